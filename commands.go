@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+configCommand() recognizes the 'config' subcommands in the positional arguments.
+Anything else is treated as a list of sources and a destination.
+*/
+func configCommand(args []string) (string, bool) {
+	if len(args) == 2 && args[0] == "config" {
+		switch args[1] {
+		case "validate", "dump":
+			return args[1], true
+		}
+	}
+	return "", false
+}
+
+/*
+runConfigCommand() runs a 'config' subcommand, writing its output to out. Returns
+the exit status for the process.
+*/
+func runConfigCommand(cfg *config.Config, command string, out io.Writer) int {
+	switch command {
+	case "validate":
+		return validateConfig(cfg, out)
+	case "dump":
+		return dumpConfig(cfg, out, cfg.GetBool("effective"))
+	}
+
+	fmt.Fprintf(out, "unknown config command '%s'\n", command)
+	return 2
+}
+
+/*
+validateConfig() loads and processes the configuration, reporting every problem
+found rather than stopping at the first.
+*/
+func validateConfig(cfg *config.Config, out io.Writer) int {
+	if status := readCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	if err := cfg.ProcessConfiguration(); err != nil {
+		errs := config.Errors(err)
+		for _, e := range errs {
+			fmt.Fprintln(out, e)
+		}
+		fmt.Fprintf(out, "found %d problem(s) in configuration\n", len(errs))
+		return 1
+	}
+
+	fmt.Fprintf(out, "configuration is valid: %s\n", strings.Join(cfg.LayerNames(), ", "))
+	return 0
+}
+
+/*
+dumpConfig() writes the loaded configuration out as YAML. Each layer is shown on
+its own, in the order they're applied, unless effective is set, in which case the
+result of merging them (and any flags) is shown instead.
+*/
+func dumpConfig(cfg *config.Config, out io.Writer, effective bool) int {
+	if status := readCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	if effective {
+		fmt.Fprintf(out, "# effective configuration from: %s\n", strings.Join(cfg.LayerNames(), ", "))
+		return writeYAML(out, cfg.EffectiveSettings())
+	}
+
+	for idx, layer := range cfg.Layers {
+		if idx > 0 {
+			fmt.Fprintln(out, "---")
+		}
+		fmt.Fprintf(out, "# from: %s\n", layer.Name)
+		if status := writeYAML(out, layer.Settings); status != 0 {
+			return status
+		}
+	}
+	return 0
+}
+
+/*
+readCommandConfig() reads the configuration for a subcommand, writing out why if it can't
+be. Returns the exit status for the process, which is 0 if it was read.
+*/
+func readCommandConfig(cfg *config.Config, out io.Writer) int {
+	loaded, err := cfg.ReadConfiguration()
+	if !loaded {
+		fmt.Fprintf(out, "configuration could not be loaded. reason: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+/*
+loadCommandConfig() reads and processes the configuration for a subcommand, writing out
+its problems if it has any. Returns the exit status for the process, which is 0 if the
+configuration can be used.
+*/
+func loadCommandConfig(cfg *config.Config, out io.Writer) int {
+	if status := readCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	if err := cfg.ProcessConfiguration(); err != nil {
+		for _, e := range config.Errors(err) {
+			fmt.Fprintln(out, e)
+		}
+		fmt.Fprintln(out, "configuration has problems. run 'config validate' for details")
+		return 1
+	}
+	return 0
+}
+
+func writeYAML(out io.Writer, settings map[string]interface{}) int {
+	contents, err := yaml.Marshal(settings)
+	if err != nil {
+		fmt.Fprintf(out, "configuration could not be written. reason: %s\n", err)
+		return 1
+	}
+
+	out.Write(contents)
+	return 0
+}
@@ -0,0 +1,237 @@
+/*
+Package catalog keeps a record of filed media in a SQLite database, so the library can be
+searched by what's known about each file without reading its metadata again.
+*/
+package catalog
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+/*
+schemaVersion is stored in the database's user_version, so later versions of the schema can
+tell what they're upgrading from.
+*/
+const schemaVersion = 1
+
+const schema = `
+CREATE TABLE IF NOT EXISTS files (
+	path           TEXT PRIMARY KEY,
+	original_name  TEXT NOT NULL,
+	source_path    TEXT NOT NULL,
+	size           INTEGER NOT NULL,
+	hash           TEXT NOT NULL,
+	hash_algorithm TEXT NOT NULL,
+	taken          TEXT NOT NULL,
+	timestamp_tag  TEXT NOT NULL,
+	mime_type      TEXT NOT NULL,
+	model          TEXT NOT NULL,
+	camera_serial  TEXT NOT NULL,
+	lens_serial    TEXT NOT NULL,
+	latitude       REAL,
+	longitude      REAL,
+	filed_at       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS files_taken ON files (taken);
+CREATE INDEX IF NOT EXISTS files_camera_serial ON files (camera_serial COLLATE NOCASE);
+CREATE INDEX IF NOT EXISTS files_hash ON files (hash);
+`
+
+/*
+timeLayout is how times are stored: in UTC, so they sort and compare as text.
+*/
+const timeLayout = "2006-01-02T15:04:05.000Z"
+
+/*
+Entry is what the catalog holds about a filed file. Path is where it was filed to, and
+SourcePath is where it was filed from, relative to its source directory. Taken is zero
+if the time a file was taken isn't known. Latitude and Longitude are only meaningful when
+HasPosition is set.
+*/
+type Entry struct {
+	Path          string
+	OriginalName  string
+	SourcePath    string
+	Size          int64
+	Hash          string
+	HashAlgorithm string
+	Taken         time.Time
+	TimestampTag  string
+	MIMEType      string
+	Model         string
+	CameraSerial  string
+	LensSerial    string
+	HasPosition   bool
+	Latitude      float64
+	Longitude     float64
+	FiledAt       time.Time
+}
+
+/*
+Query picks entries from the catalog. Empty fields match everything. Model and CameraSerial
+are matched case-insensitively, and Year is the year a file was taken, in UTC like the
+dates in file names.
+*/
+type Query struct {
+	CameraSerial string
+	Model        string
+	MIMEType     string
+	TimestampTag string
+	Year         int
+}
+
+/*
+Catalog is an open catalog database.
+*/
+type Catalog struct {
+	db *sql.DB
+}
+
+/*
+Path returns where the catalog given by the 'catalog' setting is kept, below destRootDir
+if the setting is relative. Empty if the setting isn't set.
+*/
+func Path(setting string, destRootDir string) string {
+	if setting != "" && !filepath.IsAbs(setting) {
+		return filepath.Join(destRootDir, setting)
+	}
+	return setting
+}
+
+/*
+Open opens the catalog at path, creating it if it doesn't exist yet.
+*/
+func Open(path string) (*Catalog, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single connection keeps writes from different goroutines from locking each other out
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("'%s' isn't a catalog. %s", path, err)
+	}
+	if version > schemaVersion {
+		db.Close()
+		return nil, fmt.Errorf("'%s' was written by a newer version of mediafiler (schema %d)", path, version)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't set up the catalog at '%s'. %s", path, err)
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", schemaVersion)); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Catalog{db: db}, nil
+}
+
+func (c *Catalog) Close() error {
+	return c.db.Close()
+}
+
+/*
+Put adds a file to the catalog, replacing what was held for its path before.
+*/
+func (c *Catalog) Put(entry Entry) error {
+	var latitude, longitude sql.NullFloat64
+	if entry.HasPosition {
+		latitude = sql.NullFloat64{Float64: entry.Latitude, Valid: true}
+		longitude = sql.NullFloat64{Float64: entry.Longitude, Valid: true}
+	}
+
+	// an unknown time is stored empty, so it isn't found by year
+	var taken string
+	if !entry.Taken.IsZero() {
+		taken = entry.Taken.UTC().Format(timeLayout)
+	}
+
+	_, err := c.db.Exec(`INSERT OR REPLACE INTO files (path, original_name, source_path, size, hash, hash_algorithm, taken, timestamp_tag,
+		mime_type, model, camera_serial, lens_serial, latitude, longitude, filed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Path, entry.OriginalName, entry.SourcePath, entry.Size, entry.Hash, entry.HashAlgorithm, taken,
+		entry.TimestampTag, entry.MIMEType, entry.Model, entry.CameraSerial, entry.LensSerial, latitude, longitude,
+		entry.FiledAt.UTC().Format(timeLayout))
+	return err
+}
+
+/*
+Find returns the entries matching query, oldest first.
+*/
+func (c *Catalog) Find(query Query) ([]Entry, error) {
+	var where []string
+	var args []interface{}
+
+	match := func(condition string, value interface{}) {
+		where = append(where, condition)
+		args = append(args, value)
+	}
+
+	if query.CameraSerial != "" {
+		match("camera_serial = ? COLLATE NOCASE", query.CameraSerial)
+	}
+	if query.Model != "" {
+		match("model = ? COLLATE NOCASE", query.Model)
+	}
+	if query.MIMEType != "" {
+		// a bare type like 'video' matches all of its subtypes
+		if strings.Contains(query.MIMEType, "/") {
+			match("mime_type = ?", query.MIMEType)
+		} else {
+			match("mime_type LIKE ?", query.MIMEType+"/%")
+		}
+	}
+	if query.TimestampTag != "" {
+		match("timestamp_tag = ?", query.TimestampTag)
+	}
+	if query.Year != 0 {
+		match("substr(taken, 1, 4) = ?", fmt.Sprintf("%04d", query.Year))
+	}
+
+	statement := `SELECT path, original_name, source_path, size, hash, hash_algorithm, taken, timestamp_tag, mime_type, model,
+		camera_serial, lens_serial, latitude, longitude, filed_at FROM files`
+	if len(where) > 0 {
+		statement += " WHERE " + strings.Join(where, " AND ")
+	}
+	statement += " ORDER BY taken, path"
+
+	rows, err := c.db.Query(statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []Entry
+	for rows.Next() {
+		var entry Entry
+		var taken, filedAt string
+		var latitude, longitude sql.NullFloat64
+
+		if err := rows.Scan(&entry.Path, &entry.OriginalName, &entry.SourcePath, &entry.Size, &entry.Hash, &entry.HashAlgorithm, &taken,
+			&entry.TimestampTag, &entry.MIMEType, &entry.Model, &entry.CameraSerial, &entry.LensSerial, &latitude, &longitude, &filedAt); err != nil {
+			return nil, err
+		}
+
+		if taken != "" {
+			entry.Taken, _ = time.Parse(timeLayout, taken)
+		}
+		entry.FiledAt, _ = time.Parse(timeLayout, filedAt)
+		if latitude.Valid && longitude.Valid {
+			entry.HasPosition, entry.Latitude, entry.Longitude = true, latitude.Float64, longitude.Float64
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
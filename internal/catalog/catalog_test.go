@@ -0,0 +1,131 @@
+package catalog
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testEntries() []Entry {
+	return []Entry{
+		{Path: "/lib/a.jpg", OriginalName: "IMG_0001.JPG", SourcePath: "DCIM/IMG_0001.JPG", Size: 10, Hash: "aa", HashAlgorithm: "sha256",
+			Taken: time.Date(2023, 6, 15, 10, 11, 12, 123e6, time.UTC), TimestampTag: "DateTimeOriginal", MIMEType: "image/jpeg",
+			Model: "Canon EOS Rebel T7i", CameraSerial: "012345ABC", HasPosition: true, Latitude: 48.8566, Longitude: 2.3522},
+		{Path: "/lib/b.mp4", OriginalName: "MVI_0002.MP4", SourcePath: "DCIM/MVI_0002.MP4", Size: 20, Hash: "bb", HashAlgorithm: "sha256",
+			Taken: time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC), TimestampTag: "CreateDate", MIMEType: "video/mp4",
+			Model: "Canon EOS Rebel T7i", CameraSerial: "012345ABC"},
+		{Path: "/lib/c.jpg", OriginalName: "PXL_1.jpg", SourcePath: "PXL_1.jpg", Size: 30, Hash: "cc", HashAlgorithm: "sha256",
+			Taken: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), TimestampTag: "GPSDateTime", MIMEType: "image/jpeg", Model: "Pixel 7"},
+	}
+}
+
+/*
+This test verifies that entries are stored, replaced by path and found by each kind of query
+*/
+func TestCatalog_Find(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catalog.db")
+
+	cat, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range testEntries() {
+		if err := cat.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// filing the same path again replaces what was held for it
+	replaced := testEntries()[2]
+	replaced.Hash = "dd"
+	if err := cat.Put(replaced); err != nil {
+		t.Fatal(err)
+	}
+	cat.Close()
+
+	// entries outlive the process that added them
+	if cat, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer cat.Close()
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"everything", Query{}, []string{"/lib/a.jpg", "/lib/b.mp4", "/lib/c.jpg"}},
+		{"serial in a year", Query{CameraSerial: "012345abc", Year: 2023}, []string{"/lib/a.jpg", "/lib/b.mp4"}},
+		{"timestamp tag", Query{TimestampTag: "GPSDateTime"}, []string{"/lib/c.jpg"}},
+		{"model", Query{Model: "pixel 7"}, []string{"/lib/c.jpg"}},
+		{"MIME type", Query{MIMEType: "image/jpeg", Year: 2023}, []string{"/lib/a.jpg"}},
+		{"bare MIME type", Query{MIMEType: "video"}, []string{"/lib/b.mp4"}},
+		{"nothing", Query{CameraSerial: "012345ABC", Year: 2024}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := cat.Find(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, entry := range entries {
+				got = append(got, entry.Path)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Find(%+v) = %v, want %v", tt.query, got, tt.want)
+			}
+			for idx := range got {
+				if got[idx] != tt.want[idx] {
+					t.Fatalf("Find(%+v) = %v, want %v", tt.query, got, tt.want)
+				}
+			}
+		})
+	}
+
+	entries, _ := cat.Find(Query{})
+	want := testEntries()
+	want[2].Hash = "dd"
+	for idx, entry := range entries {
+		entry.FiledAt, want[idx].FiledAt = time.Time{}, time.Time{}
+		if entry != want[idx] {
+			t.Errorf("Find() entry %d = %+v, want %+v", idx, entry, want[idx])
+		}
+	}
+}
+
+/*
+This test verifies that catalogs written by a newer schema, and files that aren't catalogs,
+are refused
+*/
+func TestOpen_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	newer := filepath.Join(dir, "newer.db")
+	db, err := sql.Open("sqlite", newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if cat, err := Open(newer); err == nil {
+		cat.Close()
+		t.Error("Open() accepted a catalog with a newer schema")
+	}
+
+	text := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(text, []byte("these are some notes, not a database. they're long enough to hold a header."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cat, err := Open(text); err == nil {
+		cat.Close()
+		t.Error("Open() accepted a file that isn't a catalog")
+	}
+}
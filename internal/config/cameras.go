@@ -0,0 +1,64 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+/*
+CameraSerialTags are the metadata tags that can hold a camera body's serial number,
+in the order they're checked.
+*/
+var CameraSerialTags = []string{"SerialNumber", "InternalSerialNumber", "BodySerialNumber"}
+
+/*
+CameraAliases maps camera serial numbers to the names they've been given in the
+'cameras' configuration. Serial numbers are matched case-insensitively.
+*/
+type CameraAliases map[string]string
+
+/*
+AddAlias() gives the camera with a serial number a name. The name is used in file
+names, so it can't be empty or contain path separators.
+*/
+func (c *CameraAliases) AddAlias(serial string, name string) error {
+	switch {
+	case strings.TrimSpace(serial) == "":
+		return errors.New("the serial number cannot be empty")
+	case name == "":
+		return errors.New("the camera name cannot be empty")
+	case strings.ContainsAny(name, `/\`):
+		return errors.New("the camera name cannot contain '/' or '\\'")
+	}
+
+	if *c == nil {
+		*c = make(CameraAliases)
+	}
+	(*c)[normalizeSerial(serial)] = name
+	return nil
+}
+
+/*
+Name() returns the name given to the camera with a serial number, if it has one.
+*/
+func (c CameraAliases) Name(serial string) (string, bool) {
+	name, ok := c[normalizeSerial(serial)]
+	return name, ok
+}
+
+/*
+Match() returns the name given to the first of serials that has one, along with that serial
+number. ok is false if none of them have a name.
+*/
+func (c CameraAliases) Match(serials []string) (name string, serial string, ok bool) {
+	for _, serial := range serials {
+		if name, ok := c.Name(serial); ok {
+			return name, serial, true
+		}
+	}
+	return "", "", false
+}
+
+func normalizeSerial(serial string) string {
+	return strings.ToLower(strings.TrimSpace(serial))
+}
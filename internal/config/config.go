@@ -4,142 +4,204 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"reflect"
 
-	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
 	"github.com/hashicorp/go-multierror"
 	"github.com/spf13/pflag"
 
 	"github.com/spf13/viper"
 )
 
-var Config viper.Viper
-var FS *pflag.FlagSet
-
-var ModelReplacer strmanip.Replacer
-var PathIgnorer PathIgnoreFilter
+/*
+ErrDefaultConfigUsed is returned by ReadConfiguration() along with a loaded configuration
+when no configuration file was found and the default configuration was used instead.
+*/
+var ErrDefaultConfigUsed = errors.New("the default configuration was used")
 
-var DEFAULT_CONFIG_USED string
+const DEFAULT_CONFIG_SOURCE string = "default configuration"
 
 /*
-Initialize() sets up the config reader and the associate flag sets
-for reading in command line arguments.
+Config holds a mediafiler configuration: the settings merged from command line
+flags, configuration files and the environment, the layers they were read from, and
+the rules built from them. Settings are read through the embedded viper reader,
+e.g. cfg.GetBool("dry-run").
 
-args []string -  typically will be os.Args[1:], but is also
-useful for passing in test data for unit tests.
+A Config isn't safe for concurrent use while it's being loaded or reloaded.
 */
-func Initialize(args []string) {
-	Config = *viper.New()
+type Config struct {
+	*viper.Viper
+	Rules
 
-	DEFAULT_CONFIG_USED = "the default configuration was used"
+	FS          *pflag.FlagSet
+	Layers      []Layer
+	configPaths []string
+}
 
-	Config.SetConfigName("mediafiler")
-	Config.SetConfigType("yaml")
+/*
+New() sets up a configuration and the flag set for reading in command line
+arguments. The configuration still needs to be read and processed.
 
-	FS = pflag.NewFlagSet("mediafiler", pflag.ContinueOnError)
-	FS.Bool("dry-run", false, "run in dry-run mode where actions are displayed but not executed")
-	FS.Bool("debug", false, "increase logging verbosity to debug level")
-	FS.Bool("dump-example-config", false, "dump example configuration file to standard output")
-	FS.Bool("use-default-config", false, "use the default/example configuration if a config file cannot"+
+args []string -  typically will be os.Args[1:], but is also
+useful for passing in test data for unit tests.
+
+The error from parsing the arguments is returned along with the configuration, so
+callers can show usage information for pflag.ErrHelp.
+*/
+func New(args []string) (*Config, error) {
+	c := &Config{Rules: Rules{Cameras: CameraAliases{}}}
+
+	c.FS = pflag.NewFlagSet("mediafiler", pflag.ContinueOnError)
+	c.FS.Bool("dry-run", false, "run in dry-run mode where actions are displayed but not executed")
+	c.FS.Bool("debug", false, "increase logging verbosity to debug level")
+	c.FS.Bool("dump-example-config", false, "dump example configuration file to standard output")
+	c.FS.Bool("use-default-config", false, "use the default/example configuration if a config file cannot"+
 		" be found via search paths. if a config file is specified via the 'config-file' argument but"+
 		" not found, this flag will have no effect.")
+	c.FS.Bool("cleanup-empty-dirs", false, "remove directories in the source directory that are left empty (or only"+
+		" contain junk files) after filing")
+	c.FS.Bool("wait", false, "wait for another mediafiler process to release its lock on the destination directory"+
+		" instead of exiting")
+
+	c.FS.String("config-file", "", "path to mediafiler configuration file. ")
+	c.FS.String("exiftool-binary", "", "path to exiftool binary")
+	c.FS.String("from-file", "", "read NUL-separated source paths (e.g. from 'find -print0') from a file, or from"+
+		" standard input if '-'")
+
+	c.FS.Bool("effective", false, "with 'config dump', show the configuration that results from merging all layers")
+	c.FS.String("model", "", "with 'rules test', a camera model to run through the model replace rules. with 'query', the"+
+		" camera model to find files from")
+	c.FS.String("path", "", "with 'rules test', a path to check against the path ignore patterns")
+	c.FS.String("exif-json", "", "with 'rules test', a file holding exiftool -json output to work out destinations for")
+	c.FS.String("serial", "", "with 'query', the camera serial number to find files from")
+	c.FS.Int("year", 0, "with 'query', the year (in UTC) to find files taken in")
+	c.FS.String("timestamp-tag", "", "with 'query', the tag to find files whose timestamp was taken from, e.g. GPSDateTime")
+	c.FS.String("mime-type", "", "with 'query', the MIME type (e.g. image/jpeg) or type (e.g. video) to find files of")
+
+	err := c.FS.Parse(args)
+	c.Viper = c.newReader()
+
+	return c, err
+}
 
-	FS.String("config-file", "", "path to mediafiler configuration file. ")
-	FS.String("exiftool-binary", "", "path to exiftool binary")
+/*
+Usage() writes out how mediafiler is run, to follow the flag descriptions printed
+for --help.
+*/
+func Usage(out io.Writer) {
+	fmt.Fprintf(out, "\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] source [source ...] destDir\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] config validate\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] config dump [--effective]\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] rules test [--model model] [--path path] [--exif-json file] [destDir]\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] lookup originalName destDir\n")
+	fmt.Fprintf(out, "#mediafiler [optional flags] query [--serial serial] [--model model] [--year year] [--timestamp-tag tag] [--mime-type type] destDir\n")
+	fmt.Fprintf(out, "\n")
+	fmt.Fprintf(out, "Sources can be files or directories. At least one source is required, unless\n")
+	fmt.Fprintf(out, "sources are read with --from-file. The last argument is always destDir.\n")
+	fmt.Fprintf(out, "\n")
+}
 
-	err := FS.Parse(args)
-	Config.BindPFlags(FS)
+/*
+newReader() returns a config reader with the command line flags bound to it.
+*/
+func (c *Config) newReader() *viper.Viper {
+	v := viper.New()
+	v.SetConfigName("mediafiler")
+	v.SetConfigType("yaml")
+	v.BindPFlags(c.FS)
 
-	// exit if -h or --help is found
-	if err == pflag.ErrHelp {
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "#mediafiler [optional flags] sourceDir destDir\n")
-		fmt.Fprintf(os.Stderr, "\n")
-		fmt.Fprintf(os.Stderr, "Both sourceDir and destDir arguments are required\n")
-		fmt.Fprintf(os.Stderr, "\n")
-		os.Exit(0)
-	}
+	// settings that can only be given as flags can also come from the environment
+	v.BindEnv("config-file", EnvPrefix+"CONFIG_FILE")
+	v.BindEnv("use-default-config", EnvPrefix+"USE_DEFAULT_CONFIG")
 
+	return v
 }
 
 /*
-ProcessFatalFlags() handles any flags that would result in the process exiting intentionally
-before normal output or function begins
+ExampleConfigYAML() returns the default/example configuration file.
 */
-func ProcessFatalFlags() {
-	if ok, _ := FS.GetBool("dump-example-config"); ok {
-		fmt.Print(string(defaultConfigYAML))
-		os.Exit(0)
-	}
+func ExampleConfigYAML() []byte {
+	return defaultConfigYAML
 }
 
 /*
 UseDefaultConfigPaths() configures the config reader to look in the
-default paths for the application.
+default paths for the application. A configuration file in the user's
+directory is layered on top of the system-wide one.
 */
-func UseDefaultConfigPaths() {
-	Config.AddConfigPath("$HOME/.config/mediafiler")
-	Config.AddConfigPath("/etc/mediafiler/")
+func (c *Config) UseDefaultConfigPaths() {
+	c.configPaths = append(c.configPaths, "$HOME/.config/mediafiler", "/etc/mediafiler/")
 }
 
 /*
 useSpecificConfigPath() configures the config reader to look in specific
-paths. Intended for use in test cases only.
+paths. Paths added first take precedence. Intended for use in test cases only.
 */
-func useSpecificConfigPath(path string) {
-	Config.AddConfigPath(path)
-}
-
-/*
-useSpecificConfigFile() tells the configuration reader to use a specific
-config file path instead of searching.
-*/
-func useSpecificConfigFile(path string) {
-	Config.SetConfigFile(path)
+func (c *Config) useSpecificConfigPath(path string) {
+	c.configPaths = append(c.configPaths, path)
 }
 
 /*
 ReadConfiguration() attempts to load in the configuration using previously
-configured path/file settings.
+configured path/file settings. Every configuration file found in the search
+paths is loaded, from the lowest precedence to the highest, followed by the file
+given with 'config-file' and then MEDIAFILER_* environment variables.
 
 Returns:
 0: bool - true if a configuration was loaded, false otherwise
-1: error - returns information on failure cases. returns contents DEFAULT_CONFIG_USED if default values were loaded if "use-default-config" is specified by the user
+1: error - returns information on failure cases. returns ErrDefaultConfigUsed if default values were loaded if "use-default-config" is specified by the user
 */
-func ReadConfiguration() (bool, error) {
-	config_file_specified := false
-
-	if Config.IsSet("config-file") {
-		file := Config.GetString("config-file")
-		if file != "" {
-			config_file_specified = true
-			useSpecificConfigFile(file)
-		} else {
+func (c *Config) ReadConfiguration() (bool, error) {
+	files, err := findConfigFiles(c.configPaths)
+	if err != nil {
+		return false, fmt.Errorf("an error occurred while loading configuration: %s", err)
+	}
+
+	if c.IsSet("config-file") {
+		file := c.GetString("config-file")
+		if file == "" {
 			return false, errors.New("config file path specified via command line arguments is somehow empty")
 		}
+		files = append(files, file)
 	}
 
-	if err := Config.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// if the user specifies a specific config file that doesn't exist, don't
-			// apply default config even if they ask for it
-			if Config.GetBool("use-default-config") && !config_file_specified {
-
-				err = ApplyDefaultConfiguration()
-				if err == nil {
-					return true, errors.New(DEFAULT_CONFIG_USED)
-				} else {
-					return false, fmt.Errorf("attempt to load defaults resulted in error: %s", err)
-				}
-			} else {
-				return false, errors.New("config file could not be found using configured paths/files")
+	env := envLayer(os.Environ())
+
+	var defaultErr error
+	if len(files) == 0 {
+		if c.GetBool("use-default-config") {
+			if err := c.ApplyDefaultConfiguration(); err != nil {
+				return false, fmt.Errorf("attempt to load defaults resulted in error: %s", err)
 			}
+			defaultErr = ErrDefaultConfigUsed
+		} else if len(env.Settings) == 0 {
+			return false, errors.New("config file could not be found using configured paths/files")
+		}
+	}
 
-		} else {
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
 			return false, fmt.Errorf("an error occurred while loading configuration: %s", err)
 		}
+
+		if err = c.addLayerYAML(file, contents); err != nil {
+			return false, fmt.Errorf("an error occurred while loading configuration from '%s': %s", file, err)
+		}
+		c.SetConfigFile(file)
+	}
+
+	if len(env.Settings) > 0 {
+		if err := c.MergeConfigMap(env.Settings); err != nil {
+			return false, fmt.Errorf("an error occurred while loading configuration from the environment: %s", err)
+		}
+		c.Layers = append(c.Layers, env)
 	}
-	return true, nil
+
+	return true, defaultErr
 }
 
 /*
@@ -147,51 +209,218 @@ ApplyDefaultConfiguration() loads in the default/example configuration from defa
 
 Returns the error value from the ReadConfig operation.
 */
-func ApplyDefaultConfiguration() error {
-	return Config.ReadConfig(bytes.NewBuffer(defaultConfigYAML))
+func (c *Config) ApplyDefaultConfiguration() error {
+	return c.applyConfigurationYAML(DEFAULT_CONFIG_SOURCE, defaultConfigYAML)
 }
 
 /*
-ProcessConfiguration() reads the structured data from the configuration file
-and populates the Model Replacer and Path Ignore rules
+applyConfigurationYAML() loads configuration from YAML content instead of a file,
+replacing anything loaded before. name is used to refer to the content in error
+messages.
+*/
+func (c *Config) applyConfigurationYAML(name string, contents []byte) error {
+	c.Layers = nil
+	return c.addLayerYAML(name, contents)
+}
 
-returns an error object to indicate success or describe failure
+/*
+addLayerYAML() layers configuration from YAML content on top of what's already
+been loaded.
 */
+func (c *Config) addLayerYAML(name string, contents []byte) error {
+	layer, err := readLayer(name, contents)
+	if err != nil {
+		return err
+	}
 
-func ProcessConfiguration() error {
+	if err = c.MergeConfig(bytes.NewBuffer(contents)); err != nil {
+		return err
+	}
 
-	// set these to new empty objects so it's safe to use repeatedly in tests
-	ModelReplacer = strmanip.Replacer{}
-	PathIgnorer = PathIgnoreFilter{}
-	var err error
+	c.Layers = append(c.Layers, layer)
+	return nil
+}
+
+/*
+readLayer() parses YAML configuration content on its own, without any of the flags
+or other settings bound to Config.
+*/
+func readLayer(name string, contents []byte) (Layer, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+
+	if err := v.ReadConfig(bytes.NewBuffer(contents)); err != nil {
+		return Layer{}, err
+	}
+
+	settings := v.AllSettings()
+	if err := readLiteralSettings(contents, settings); err != nil {
+		return Layer{}, err
+	}
+
+	return Layer{Name: name, Settings: settings}, nil
+}
+
+/*
+ProcessConfiguration() decodes the structured data from the loaded configuration
+and populates the Model Replacer, Path Ignore and Media Type rules
+
+returns an error object to indicate success or describe failure. Every problem
+found is included as a *ValidationError, which can be listed with Errors().
+*/
+func (c *Config) ProcessConfiguration() error {
+	rules, err := c.buildRules()
+	c.Rules = *rules
+	return err
+}
+
+/*
+buildRules() builds the rules described by the loaded configuration. Rules with
+problems are left out, and the problems returned.
+*/
+func (c *Config) buildRules() (*Rules, error) {
+	rules := &Rules{Cameras: CameraAliases{}}
 	var merr error
 
-	if Config.IsSet("model-replace-rules") {
-		mrr := Config.Get("model-replace-rules").([]interface{})
-		for _, mrrv := range mrr {
-			vv := mrrv.(map[string]interface{})
-			vvv := strmanip.ReplacerRule{Type: vv["replace_type"].(string), Find: vv["find_pattern"].(string), ReplaceWith: vv["replace_with"].(string)}
+	// media types without their own path template use the top-level one
+	pathTemplate := nametmpl.DefaultPathTemplate
+	if c.IsSet("path-template") {
+		pathTemplate = c.GetString("path-template")
+	}
+
+	configs := make([]FileConfig, len(c.Layers))
+	decodeErrs := make([][]error, len(c.Layers))
+	mediaTypesConfigured := false
+
+	for idx, layer := range c.Layers {
+		decodeErrs[idx] = decodeSettings(layer.Name, layer.Settings, &configs[idx])
+		merr = appendErrors(merr, decodeErrs[idx])
+
+		if _, ok := layer.Settings["media-types"]; ok {
+			mediaTypesConfigured = true
+		}
+	}
+
+	_, merge := fileConfigKeys()
+
+	for _, l := range layerOrder(len(c.Layers), merge["model-replace-rules"]) {
+		for idx, rc := range configs[l].ModelReplaceRules {
+			location := fmt.Sprintf("model-replace-rules[%d]", idx)
+			if hasErrorsAt(decodeErrs[l], location) {
+				continue
+			}
+
+			rule := rc.ReplacerRule()
+			if valid, err := rule.IsValid(); !valid {
+				merr = appendErrors(merr, ruleErrors(c.Layers[l].Name, location, err, reflect.TypeOf(rc)))
+				continue
+			}
+			rules.ModelReplacer.AddRule(rule)
+		}
+	}
+
+	for _, l := range layerOrder(len(c.Layers), merge["path-ignore-patterns"]) {
+		for idx, pc := range configs[l].PathIgnorePatterns {
+			location := fmt.Sprintf("path-ignore-patterns[%d]", idx)
+			if hasErrorsAt(decodeErrs[l], location) {
+				continue
+			}
+
+			pattern := pc.PathIgnorePattern()
+			if valid, err := pattern.IsValid(); !valid {
+				merr = appendErrors(merr, ruleErrors(c.Layers[l].Name, location, err, reflect.TypeOf(pc)))
+				continue
+			}
+			rules.PathIgnorer.AddPattern(pattern)
+		}
+	}
+
+	for _, l := range layerOrder(len(c.Layers), merge["file-filters"]) {
+		for idx, fc := range configs[l].FileFilters {
+			location := fmt.Sprintf("file-filters[%d]", idx)
+			if hasErrorsAt(decodeErrs[l], location) {
+				continue
+			}
 
-			err = ModelReplacer.AddRule(vvv)
-			if err != nil {
-				merr = multierror.Append(fmt.Errorf("error adding Model Replacer rule: %s", err))
+			filter := fc.FileFilter()
+			if valid, err := filter.IsValid(); !valid {
+				merr = appendErrors(merr, ruleErrors(c.Layers[l].Name, location, err, reflect.TypeOf(fc)))
+				continue
 			}
+			rules.FileFilters.AddFilter(filter)
 		}
 	}
 
-	if Config.IsSet("path-ignore-patterns") {
-		pip := Config.Get("path-ignore-patterns").([]interface{})
-		for _, pipv := range pip {
-			vv := pipv.(map[string]interface{})
-			vvv := PathIgnorePattern{Type: vv["type"].(string), Pattern: vv["pattern"].(string)}
+	for _, l := range layerOrder(len(c.Layers), merge["media-types"]) {
+		for idx, mc := range configs[l].MediaTypes {
+			location := fmt.Sprintf("media-types[%d]", idx)
+			if hasErrorsAt(decodeErrs[l], location) {
+				continue
+			}
 
-			err = PathIgnorer.AddPattern(vvv)
+			rule := mc.MediaTypeRule(pathTemplate)
+			if valid, err := rule.IsValid(); !valid {
+				merr = appendErrors(merr, ruleErrors(c.Layers[l].Name, location, err, reflect.TypeOf(mc)))
+				continue
+			}
+			rules.MediaTypes.AddRule(rule)
+		}
+	}
 
-			if err != nil {
-				merr = multierror.Append(fmt.Errorf("error adding path ignore rule: %s", err))
+	for l, layer := range c.Layers {
+		for _, serial := range sortedKeys(layer.Settings["cameras"]) {
+			location := joinLocation("cameras", serial)
+			if hasErrorsAt(decodeErrs[l], location) {
+				continue
 			}
+
+			if err := rules.Cameras.AddAlias(serial, configs[l].Cameras[serial]); err != nil {
+				merr = multierror.Append(merr, &ValidationError{Source: layer.Name, Location: location, Err: err})
+			}
+		}
+	}
+
+	filenameTemplate := nametmpl.DefaultFilenameTemplate
+	if c.IsSet("filename-template") {
+		filenameTemplate = c.GetString("filename-template")
+	}
+
+	var err error
+	if rules.FilenameTemplate, err = nametmpl.Parse(filenameTemplate); err != nil {
+		merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("filename-template"), Location: "filename-template",
+			Err: fmt.Errorf("filename template is not valid. reason: %s", err)})
+	}
+
+	events, eventErr := c.buildEventRules()
+	rules.Events = events
+	if eventErr != nil {
+		merr = multierror.Append(merr, eventErr)
+	}
+
+	nearDuplicates, nearDuplicateErr := c.buildNearDuplicateRules()
+	rules.NearDuplicates = nearDuplicates
+	if nearDuplicateErr != nil {
+		merr = multierror.Append(merr, nearDuplicateErr)
+	}
+
+	duplicates, duplicateErr := c.buildDuplicateRules()
+	rules.Duplicates = duplicates
+	if duplicateErr != nil {
+		merr = multierror.Append(merr, duplicateErr)
+	}
+
+	originalNames, originalNamesErr := c.buildOriginalNames()
+	rules.OriginalNames = originalNames
+	if originalNamesErr != nil {
+		merr = multierror.Append(merr, originalNamesErr)
+	}
+
+	if !mediaTypesConfigured {
+		rules.MediaTypes, err = DefaultMediaTypeRouter(pathTemplate)
+		if err != nil {
+			merr = multierror.Append(merr, fmt.Errorf("error adding default media type rules: %s", err))
 		}
 	}
 
-	return merr
+	return rules, merr
 }
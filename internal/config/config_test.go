@@ -1,11 +1,16 @@
 package config
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/d0ct0rvenkman/mediafiler/internal/filehash"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
 	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
 )
 
@@ -21,25 +26,25 @@ func Test_CommandLine_Basic(t *testing.T) {
 	t.Run("empty command line", func(t *testing.T) {
 		var args cli_args
 
-		Initialize(args)
+		cfg, _ := New(args)
 
-		if Config.GetBool("dry-run") != false {
+		if cfg.GetBool("dry-run") != false {
 			t.Error("dry-run is not false")
 		}
 
-		if Config.GetBool("debug") != false {
+		if cfg.GetBool("debug") != false {
 			t.Error("debug is not false")
 		}
 
-		if Config.GetString("config-file") != "" {
+		if cfg.GetString("config-file") != "" {
 			t.Error("config-file is not empty")
 		}
 
-		if Config.GetBool("dump-example-config") != false {
+		if cfg.GetBool("dump-example-config") != false {
 			t.Error("dump-example-config is not false")
 		}
 
-		if Config.GetString("exiftool-binary") != "" {
+		if cfg.GetString("exiftool-binary") != "" {
 			t.Error("exiftool-binary is not empty")
 		}
 	})
@@ -70,16 +75,23 @@ func Test_CommandLine_Basic(t *testing.T) {
 		{"use-default-config true explicit", cli_args{"--use-default-config=true"}, "use-default-config", true, true},
 		{"use-default-config false explicit", cli_args{"--use-default-config=false"}, "use-default-config", true, false},
 		{"use-default-config nonsense", cli_args{"--use-default-config=nonsense"}, "use-default-config", false, false},
+
+		{"cleanup-empty-dirs true implicit", cli_args{"--cleanup-empty-dirs"}, "cleanup-empty-dirs", true, true},
+		{"cleanup-empty-dirs false explicit", cli_args{"--cleanup-empty-dirs=false"}, "cleanup-empty-dirs", true, false},
+
+		{"wait true implicit", cli_args{"--wait"}, "wait", true, true},
+		{"wait false explicit", cli_args{"--wait=false"}, "wait", true, false},
+		{"wait nonsense", cli_args{"--wait=nonsense"}, "wait", false, false},
 	}
 	for _, v := range boolTests {
 		t.Run(testNameSlug+"flag_"+v.name, func(t *testing.T) {
-			Initialize(v.args)
+			cfg, _ := New(v.args)
 
-			if found := Config.IsSet(v.key); found != v.found {
+			if found := cfg.IsSet(v.key); found != v.found {
 				t.Errorf(v.key+" found %v, expected %v", found, v.found)
 			}
 
-			if got := Config.GetBool(v.key); got != v.want {
+			if got := cfg.GetBool(v.key); got != v.want {
 				t.Errorf(v.key+" is %v, wanted %v", got, v.want)
 			}
 		})
@@ -100,16 +112,20 @@ func Test_CommandLine_Basic(t *testing.T) {
 		{"exiftool-binary incomplete", cli_args{"--exiftool-binary"}, "exiftool-binary", false, ""},
 		{"exiftool-binary equals string", cli_args{"--exiftool-binary=nonsense"}, "exiftool-binary", true, "nonsense"},
 		{"exiftool-binary space string", cli_args{"--exiftool-binary", "nonsense"}, "exiftool-binary", true, "nonsense"},
+
+		{"from-file incomplete", cli_args{"--from-file"}, "from-file", false, ""},
+		{"from-file equals string", cli_args{"--from-file=-"}, "from-file", true, "-"},
+		{"from-file space string", cli_args{"--from-file", "files.txt"}, "from-file", true, "files.txt"},
 	}
 	for _, v := range stringTests {
 		t.Run(testNameSlug+"flag_"+v.name, func(t *testing.T) {
-			Initialize(v.args)
+			cfg, _ := New(v.args)
 
-			if found := Config.IsSet(v.key); found != v.found {
+			if found := cfg.IsSet(v.key); found != v.found {
 				t.Errorf(v.key+" found %v, expected %v", found, v.found)
 			}
 
-			if got := Config.GetString(v.key); got != v.want {
+			if got := cfg.GetString(v.key); got != v.want {
 				t.Errorf(v.key+" is %v, wanted %v", got, v.want)
 			}
 		})
@@ -125,13 +141,13 @@ func Test_DefaultConfigYAML(t *testing.T) {
 
 	testNameSlug := "defaultconfig-"
 
-	Initialize(args)
-	if err := ApplyDefaultConfiguration(); err != nil {
-		t.Fatalf(testNameSlug+"ApplyDefaultConfiguration() failed: reason: %s", err)
+	cfg, _ := New(args)
+	if err := cfg.ApplyDefaultConfiguration(); err != nil {
+		t.Fatalf(testNameSlug+"cfg.ApplyDefaultConfiguration() failed: reason: %s", err)
 	}
 
-	if err := ProcessConfiguration(); err != nil {
-		t.Fatalf(testNameSlug+"ProcessConfiguration() failed: reason: %s", err)
+	if err := cfg.ProcessConfiguration(); err != nil {
+		t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
 	}
 
 	var exp_model_replacer strmanip.Replacer
@@ -150,11 +166,11 @@ func Test_DefaultConfigYAML(t *testing.T) {
 	for _, v := range boolTests {
 		t.Run(testNameSlug+"flag_"+v.name, func(t *testing.T) {
 
-			if found := Config.IsSet(v.key); found != v.found {
+			if found := cfg.IsSet(v.key); found != v.found {
 				t.Errorf(v.key+" found %v, expected %v", found, v.found)
 			}
 
-			if got := Config.GetBool(v.key); got != v.want {
+			if got := cfg.GetBool(v.key); got != v.want {
 				t.Errorf(v.key+" is %v, wanted %v", got, v.want)
 			}
 		})
@@ -173,11 +189,11 @@ func Test_DefaultConfigYAML(t *testing.T) {
 	for _, v := range stringTests {
 		t.Run(testNameSlug+"flag_"+v.name, func(t *testing.T) {
 
-			if found := Config.IsSet(v.key); found != v.found {
+			if found := cfg.IsSet(v.key); found != v.found {
 				t.Errorf(v.key+" found %v, expected %v", found, v.found)
 			}
 
-			if got := Config.GetString(v.key); got != v.want {
+			if got := cfg.GetString(v.key); got != v.want {
 				t.Errorf(v.key+" is %v, wanted %v", got, v.want)
 			}
 		})
@@ -186,21 +202,20 @@ func Test_DefaultConfigYAML(t *testing.T) {
 	exp_model_replacer.AddRule(strmanip.ReplacerRule{Type: "string", Find: "FooBarMatic", ReplaceWith: "FBM"})
 	exp_model_replacer.AddRule(strmanip.ReplacerRule{Type: "regex", Find: `\s+`, ReplaceWith: ""})
 
-	exp_path_filter.AddPattern(PathIgnorePattern{Type: "string", Pattern: `.git/`})
-	exp_path_filter.AddPattern(PathIgnorePattern{Type: "string", Pattern: `.git\`})
+	exp_path_filter.AddPattern(PathIgnorePattern{Type: "gitignore", Pattern: `.git/`})
 	exp_path_filter.AddPattern(PathIgnorePattern{Type: "regex", Pattern: `^.*[Ii][Cc][Oo]$`})
 
 	t.Run(testNameSlug+"model-replacer-length", func(t *testing.T) {
 		// we can't compare these directly, so we'll compare their lengths and then their individual rules
 
-		if len(exp_model_replacer) != len(ModelReplacer) {
+		if len(exp_model_replacer) != len(cfg.ModelReplacer) {
 			t.Error("model replacer rules loaded from config are different from expected rules (rule count)")
 		}
 
 	})
 	t.Run(testNameSlug+"model-replacer-contents", func(t *testing.T) {
 		for compare_idx := range exp_model_replacer {
-			if exp_model_replacer[compare_idx] != ModelReplacer[compare_idx] {
+			if !exp_model_replacer[compare_idx].Equal(cfg.ModelReplacer[compare_idx]) {
 				t.Error("model replacer rules loaded from config are different from expected rules (specifc rule)")
 
 			}
@@ -208,48 +223,197 @@ func Test_DefaultConfigYAML(t *testing.T) {
 	})
 
 	// we can't compare these directly, so we'll compare their lengths and then their individual patterns
-	if len(exp_path_filter) != len(PathIgnorer) {
+	if len(exp_path_filter) != len(cfg.PathIgnorer) {
 		t.Error("path filter patterns loaded from config are different from expected patterns (rule count)")
 	}
 
 	for compare_idx := range exp_path_filter {
-		if exp_path_filter[compare_idx] != PathIgnorer[compare_idx] {
+		if !exp_path_filter[compare_idx].Equal(cfg.PathIgnorer[compare_idx]) {
 			t.Error("path filter patterns loaded from config are different from expected patterns (specifc rule)")
 
 		}
 	}
 
+	t.Run(testNameSlug+"media-types", func(t *testing.T) {
+		want := []string{"image", "video"}
+
+		if len(cfg.MediaTypes) != len(want) {
+			t.Fatalf("media type rules loaded from config are different from expected rules (rule count)")
+		}
+
+		for idx, mime := range want {
+			if cfg.MediaTypes[idx].MIME != mime || cfg.MediaTypes[idx].PathTemplate != nametmpl.DefaultPathTemplate || cfg.MediaTypes[idx].DestRoot != "" {
+				t.Errorf("media type rule %d is '%v', expected '%s' with the default path template", idx, cfg.MediaTypes[idx], mime)
+			}
+		}
+	})
+
 }
 
 /*
-This test ensures that the 'correct' configuration (e.g. the first found) is loaded from a set of multiple
-search directories.
+This test verifies that media type rules are loaded from configuration, falling back to the
+top-level path template, and that images and videos are filed when no media types are configured.
+*/
+func Test_MediaTypesConfig(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "mediatypes-"
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+path-template: "{{.Year}}/{{.Month}}"
+media-types:
+- mime: "image"
+- mime: "audio"
+  dest_root: "/srv/audio"
+- mime: "application/pdf"
+  dest_root: "scans"
+  path_template: "{{.Year}}"
+`)
+		if err := cfg.applyConfigurationYAML(testNameSlug+"yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := []MediaTypeRule{
+			{MIME: "image", PathTemplate: "{{.Year}}/{{.Month}}"},
+			{MIME: "audio", DestRoot: "/srv/audio", PathTemplate: "{{.Year}}/{{.Month}}"},
+			{MIME: "application/pdf", DestRoot: "scans", PathTemplate: "{{.Year}}"},
+		}
+
+		if len(cfg.MediaTypes) != len(want) {
+			t.Fatalf("media type rule count is %d, expected %d", len(cfg.MediaTypes), len(want))
+		}
+
+		for idx := range want {
+			got := cfg.MediaTypes[idx]
+			if got.MIME != want[idx].MIME || got.DestRoot != want[idx].DestRoot || got.PathTemplate != want[idx].PathTemplate {
+				t.Errorf("media type rule %d is '%v', expected '%v'", idx, got, want[idx])
+			}
+		}
+	})
+
+	t.Run(testNameSlug+"unconfigured", func(t *testing.T) {
+		cfg, _ := New(args)
+
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		if _, ok := cfg.MediaTypes.Match("image", "jpeg"); !ok {
+			t.Error("images are not filed by default")
+		}
+
+		if _, ok := cfg.MediaTypes.Match("video", "mp4"); !ok {
+			t.Error("videos are not filed by default")
+		}
+
+		if _, ok := cfg.MediaTypes.Match("audio", "mpeg"); ok {
+			t.Error("audio is filed by default")
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+media-types:
+- mime: "image/"
+`)
+		if err := cfg.applyConfigurationYAML(testNameSlug+"yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		if err := cfg.ProcessConfiguration(); err == nil {
+			t.Error(testNameSlug + "cfg.ProcessConfiguration() succeeded when it should have failed")
+		}
+	})
+}
+
+/*
+This test ensures that every problem in the configuration is reported, along with where it was found.
+*/
+func Test_ProcessConfiguration_Errors(t *testing.T) {
+	var args cli_args
+
+	cfg, _ := New(args)
+	yaml := []byte(`
+model-replace-rules:
+- replace_type: "regex"
+  find_pattern: "(Canon"
+- replace_type: "string"
+path-ignore-patterns:
+- type: "wildcard"
+  pattern: "*.txt"
+media-types:
+- mime: "image/"
+`)
+	if err := cfg.applyConfigurationYAML("errors.yaml", yaml); err != nil {
+		t.Fatalf("cfg.applyConfigurationYAML() failed: reason: %s", err)
+	}
+
+	err := cfg.ProcessConfiguration()
+	if err == nil {
+		t.Fatal("cfg.ProcessConfiguration() succeeded when it should have failed")
+	}
+
+	wantLocations := []string{
+		"model-replace-rules[1].find_pattern",
+		"model-replace-rules[0].find_pattern",
+		"path-ignore-patterns[0].type",
+		"media-types[0].mime",
+	}
+
+	errs := Errors(err)
+	if len(errs) != len(wantLocations) {
+		t.Fatalf("cfg.ProcessConfiguration() returned %d errors %v, expected %d", len(errs), errs, len(wantLocations))
+	}
+
+	for idx, e := range errs {
+		verr, ok := e.(*ValidationError)
+		if !ok {
+			t.Errorf("error %d '%s' is not a *ValidationError", idx, e)
+			continue
+		}
+
+		if verr.Source != "errors.yaml" || verr.Location != wantLocations[idx] {
+			t.Errorf("error %d is at '%s: %s', expected 'errors.yaml: %s'", idx, verr.Source, verr.Location, wantLocations[idx])
+		}
+	}
+}
+
+/*
+This test ensures that the 'correct' configuration (e.g. the first found) takes precedence when layered
+from a set of multiple search directories.
 */
 func Test_PathSearch_1(t *testing.T) {
 	var args cli_args
 
 	testNameSlug := "pathsearch1-"
 
-	Initialize(args)
-	useSpecificConfigPath("../../test/config/path1")
-	useSpecificConfigPath("../../test/config/path2")
-	useSpecificConfigPath("../../test/config/path3")
+	cfg, _ := New(args)
+	cfg.useSpecificConfigPath("../../test/config/path1")
+	cfg.useSpecificConfigPath("../../test/config/path2")
+	cfg.useSpecificConfigPath("../../test/config/path3")
 
 	t.Run(testNameSlug+"readconfiguration", func(t *testing.T) {
-		cfgRead, err := ReadConfiguration()
+		cfgRead, err := cfg.ReadConfiguration()
 		if !cfgRead || (err != nil) {
-			t.Fatalf(testNameSlug+"ReadConfiguration() failed: reason: %s", err)
+			t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"processconfiguration", func(t *testing.T) {
-		if err := ProcessConfiguration(); err != nil {
-			t.Fatalf(testNameSlug+"ProcessConfiguration() failed: reason: %s", err)
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"configfileused", func(t *testing.T) {
-		pathparts := strings.Split(Config.ConfigFileUsed(), string(os.PathSeparator))
+		pathparts := strings.Split(cfg.ConfigFileUsed(), string(os.PathSeparator))
 
 		// only look at the last parts of the file path that exist within this source repo
 		subpath := pathparts[len(pathparts)-4:]
@@ -262,55 +426,62 @@ func Test_PathSearch_1(t *testing.T) {
 	})
 
 	t.Run(testNameSlug+"dry-run", func(t *testing.T) {
-		if !(Config.IsSet("dry-run") && Config.GetBool("dry-run")) {
+		if !(cfg.IsSet("dry-run") && cfg.GetBool("dry-run")) {
 			t.Errorf("dry-run setting is not as expected (explicit true)")
 		}
 	})
 
+	// settings from files with lower precedence are still used
 	t.Run(testNameSlug+"debug", func(t *testing.T) {
-		if Config.IsSet("debug") {
-			t.Errorf("debug setting is not as expected (unset)")
+		if !(cfg.IsSet("debug") && cfg.GetBool("debug")) {
+			t.Errorf("debug setting is not as expected (explicit true, from path2)")
 		}
 	})
 
 	t.Run(testNameSlug+"exiftool-binary", func(t *testing.T) {
 		want := "/usr/bin/path1/exiftool"
-		if !(Config.IsSet("exiftool-binary") && (Config.GetString("exiftool-binary") == want)) {
+		if !(cfg.IsSet("exiftool-binary") && (cfg.GetString("exiftool-binary") == want)) {
 			t.Errorf("exiftool-binary setting is not as expected (explicit %s)", want)
 		}
 	})
 
+	t.Run(testNameSlug+"layers", func(t *testing.T) {
+		if len(cfg.Layers) != 2 {
+			t.Errorf("%d configuration layers were loaded, expected 2", len(cfg.Layers))
+		}
+	})
+
 }
 
 /*
-This test ensures that the 'correct' configuration (e.g. the first found) is loaded from a set of multiple
-search directories.
+This test ensures that the 'correct' configuration (e.g. the first found) takes precedence when layered
+from a set of multiple search directories.
 */
 func Test_PathSearch_2(t *testing.T) {
 	var args cli_args
 
 	testNameSlug := "pathsearch2-"
 
-	Initialize(args)
-	useSpecificConfigPath("../../test/config/path3")
-	useSpecificConfigPath("../../test/config/path2")
-	useSpecificConfigPath("../../test/config/path1")
+	cfg, _ := New(args)
+	cfg.useSpecificConfigPath("../../test/config/path3")
+	cfg.useSpecificConfigPath("../../test/config/path2")
+	cfg.useSpecificConfigPath("../../test/config/path1")
 
 	t.Run(testNameSlug+"readconfiguration", func(t *testing.T) {
-		cfgRead, err := ReadConfiguration()
+		cfgRead, err := cfg.ReadConfiguration()
 		if !cfgRead || (err != nil) {
-			t.Fatalf(testNameSlug+"ReadConfiguration() failed: reason: %s", err)
+			t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"processconfiguration", func(t *testing.T) {
-		if err := ProcessConfiguration(); err != nil {
-			t.Fatalf(testNameSlug+"ProcessConfiguration() failed: reason: %s", err)
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"configfileused", func(t *testing.T) {
-		pathparts := strings.Split(Config.ConfigFileUsed(), string(os.PathSeparator))
+		pathparts := strings.Split(cfg.ConfigFileUsed(), string(os.PathSeparator))
 
 		// only look at the last parts of the file path that exist within this source repo
 		subpath := pathparts[len(pathparts)-4:]
@@ -323,20 +494,21 @@ func Test_PathSearch_2(t *testing.T) {
 	})
 
 	t.Run(testNameSlug+"debug", func(t *testing.T) {
-		if !(Config.IsSet("debug") && Config.GetBool("debug")) {
+		if !(cfg.IsSet("debug") && cfg.GetBool("debug")) {
 			t.Errorf("debug setting is not as expected (explicit true)")
 		}
 	})
 
+	// settings from files with lower precedence are still used
 	t.Run(testNameSlug+"dry-run", func(t *testing.T) {
-		if Config.IsSet("dry-run") {
-			t.Errorf("dry-run setting is not as expected (unset)")
+		if !(cfg.IsSet("dry-run") && cfg.GetBool("dry-run")) {
+			t.Errorf("dry-run setting is not as expected (explicit true, from path1)")
 		}
 	})
 
 	t.Run(testNameSlug+"exiftool-binary", func(t *testing.T) {
 		want := "/usr/bin/path2/exiftool"
-		if !(Config.IsSet("exiftool-binary") && (Config.GetString("exiftool-binary") == want)) {
+		if !(cfg.IsSet("exiftool-binary") && (cfg.GetString("exiftool-binary") == want)) {
 			t.Errorf("exiftool-binary setting is not as expected (explicit %s)", want)
 		}
 	})
@@ -352,23 +524,23 @@ func Test_SpecificCfgFile_Good(t *testing.T) {
 
 	args = append(args, "--config-file=../../test/config/path1/mediafiler.yaml")
 
-	Initialize(args)
+	cfg, _ := New(args)
 
 	t.Run(testNameSlug+"readconfiguration", func(t *testing.T) {
-		cfgRead, err := ReadConfiguration()
+		cfgRead, err := cfg.ReadConfiguration()
 		if !cfgRead || (err != nil) {
-			t.Fatalf(testNameSlug+"ReadConfiguration() failed: reason: %s", err)
+			t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"processconfiguration", func(t *testing.T) {
-		if err := ProcessConfiguration(); err != nil {
-			t.Fatalf(testNameSlug+"ProcessConfiguration() failed: reason: %s", err)
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
 		}
 	})
 
 	t.Run(testNameSlug+"configfileused", func(t *testing.T) {
-		pathparts := strings.Split(Config.ConfigFileUsed(), string(os.PathSeparator))
+		pathparts := strings.Split(cfg.ConfigFileUsed(), string(os.PathSeparator))
 
 		// only look at the last parts of the file path that exist within this source repo
 		subpath := pathparts[len(pathparts)-4:]
@@ -381,20 +553,20 @@ func Test_SpecificCfgFile_Good(t *testing.T) {
 	})
 
 	t.Run(testNameSlug+"dry-run", func(t *testing.T) {
-		if !(Config.IsSet("dry-run") && Config.GetBool("dry-run")) {
+		if !(cfg.IsSet("dry-run") && cfg.GetBool("dry-run")) {
 			t.Errorf("dry-run setting is not as expected (explicit true)")
 		}
 	})
 
 	t.Run(testNameSlug+"debug", func(t *testing.T) {
-		if Config.IsSet("debug") {
+		if cfg.IsSet("debug") {
 			t.Errorf("debug setting is not as expected (unset)")
 		}
 	})
 
 	t.Run(testNameSlug+"exiftool-binary", func(t *testing.T) {
 		want := "/usr/bin/path1/exiftool"
-		if !(Config.IsSet("exiftool-binary") && (Config.GetString("exiftool-binary") == want)) {
+		if !(cfg.IsSet("exiftool-binary") && (cfg.GetString("exiftool-binary") == want)) {
 			t.Errorf("exiftool-binary setting is not as expected (explicit %s)", want)
 		}
 	})
@@ -414,12 +586,12 @@ func Test_SpecificCfgFile_Bad(t *testing.T) {
 
 	args = append(args, "--config-file=../../test/config/path3/mediafooler.jsooon")
 
-	Initialize(args)
+	cfg, _ := New(args)
 
 	t.Run(testNameSlug+"readconfiguration", func(t *testing.T) {
-		cfgRead, err := ReadConfiguration()
+		cfgRead, err := cfg.ReadConfiguration()
 		if cfgRead || (err == nil) {
-			t.Fatal(testNameSlug + "ReadConfiguration() succeeded when it should have failed")
+			t.Fatal(testNameSlug + "cfg.ReadConfiguration() succeeded when it should have failed")
 		}
 	})
 
@@ -435,19 +607,666 @@ func Test_SpecificCfgFile_Missing(t *testing.T) {
 
 	args = append(args, "--config-file=../../test/config/path_that_does_not_exist/mediafiler.yaml")
 
-	Initialize(args)
+	cfg, _ := New(args)
 
 	t.Run(testNameSlug+"readconfiguration", func(t *testing.T) {
 		expected := "no such file or directory"
-		cfgRead, err := ReadConfiguration()
+		cfgRead, err := cfg.ReadConfiguration()
 
 		msg := err.Error()
 		offset := len(msg) - len(expected)
 		t.Logf("err: %s", err)
 
 		if cfgRead || (msg[offset:] != expected) {
-			t.Fatal(testNameSlug + "ReadConfiguration() succeeded when it should have failed")
+			t.Fatal(testNameSlug + "cfg.ReadConfiguration() succeeded when it should have failed")
 		}
 	})
 
 }
+
+/*
+This test ensures that configuration is layered from the search paths, the file given with --config-file,
+the environment and the command line, and that rule lists are merged across the layers.
+*/
+func Test_Layering(t *testing.T) {
+	testNameSlug := "layering-"
+
+	overlay := filepath.Join(t.TempDir(), "overlay.yaml")
+	err := os.WriteFile(overlay, []byte(`
+exiftool-binary: /usr/bin/overlay/exiftool
+model-replace-rules:
+- replace_type: "string"
+  find_pattern: "overlay"
+media-types:
+- mime: "image"
+  dest_root: "/srv/overlay"
+`), 0644)
+	if err != nil {
+		t.Fatalf("could not write overlay configuration: %s", err)
+	}
+
+	t.Setenv("MEDIAFILER_DRY_RUN", "false")
+	t.Setenv("MEDIAFILER_UNSORTED_DIR", "123")
+	t.Setenv("MEDIAFILER_MODEL_REPLACE_RULES", `[{replace_type: string, find_pattern: env}]`)
+
+	cfg, _ := New(cli_args{"--config-file", overlay, "--debug=false"})
+	cfg.useSpecificConfigPath("../../test/config/path1")
+	cfg.useSpecificConfigPath("../../test/config/path2")
+
+	if cfgRead, err := cfg.ReadConfiguration(); !cfgRead || (err != nil) {
+		t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
+	}
+
+	if err := cfg.ProcessConfiguration(); err != nil {
+		t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+	}
+
+	t.Run(testNameSlug+"layers", func(t *testing.T) {
+		want := []string{"path2", "path1", "overlay.yaml", ENV_SOURCE}
+		if len(cfg.Layers) != len(want) {
+			t.Fatalf("%d configuration layers were loaded, expected %d", len(cfg.Layers), len(want))
+		}
+
+		for idx, layer := range cfg.Layers {
+			if !strings.Contains(layer.Name, want[idx]) {
+				t.Errorf("layer %d is '%s', expected it to contain '%s'", idx, layer.Name, want[idx])
+			}
+		}
+	})
+
+	settingTests := []struct {
+		key  string
+		want interface{}
+	}{
+		{"exiftool-binary", "/usr/bin/overlay/exiftool"}, // overlay beats search paths
+		{"dry-run", false},      // environment beats path1
+		{"debug", false},        // flag beats path2
+		{"unsorted-dir", "123"}, // string settings aren't parsed
+	}
+	for _, v := range settingTests {
+		t.Run(testNameSlug+v.key, func(t *testing.T) {
+			if got := cfg.Get(v.key); got != v.want {
+				t.Errorf("%s is '%v', expected '%v'", v.key, got, v.want)
+			}
+		})
+	}
+
+	t.Run(testNameSlug+"model-replace-rules", func(t *testing.T) {
+		if got, _ := cfg.ModelReplacer.Replace("overlay env"); got != " " {
+			t.Errorf("rules from the overlay and environment weren't both applied, got '%s'", got)
+		}
+	})
+
+	t.Run(testNameSlug+"media-types", func(t *testing.T) {
+		rule, ok := cfg.MediaTypes.Match("image", "jpeg")
+		if !ok || rule.DestRoot != "/srv/overlay" {
+			t.Errorf("images are not filed using the overlay's rule")
+		}
+	})
+
+	t.Run(testNameSlug+"effective", func(t *testing.T) {
+		settings := cfg.EffectiveSettings()
+
+		rules, _ := settings["model-replace-rules"].([]interface{})
+		if len(rules) != 2 {
+			t.Errorf("effective configuration has %d model-replace-rules, expected 2", len(rules))
+		}
+
+		if settings["debug"] != false || settings["exiftool-binary"] != "/usr/bin/overlay/exiftool" {
+			t.Errorf("effective configuration doesn't match the layered settings: %v", settings)
+		}
+	})
+}
+
+/*
+Configuration can come entirely from the environment, e.g. when running in a container.
+*/
+func Test_EnvironmentOnly(t *testing.T) {
+	var args cli_args
+	testNameSlug := "environmentonly-"
+
+	t.Setenv("MEDIAFILER_DEBUG", "true")
+	t.Setenv("MEDIAFILER_PATH_IGNORE_PATTERNS", `[{type: string, pattern: /tmp/}]`)
+
+	cfg, _ := New(args)
+
+	if cfgRead, err := cfg.ReadConfiguration(); !cfgRead || (err != nil) {
+		t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
+	}
+
+	if err := cfg.ProcessConfiguration(); err != nil {
+		t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+	}
+
+	if !cfg.GetBool("debug") {
+		t.Error("debug setting is not as expected (explicit true)")
+	}
+
+	if len(cfg.PathIgnorer) != 1 {
+		t.Errorf("%d path ignore patterns were loaded, expected 1", len(cfg.PathIgnorer))
+	}
+}
+
+/*
+This test ensures that conditions on model replace rules are loaded, and that problems with them are
+reported at the condition they were found in.
+*/
+func Test_ConditionalReplaceRules(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "conditionalrules-"
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+model-replace-rules:
+- replace_type: "regex"
+  find_pattern: "^FC330$"
+  replace_with: "Phantom4"
+  stop_after_match: true
+  conditions:
+  - tag: "Make"
+    type: "string"
+    pattern: "DJI"
+`)
+		if err := cfg.applyConfigurationYAML(testNameSlug+"yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := strmanip.ReplacerRule{Type: "regex", Find: "^FC330$", ReplaceWith: "Phantom4", StopAfterMatch: true,
+			Conditions: []strmanip.ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "DJI"}}}
+
+		if len(cfg.ModelReplacer) != 1 || !cfg.ModelReplacer[0].Equal(want) {
+			t.Errorf("model replacer rules are '%v', expected '%v'", cfg.ModelReplacer, want)
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+model-replace-rules:
+- replace_type: "string"
+  find_pattern: "FC330"
+  conditions:
+  - tag: "Make"
+    type: "string"
+    pattern: "DJI"
+  - tag: "Software"
+    type: "regex"
+    pattern: "(v01"
+`)
+		if err := cfg.applyConfigurationYAML("conditions.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		err := cfg.ProcessConfiguration()
+		if err == nil {
+			t.Fatal(testNameSlug + "cfg.ProcessConfiguration() succeeded when it should have failed")
+		}
+
+		want := "conditions.yaml: model-replace-rules[0].conditions[1].pattern: value Pattern is not a valid regex"
+		if errs := Errors(err); len(errs) != 1 || errs[0].Error() != want {
+			t.Errorf("cfg.ProcessConfiguration() errors are %v, expected '%s'", errs, want)
+		}
+	})
+}
+
+/*
+This test ensures that camera names are loaded with their serial numbers exactly as written, merged
+across layers, and that unusable names are reported.
+*/
+func Test_CamerasConfig(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "cameras-"
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		t.Setenv("MEDIAFILER_CAMERAS", `{0777: Canon800D-C, AB12cd: Canon800D-D}`)
+
+		overlay := filepath.Join(t.TempDir(), "mediafiler.yaml")
+		err := os.WriteFile(overlay, []byte(`
+cameras:
+  012345: Canon800D-A
+  067890: Canon800D-B
+  0777: Canon800D-X
+`), 0644)
+		if err != nil {
+			t.Fatalf("could not write configuration: %s", err)
+		}
+
+		cfg, _ := New(cli_args{"--config-file", overlay})
+
+		if cfgRead, err := cfg.ReadConfiguration(); !cfgRead || (err != nil) {
+			t.Fatalf(testNameSlug+"cfg.ReadConfiguration() failed: reason: %s", err)
+		}
+
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := map[string]string{
+			"012345": "Canon800D-A",
+			"067890": "Canon800D-B",
+			"0777":   "Canon800D-C", // the environment beats the file
+			"ab12CD": "Canon800D-D",
+		}
+		for serial, name := range want {
+			if got, ok := cfg.Cameras.Name(serial); !ok || got != name {
+				t.Errorf("camera name for serial '%s' is '%s', expected '%s'", serial, got, name)
+			}
+		}
+
+		if _, ok := cfg.Cameras.Name("12345"); ok {
+			t.Error("serial numbers are not matched exactly")
+		}
+
+		if cameras, _ := cfg.EffectiveSettings()["cameras"].(map[string]interface{}); len(cameras) != 4 {
+			t.Errorf("effective configuration has %d cameras, expected 4", len(cameras))
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+cameras:
+  "012345": "Canon/800D"
+  "067890": ["Canon800D-B"]
+`)
+		if err := cfg.applyConfigurationYAML("cameras.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		err := cfg.ProcessConfiguration()
+		if err == nil {
+			t.Fatal(testNameSlug + "cfg.ProcessConfiguration() succeeded when it should have failed")
+		}
+
+		wantLocations := []string{"cameras.067890", "cameras.012345"}
+		errs := Errors(err)
+		if len(errs) != len(wantLocations) {
+			t.Fatalf("cfg.ProcessConfiguration() returned %d errors %v, expected %d", len(errs), errs, len(wantLocations))
+		}
+
+		for idx, e := range errs {
+			if verr, ok := e.(*ValidationError); !ok || verr.Location != wantLocations[idx] {
+				t.Errorf("error %d '%s' is not at '%s'", idx, e, wantLocations[idx])
+			}
+		}
+	})
+
+	t.Run(testNameSlug+"filename-template", func(t *testing.T) {
+		cfg, _ := New(args)
+		if err := cfg.applyConfigurationYAML("template.yaml", []byte(`filename-template: "{{.Camara}}"`)); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		errs := Errors(cfg.ProcessConfiguration())
+		if len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "template.yaml: filename-template: ") {
+			t.Errorf("cfg.ProcessConfiguration() errors are %v, expected a filename-template error", errs)
+		}
+	})
+}
+
+/*
+This test verifies that file filters are read from the configuration, and that problems with
+them are reported at the key that needs fixing.
+*/
+func Test_FileFiltersConfig(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "filefilters-"
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+file-filters:
+- name: "screenshots"
+  action: "exclude"
+  conditions:
+  - tag: "Model"
+    type: "string"
+    pattern: "Screenshot"
+- name: "thumbnails"
+  action: "exclude"
+  mime: "image"
+  dimensions_below: 640
+  size_below: "50KB"
+`)
+		if err := cfg.applyConfigurationYAML(testNameSlug+"yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := []FileFilter{
+			{Name: "screenshots", Action: "exclude", Conditions: []strmanip.ReplacerCondition{{Tag: "Model", Type: "string", Pattern: "Screenshot"}}},
+			{Name: "thumbnails", Action: "exclude", MIME: "image", DimensionsBelow: 640, SizeBelow: "50KB"},
+		}
+
+		if !slices.EqualFunc(cfg.FileFilters, want, FileFilter.Equal) {
+			t.Errorf("file filters are '%v', expected '%v'", cfg.FileFilters, want)
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+file-filters:
+- name: "old"
+  action: "skip"
+  taken_before: "last year"
+- name: "raw"
+  action: "include"
+  conditions:
+  - tag: "FileType"
+    type: "regex"
+    pattern: "(CR2"
+- name: "everything"
+  action: "include"
+`)
+		if err := cfg.applyConfigurationYAML("filters.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		err := cfg.ProcessConfiguration()
+		if err == nil {
+			t.Fatal(testNameSlug + "cfg.ProcessConfiguration() succeeded when it should have failed")
+		}
+
+		wantLocations := []string{
+			"file-filters[0].action",
+			"file-filters[0].taken_before",
+			"file-filters[1].conditions[0].pattern",
+			"file-filters[2]",
+		}
+
+		errs := Errors(err)
+		if len(errs) != len(wantLocations) {
+			t.Fatalf("cfg.ProcessConfiguration() returned %d errors %v, expected %d", len(errs), errs, len(wantLocations))
+		}
+
+		for idx, e := range errs {
+			var verr *ValidationError
+			if !errors.As(e, &verr) || verr.Location != wantLocations[idx] {
+				t.Errorf("error %d '%s' is not at '%s'", idx, e, wantLocations[idx])
+			}
+		}
+
+		if len(cfg.FileFilters) != 0 {
+			t.Errorf("invalid file filters were added: %v", cfg.FileFilters)
+		}
+	})
+}
+
+/*
+This test verifies that a reloaded configuration replaces the rules in use, and that an
+invalid one is rejected without disturbing them.
+*/
+func Test_Reload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "mediafiler.yaml")
+	write := func(contents string) {
+		if err := os.WriteFile(file, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+unsorted-dir: "unsorted"
+cameras:
+  "012345": "Canon800D-A"
+`)
+
+	cfg, _ := New(cli_args{"--config-file", file})
+	if loaded, err := cfg.ReadConfiguration(); !loaded {
+		t.Fatalf("cfg.ReadConfiguration() failed: reason: %s", err)
+	}
+	if err := cfg.ProcessConfiguration(); err != nil {
+		t.Fatalf("cfg.ProcessConfiguration() failed: reason: %s", err)
+	}
+
+	write(`
+unsorted-dir: "unsorted"
+cameras:
+  "012345": "Canon800D-A"
+  "067890": "Canon800D-B"
+`)
+	if err := cfg.Reload(); err != nil {
+		t.Fatalf("cfg.Reload() failed: reason: %s", err)
+	}
+
+	if name, ok := cfg.Cameras.Name("067890"); !ok || name != "Canon800D-B" {
+		t.Errorf("camera added in the reloaded configuration is '%s', %v, expected 'Canon800D-B'", name, ok)
+	}
+
+	write(`
+unsorted-dir: "elsewhere"
+cameras:
+  "012345": "Canon800D/A"
+model-replace-rules:
+- replace_type: "regex"
+  find_pattern: "(Canon"
+`)
+	if err := cfg.Reload(); err == nil {
+		t.Fatal("cfg.Reload() succeeded with an invalid configuration")
+	}
+
+	if name, ok := cfg.Cameras.Name("067890"); !ok || name != "Canon800D-B" {
+		t.Errorf("cameras changed after an invalid configuration was rejected")
+	}
+	if len(cfg.ModelReplacer) != 0 {
+		t.Errorf("model replace rules changed after an invalid configuration was rejected: %v", cfg.ModelReplacer)
+	}
+	if dir := cfg.GetString("unsorted-dir"); dir != "unsorted" {
+		t.Errorf("unsorted-dir is '%s' after an invalid configuration was rejected, expected 'unsorted'", dir)
+	}
+	if len(cfg.Layers) != 1 || cfg.Layers[0].Name != file {
+		t.Errorf("layers are %v after an invalid configuration was rejected", cfg.LayerNames())
+	}
+}
+
+/*
+This test verifies that event grouping settings are read from the configuration, and that
+problems with them are reported at the key that needs fixing.
+*/
+func Test_EventsConfig(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "events-"
+
+	t.Run(testNameSlug+"defaults", func(t *testing.T) {
+		cfg, _ := New(args)
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := EventRules{MarkerFile: DEFAULT_EVENT_MARKER_FILE}
+		if cfg.Events != want || cfg.Events.Enabled() {
+			t.Errorf("cfg.Events = %+v, want %+v", cfg.Events, want)
+		}
+	})
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+event-gap: 3h30m
+event-distance: 25
+event-marker-file: event.txt
+`)
+		if err := cfg.applyConfigurationYAML("events.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := EventRules{Gap: 3*time.Hour + 30*time.Minute, Distance: 25, MarkerFile: "event.txt"}
+		if cfg.Events != want || !cfg.Events.Enabled() {
+			t.Errorf("cfg.Events = %+v, want %+v", cfg.Events, want)
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+event-gap: soon
+event-distance: -1
+event-marker-file: markers/event.txt
+`)
+		if err := cfg.applyConfigurationYAML("events.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		wantLocations := []string{"event-gap", "event-distance", "event-marker-file"}
+		errs := Errors(cfg.ProcessConfiguration())
+		if len(errs) != len(wantLocations) {
+			t.Fatalf("cfg.ProcessConfiguration() returned %d errors %v, expected %d", len(errs), errs, len(wantLocations))
+		}
+
+		for idx, e := range errs {
+			if verr, ok := e.(*ValidationError); !ok || verr.Location != wantLocations[idx] || verr.Source != "events.yaml" {
+				t.Errorf("error %d '%s' is not at 'events.yaml: %s'", idx, e, wantLocations[idx])
+			}
+		}
+	})
+}
+
+/*
+This test verifies that near-duplicate settings are read from the configuration, and that
+problems with them are reported at the key that needs fixing.
+*/
+func Test_NearDuplicatesConfig(t *testing.T) {
+	var args cli_args
+
+	testNameSlug := "nearduplicates-"
+
+	t.Run(testNameSlug+"configured", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+near-duplicates: review
+near-duplicate-hash: phash
+near-duplicate-distance: 10
+near-duplicate-dir: /photos/review
+`)
+		if err := cfg.applyConfigurationYAML("near.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+		if err := cfg.ProcessConfiguration(); err != nil {
+			t.Fatalf(testNameSlug+"cfg.ProcessConfiguration() failed: reason: %s", err)
+		}
+
+		want := NearDuplicateRules{Mode: NEAR_DUPLICATES_REVIEW, Algorithm: "phash", MaxDistance: 10, Dir: "/photos/review"}
+		if cfg.NearDuplicates != want || !cfg.NearDuplicates.Enabled() {
+			t.Errorf("cfg.NearDuplicates = %+v, want %+v", cfg.NearDuplicates, want)
+		}
+	})
+
+	t.Run(testNameSlug+"invalid", func(t *testing.T) {
+		cfg, _ := New(args)
+		yaml := []byte(`
+near-duplicates: delete
+near-duplicate-hash: md5
+near-duplicate-distance: 65
+`)
+		if err := cfg.applyConfigurationYAML("near.yaml", yaml); err != nil {
+			t.Fatalf(testNameSlug+"cfg.applyConfigurationYAML() failed: reason: %s", err)
+		}
+
+		wantLocations := []string{"near-duplicates", "near-duplicate-hash", "near-duplicate-distance"}
+		errs := Errors(cfg.ProcessConfiguration())
+		if len(errs) != len(wantLocations) {
+			t.Fatalf("cfg.ProcessConfiguration() returned %d errors %v, expected %d", len(errs), errs, len(wantLocations))
+		}
+
+		for idx, e := range errs {
+			if verr, ok := e.(*ValidationError); !ok || verr.Location != wantLocations[idx] {
+				t.Errorf("error %d '%s' is not at '%s'", idx, e, wantLocations[idx])
+			}
+		}
+	})
+}
+
+/*
+This test verifies that the duplicate check's hash algorithm and comparison are read from the
+configuration
+*/
+func Test_DuplicatesConfig(t *testing.T) {
+	var args cli_args
+
+	tests := []struct {
+		yaml        string
+		want        filehash.Algorithm
+		wantCompare filehash.Comparison
+		wantErrAt   string
+	}{
+		{``, filehash.SHA256, filehash.COMPARED_CONTENT, ""},
+		{`duplicate-hash: blake3`, filehash.BLAKE3, filehash.COMPARED_CONTENT, ""},
+		{`duplicate-hash: xxh3`, filehash.XXH3, filehash.COMPARED_CONTENT, ""},
+		{`duplicate-hash: md5`, filehash.SHA256, filehash.COMPARED_CONTENT, "duplicate-hash"},
+		{`duplicate-compare: payload`, filehash.SHA256, filehash.COMPARED_PAYLOAD, ""},
+		{`duplicate-compare: pixels`, filehash.SHA256, filehash.COMPARED_CONTENT, "duplicate-compare"},
+	}
+
+	for _, tt := range tests {
+		t.Run("duplicates-"+tt.yaml, func(t *testing.T) {
+			cfg, _ := New(args)
+			if err := cfg.applyConfigurationYAML("duplicates.yaml", []byte(tt.yaml)); err != nil {
+				t.Fatalf("cfg.applyConfigurationYAML() failed: reason: %s", err)
+			}
+
+			errs := Errors(cfg.ProcessConfiguration())
+			if (len(errs) > 0) != (tt.wantErrAt != "") {
+				t.Fatalf("cfg.ProcessConfiguration() errors are %v, want them at '%s'", errs, tt.wantErrAt)
+			}
+			if tt.wantErrAt != "" && !strings.HasPrefix(errs[0].Error(), "duplicates.yaml: "+tt.wantErrAt+": ") {
+				t.Errorf("cfg.ProcessConfiguration() error '%s' is not at %s", errs[0], tt.wantErrAt)
+			}
+			if cfg.Duplicates.Algorithm != tt.want || cfg.Duplicates.Compare != tt.wantCompare {
+				t.Errorf("cfg.Duplicates = %+v, want '%s' and '%s'", cfg.Duplicates, tt.want, tt.wantCompare)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that where original names are recorded is read from the configuration
+*/
+func Test_OriginalNamesConfig(t *testing.T) {
+	var args cli_args
+
+	tests := []struct {
+		yaml    string
+		want    string
+		wantErr bool
+	}{
+		{``, "", false},
+		{`original-names: manifest`, ORIGINAL_NAMES_MANIFEST, false},
+		{`original-names: sidecar`, ORIGINAL_NAMES_SIDECAR, false},
+		{`original-names: database`, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run("original-names-"+tt.yaml, func(t *testing.T) {
+			cfg, _ := New(args)
+			if err := cfg.applyConfigurationYAML("names.yaml", []byte(tt.yaml)); err != nil {
+				t.Fatalf("cfg.applyConfigurationYAML() failed: reason: %s", err)
+			}
+
+			errs := Errors(cfg.ProcessConfiguration())
+			if (len(errs) > 0) != tt.wantErr {
+				t.Fatalf("cfg.ProcessConfiguration() errors are %v, wantErr %v", errs, tt.wantErr)
+			}
+			if tt.wantErr && !strings.HasPrefix(errs[0].Error(), "names.yaml: original-names: ") {
+				t.Errorf("cfg.ProcessConfiguration() error '%s' is not at original-names", errs[0])
+			}
+			if cfg.OriginalNames != tt.want {
+				t.Errorf("cfg.OriginalNames = '%s', want '%s'", cfg.OriginalNames, tt.want)
+			}
+		})
+	}
+}
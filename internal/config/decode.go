@@ -0,0 +1,313 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/hashicorp/go-multierror"
+)
+
+/*
+ValidationError describes a problem found in a configuration source, along with
+where it was found. e.g.
+
+	/etc/mediafiler/mediafiler.yaml: model-replace-rules[2].find_pattern: missing required key
+*/
+type ValidationError struct {
+	Source   string
+	Location string
+	Err      error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Location == "" {
+		return fmt.Sprintf("%s: %s", e.Source, e.Err)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Source, e.Location, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+/*
+decodeSettings decodes settings read from a configuration source into out, which
+must be a pointer to a struct with 'config' tags. Unknown keys, missing required
+keys and values of the wrong type are all reported, rather than stopping at the first.
+*/
+func decodeSettings(source string, settings map[string]interface{}, out interface{}) []error {
+	return decodeValue(source, "", settings, reflect.ValueOf(out).Elem())
+}
+
+func decodeValue(source string, location string, raw interface{}, out reflect.Value) []error {
+	var errs []error
+
+	// an empty value (e.g. 'replace_with:') leaves the zero value in place
+	if raw == nil {
+		return nil
+	}
+
+	typeErr := func(want string) []error {
+		return []error{&ValidationError{Source: source, Location: location, Err: fmt.Errorf("expected %s, found %T value '%v'", want, raw, raw)}}
+	}
+
+	switch out.Kind() {
+	case reflect.String:
+		v, ok := raw.(string)
+		if !ok {
+			return typeErr("a string")
+		}
+		out.SetString(v)
+
+	case reflect.Bool:
+		v, ok := raw.(bool)
+		if !ok {
+			return typeErr("true or false")
+		}
+		out.SetBool(v)
+
+	case reflect.Int, reflect.Int64:
+		switch v := raw.(type) {
+		case int:
+			out.SetInt(int64(v))
+		case int64:
+			out.SetInt(v)
+		case uint64:
+			out.SetInt(int64(v))
+		case float64:
+			if v != math.Trunc(v) {
+				return typeErr("a whole number")
+			}
+			out.SetInt(int64(v))
+		default:
+			return typeErr("a whole number")
+		}
+
+	case reflect.Float64:
+		switch v := raw.(type) {
+		case int:
+			out.SetFloat(float64(v))
+		case int64:
+			out.SetFloat(float64(v))
+		case uint64:
+			out.SetFloat(float64(v))
+		case float64:
+			out.SetFloat(v)
+		default:
+			return typeErr("a number")
+		}
+
+	case reflect.Slice:
+		list, ok := raw.([]interface{})
+		if !ok {
+			return typeErr("a list")
+		}
+
+		slice := reflect.MakeSlice(out.Type(), len(list), len(list))
+		for idx, item := range list {
+			errs = append(errs, decodeValue(source, fmt.Sprintf("%s[%d]", location, idx), item, slice.Index(idx))...)
+		}
+		out.Set(slice)
+
+	case reflect.Map:
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			return typeErr("a mapping")
+		}
+
+		m := reflect.MakeMapWithSize(out.Type(), len(settings))
+		for _, key := range sortedKeys(settings) {
+			value := reflect.New(out.Type().Elem()).Elem()
+			errs = append(errs, decodeValue(source, joinLocation(location, key), settings[key], value)...)
+			m.SetMapIndex(reflect.ValueOf(key), value)
+		}
+		out.Set(m)
+
+	case reflect.Struct:
+		settings, ok := raw.(map[string]interface{})
+		if !ok {
+			return typeErr("a mapping")
+		}
+
+		known := make(map[string]bool)
+		for idx := 0; idx < out.NumField(); idx++ {
+			key, required, ok := configKey(out.Type().Field(idx))
+			if !ok {
+				continue
+			}
+			known[key] = true
+
+			value, present := settings[key]
+			if !present {
+				if required {
+					errs = append(errs, &ValidationError{Source: source, Location: joinLocation(location, key), Err: errors.New("missing required key")})
+				}
+				continue
+			}
+
+			errs = append(errs, decodeValue(source, joinLocation(location, key), value, out.Field(idx))...)
+		}
+
+		for _, key := range sortedKeys(settings) {
+			if !known[key] {
+				errs = append(errs, &ValidationError{Source: source, Location: joinLocation(location, key), Err: errors.New("unknown key")})
+			}
+		}
+
+	default:
+		return []error{&ValidationError{Source: source, Location: location, Err: fmt.Errorf("values of kind %s can't be decoded", out.Kind())}}
+	}
+
+	return errs
+}
+
+/*
+configKey returns the configuration key for a struct field, and whether it's required.
+*/
+func configKey(field reflect.StructField) (string, bool, bool) {
+	key, opts, ok := configTag(field)
+	return key, slices.Contains(opts, "required"), ok
+}
+
+/*
+configTag splits the 'config' tag of a struct field into the key and its options.
+*/
+func configTag(field reflect.StructField) (string, []string, bool) {
+	tag := field.Tag.Get("config")
+	if tag == "" || tag == "-" {
+		return "", nil, false
+	}
+
+	key, opts, _ := strings.Cut(tag, ",")
+	if opts == "" {
+		return key, nil, true
+	}
+	return key, strings.Split(opts, ","), true
+}
+
+/*
+ruleErrors turns the errors returned by a rule's IsValid() method into ValidationErrors.
+Errors about a specific field of the rule are located at the key for that field in
+configType, a struct type with 'config' tags and field names matching the rule's.
+*/
+func ruleErrors(source string, location string, err error, configType reflect.Type) []error {
+	var errs []error
+
+	for _, e := range splitErrors(err) {
+		errs = append(errs, &ValidationError{Source: source, Location: ruleErrorLocation(location, e, configType), Err: e})
+	}
+
+	return errs
+}
+
+/*
+ruleErrorLocation follows (possibly nested) RuleErrors through configType to find
+the key a problem was found at.
+*/
+func ruleErrorLocation(location string, err error, configType reflect.Type) string {
+	var ruleErr *strmanip.RuleError
+	if !errors.As(err, &ruleErr) {
+		return location
+	}
+
+	field, ok := configType.FieldByName(ruleErr.Field)
+	if !ok {
+		return location
+	}
+
+	key, _, ok := configKey(field)
+	if !ok {
+		return location
+	}
+	location = joinLocation(location, key)
+
+	if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+		location = fmt.Sprintf("%s[%d]", location, ruleErr.Index)
+		return ruleErrorLocation(location, ruleErr.Err, field.Type.Elem())
+	}
+
+	return location
+}
+
+/*
+splitErrors flattens multi-errors into their individual errors.
+*/
+func splitErrors(err error) []error {
+	var errs []error
+
+	if err == nil {
+		return nil
+	}
+
+	var wrapped []error
+	switch e := err.(type) {
+	case interface{ WrappedErrors() []error }: // github.com/hashicorp/go-multierror
+		wrapped = e.WrappedErrors()
+	case interface{ Errors() []error }: // go.uber.org/multierr
+		wrapped = e.Errors()
+	case interface{ Unwrap() []error }: // errors.Join()
+		wrapped = e.Unwrap()
+	default:
+		return []error{err}
+	}
+
+	for _, e := range wrapped {
+		errs = append(errs, splitErrors(e)...)
+	}
+
+	return errs
+}
+
+/*
+Errors() returns the individual errors in an error returned by ProcessConfiguration().
+*/
+func Errors(err error) []error {
+	return splitErrors(err)
+}
+
+/*
+hasErrorsAt reports whether any of errs were found at location, or inside it.
+*/
+func hasErrorsAt(errs []error, location string) bool {
+	for _, err := range errs {
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			continue
+		}
+
+		if verr.Location == location || strings.HasPrefix(verr.Location, location+".") || strings.HasPrefix(verr.Location, location+"[") {
+			return true
+		}
+	}
+	return false
+}
+
+func appendErrors(merr error, errs []error) error {
+	for _, err := range errs {
+		merr = multierror.Append(merr, err)
+	}
+	return merr
+}
+
+func joinLocation(location string, key string) string {
+	if location == "" {
+		return key
+	}
+	return location + "." + key
+}
+
+func sortedKeys(value interface{}) []string {
+	m, _ := value.(map[string]interface{})
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"testing"
+)
+
+func Test_decodeSettings(t *testing.T) {
+	type testCase struct {
+		name     string
+		yaml     string
+		wantErrs []string
+	}
+
+	tests := []testCase{
+		{
+			name: "valid",
+			yaml: `
+dry-run: true
+model-replace-rules:
+- replace_type: "string"
+  find_pattern: "Canon"
+  replace_with:
+junk-files: [".DS_Store"]
+`,
+		},
+		{
+			name:     "unknown top-level key",
+			yaml:     "dry-runn: true\n",
+			wantErrs: []string{"src: dry-runn: unknown key"},
+		},
+		{
+			name:     "wrong type",
+			yaml:     "dry-run: \"yes\"\n",
+			wantErrs: []string{"src: dry-run: expected true or false, found string value 'yes'"},
+		},
+		{
+			name:     "list expected",
+			yaml:     "model-replace-rules: \"Canon\"\n",
+			wantErrs: []string{"src: model-replace-rules: expected a list, found string value 'Canon'"},
+		},
+		{
+			name: "every rule problem reported",
+			yaml: `
+model-replace-rules:
+- replace_type: "string"
+  find_pattern: "Canon"
+- replace_type: "string"
+  find: "Nikon"
+path-ignore-patterns:
+- type: "regex"
+  pattern: 42
+`,
+			wantErrs: []string{
+				"src: model-replace-rules[1].find_pattern: missing required key",
+				"src: model-replace-rules[1].find: unknown key",
+				"src: path-ignore-patterns[0].pattern: expected a string, found int value '42'",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layer, err := readLayer("src", []byte(test.yaml))
+			if err != nil {
+				t.Fatalf("readLayer() failed: reason: %s", err)
+			}
+
+			var fc FileConfig
+			errs := decodeSettings(layer.Name, layer.Settings, &fc)
+
+			if len(errs) != len(test.wantErrs) {
+				t.Fatalf("decodeSettings() returned %d errors %v, expected %d", len(errs), errs, len(test.wantErrs))
+			}
+
+			for idx, err := range errs {
+				if err.Error() != test.wantErrs[idx] {
+					t.Errorf("error %d is '%s', expected '%s'", idx, err, test.wantErrs[idx])
+				}
+			}
+		})
+	}
+}
@@ -12,11 +12,17 @@ model-replace-rules:
   find_pattern: '\s+'
   replace_with: ""
 path-ignore-patterns:
-- type: "string"
+- type: "gitignore"
   pattern: '.git/'
-- type: "string"
-  pattern: '.git\'
 - type: "regex"
   pattern: '^.*[Ii][Cc][Oo]$'
+path-template: "{{.MIMEType}}/{{.MIMESubType}}/{{.Year}}/{{.Month}}"
+media-types:
+- mime: "image"
+- mime: "video"
+junk-files:
+- "Thumbs.db"
+- ".DS_Store"
+- "ZbThumbnail.info"
   
 `)
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/filehash"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	DEFAULT_DUPLICATE_HASH    filehash.Algorithm  = filehash.SHA256
+	DEFAULT_DUPLICATE_COMPARE filehash.Comparison = filehash.COMPARED_CONTENT
+)
+
+/*
+DuplicateRules describes how a file is compared with one already at its destination, to
+decide whether it's a duplicate.
+*/
+type DuplicateRules struct {
+	Algorithm filehash.Algorithm
+	Compare   filehash.Comparison // whether whole files are compared, or only their image or video data
+}
+
+/*
+buildDuplicateRules() reads the duplicate check settings, reporting problems at the key
+that needs fixing.
+*/
+func (c *Config) buildDuplicateRules() (DuplicateRules, error) {
+	rules := DuplicateRules{Algorithm: DEFAULT_DUPLICATE_HASH, Compare: DEFAULT_DUPLICATE_COMPARE}
+	var merr error
+
+	if c.IsSet("duplicate-hash") {
+		if algorithm := filehash.Algorithm(c.GetString("duplicate-hash")); algorithm.IsValid() {
+			rules.Algorithm = algorithm
+		} else {
+			merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("duplicate-hash"), Location: "duplicate-hash",
+				Err: fmt.Errorf("'%s' should be one of %v", algorithm, filehash.Algorithms)})
+		}
+	}
+
+	if c.IsSet("duplicate-compare") {
+		if compare := filehash.Comparison(c.GetString("duplicate-compare")); compare.IsValid() {
+			rules.Compare = compare
+		} else {
+			merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("duplicate-compare"), Location: "duplicate-compare",
+				Err: fmt.Errorf("'%s' should be one of %v", compare, filehash.Comparisons)})
+		}
+	}
+
+	return rules, merr
+}
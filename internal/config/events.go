@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const DEFAULT_EVENT_MARKER_FILE string = ".mediafiler-event"
+
+/*
+EventRules describes how files are grouped into events for the '{{.Event}}' template
+field. Files taken less than Gap apart belong to the same event, unless they're more
+than Distance kilometres apart. A Gap of zero turns grouping off, and a Distance of zero
+ignores GPS positions. Files in a directory holding a MarkerFile belong to the event it
+names instead.
+*/
+type EventRules struct {
+	Gap        time.Duration
+	Distance   float64
+	MarkerFile string
+}
+
+/*
+Enabled() reports whether files are grouped by time.
+*/
+func (e EventRules) Enabled() bool {
+	return e.Gap > 0
+}
+
+/*
+buildEventRules() reads the event settings, reporting problems at the key that needs fixing.
+*/
+func (c *Config) buildEventRules() (EventRules, error) {
+	events := EventRules{MarkerFile: DEFAULT_EVENT_MARKER_FILE}
+	var merr error
+
+	if gap := c.GetString("event-gap"); gap != "" {
+		var err error
+		if events.Gap, err = time.ParseDuration(gap); err != nil || events.Gap < 0 {
+			events.Gap = 0
+			merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("event-gap"), Location: "event-gap",
+				Err: fmt.Errorf("'%s' should be a positive duration like '3h' or '90m'", gap)})
+		}
+	}
+
+	if c.IsSet("event-distance") {
+		if events.Distance = c.GetFloat64("event-distance"); events.Distance < 0 {
+			events.Distance = 0
+			merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("event-distance"), Location: "event-distance",
+				Err: errors.New("the distance cannot be negative")})
+		}
+	}
+
+	if c.IsSet("event-marker-file") {
+		marker := c.GetString("event-marker-file")
+		if marker == "" || filepath.Base(marker) != marker {
+			merr = multierror.Append(merr, &ValidationError{Source: c.settingSource("event-marker-file"), Location: "event-marker-file",
+				Err: fmt.Errorf("'%s' should be a file name, without a directory", marker)})
+		} else {
+			events.MarkerFile = marker
+		}
+	}
+
+	return events, merr
+}
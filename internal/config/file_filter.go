@@ -0,0 +1,320 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"go.uber.org/multierr"
+)
+
+/*
+FilterFile holds what's known about a file once its metadata has been read, for
+checking it against file filters. Width, Height and Taken are left at their zero
+values when the metadata doesn't include them.
+*/
+type FilterFile struct {
+	Path        string
+	Size        int64
+	MIMEType    string
+	MIMESubType string
+	Width       int
+	Height      int
+	Taken       time.Time
+	Tags        strmanip.TagLookup
+}
+
+/*
+FileFilter includes or excludes files based on their path and metadata. A filter
+matches a file when every one of its criteria that is set holds:
+
+  - Path: the file's path, or a directory above it, matches the glob
+  - MIME: the file's MIME type matches, as in 'media-types'
+  - Conditions: each metadata tag condition holds, as in model replace rules
+  - SizeBelow / SizeAbove: the file is smaller / larger than the size, e.g. '50KB'
+  - DimensionsBelow: the longer side of the image or video is below this many pixels
+  - TakenBefore / TakenAfter: the file's timestamp is before / after the date, given
+    as '2006-01-02' (UTC) or in RFC 3339 format
+
+Criteria that need metadata the file doesn't have never hold.
+*/
+type FileFilter struct {
+	Name            string
+	Action          string
+	Path            string
+	MIME            string
+	Conditions      []strmanip.ReplacerCondition
+	SizeBelow       string
+	SizeAbove       string
+	DimensionsBelow int
+	TakenBefore     string
+	TakenAfter      string
+	sizeBelow       int64
+	sizeAbove       int64
+	takenBefore     time.Time
+	takenAfter      time.Time
+}
+
+const (
+	FILTER_INCLUDE string = "include"
+	FILTER_EXCLUDE string = "exclude"
+)
+
+/*
+Equal() reports whether two filters are the same.
+*/
+func (f FileFilter) Equal(other FileFilter) bool {
+	return f.Name == other.Name &&
+		f.Action == other.Action &&
+		f.Path == other.Path &&
+		f.MIME == other.MIME &&
+		slices.EqualFunc(f.Conditions, other.Conditions, strmanip.ReplacerCondition.Equal) &&
+		f.SizeBelow == other.SizeBelow &&
+		f.SizeAbove == other.SizeAbove &&
+		f.DimensionsBelow == other.DimensionsBelow &&
+		f.TakenBefore == other.TakenBefore &&
+		f.TakenAfter == other.TakenAfter
+}
+
+func (f FileFilter) IsValid() (bool, error) {
+	var merr error
+
+	if f.Name == "" {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "Name", Err: errors.New("the Name cannot be empty")})
+	}
+
+	if f.Action != FILTER_INCLUDE && f.Action != FILTER_EXCLUDE {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "Action",
+			Err: fmt.Errorf("invalid Action '%s'. valid actions are '%s' or '%s'", f.Action, FILTER_INCLUDE, FILTER_EXCLUDE)})
+	}
+
+	if f.Path != "" && !doublestar.ValidatePattern(f.Path) {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "Path", Err: errors.New("value for Path is not a valid glob")})
+	}
+
+	if f.MIME != "" {
+		if mimeType, mimeSubType, hasSubType := strings.Cut(f.MIME, "/"); mimeType == "" || (hasSubType && mimeSubType == "") {
+			merr = multierr.Append(merr, &strmanip.RuleError{Field: "MIME", Err: fmt.Errorf("MIME value '%s' should look like 'type' or 'type/subtype'", f.MIME)})
+		}
+	}
+
+	for idx, condition := range f.Conditions {
+		if ok, err := condition.IsValid(); !ok {
+			for _, e := range splitErrors(err) {
+				merr = multierr.Append(merr, &strmanip.RuleError{Field: "Conditions", Index: idx, Err: e})
+			}
+		}
+	}
+
+	if _, err := ParseSize(f.SizeBelow); f.SizeBelow != "" && err != nil {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "SizeBelow", Err: err})
+	}
+	if _, err := ParseSize(f.SizeAbove); f.SizeAbove != "" && err != nil {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "SizeAbove", Err: err})
+	}
+
+	if f.DimensionsBelow < 0 {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "DimensionsBelow", Err: errors.New("DimensionsBelow cannot be negative")})
+	}
+
+	if _, err := parseFilterDate(f.TakenBefore); f.TakenBefore != "" && err != nil {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "TakenBefore", Err: err})
+	}
+	if _, err := parseFilterDate(f.TakenAfter); f.TakenAfter != "" && err != nil {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "TakenAfter", Err: err})
+	}
+
+	if f.Path == "" && f.MIME == "" && len(f.Conditions) == 0 && f.SizeBelow == "" && f.SizeAbove == "" &&
+		f.DimensionsBelow == 0 && f.TakenBefore == "" && f.TakenAfter == "" {
+		merr = multierr.Append(merr, errors.New("the filter doesn't have any criteria, so it would match every file"))
+	}
+
+	return merr == nil, merr
+}
+
+/*
+compile() returns a copy of the filter with its sizes, dates and conditions parsed,
+so they don't need to be parsed for every file. The filter must be valid.
+*/
+func (f FileFilter) compile() (FileFilter, error) {
+	var err error
+
+	if f.SizeBelow != "" {
+		if f.sizeBelow, err = ParseSize(f.SizeBelow); err != nil {
+			return f, err
+		}
+	}
+	if f.SizeAbove != "" {
+		if f.sizeAbove, err = ParseSize(f.SizeAbove); err != nil {
+			return f, err
+		}
+	}
+	if f.TakenBefore != "" {
+		if f.takenBefore, err = parseFilterDate(f.TakenBefore); err != nil {
+			return f, err
+		}
+	}
+	if f.TakenAfter != "" {
+		if f.takenAfter, err = parseFilterDate(f.TakenAfter); err != nil {
+			return f, err
+		}
+	}
+
+	conditions := make([]strmanip.ReplacerCondition, len(f.Conditions))
+	for idx, condition := range f.Conditions {
+		if conditions[idx], err = condition.Compile(); err != nil {
+			return f, err
+		}
+	}
+	f.Conditions = conditions
+
+	return f, nil
+}
+
+/*
+Matches() reports whether every criterion of the filter holds for the file.
+*/
+func (f FileFilter) Matches(file FilterFile) bool {
+	if f.Path != "" && !matchGlob(f.Path, file.Path) {
+		return false
+	}
+
+	if f.MIME != "" && !(MediaTypeRule{MIME: f.MIME}).Matches(file.MIMEType, file.MIMESubType) {
+		return false
+	}
+
+	for _, condition := range f.Conditions {
+		if !condition.Matches(file.Tags) {
+			return false
+		}
+	}
+
+	if f.SizeBelow != "" && file.Size >= f.sizeBelow {
+		return false
+	}
+	if f.SizeAbove != "" && file.Size <= f.sizeAbove {
+		return false
+	}
+
+	if f.DimensionsBelow > 0 {
+		if file.Width == 0 || file.Height == 0 || max(file.Width, file.Height) >= f.DimensionsBelow {
+			return false
+		}
+	}
+
+	if f.TakenBefore != "" && (file.Taken.IsZero() || !file.Taken.Before(f.takenBefore)) {
+		return false
+	}
+	if f.TakenAfter != "" && (file.Taken.IsZero() || !file.Taken.After(f.takenAfter)) {
+		return false
+	}
+
+	return true
+}
+
+/*
+FileFilterList holds the configured file filters. Filters are compiled when they're
+added, and a FileFilterList is safe for concurrent use once all of its filters have
+been added.
+*/
+type FileFilterList []FileFilter
+
+func (l *FileFilterList) AddFilter(newFilter FileFilter) error {
+	valid, err := newFilter.IsValid()
+	if !valid {
+		return fmt.Errorf("new file filter is not valid. reason: %s", err)
+	}
+
+	if newFilter, err = newFilter.compile(); err != nil {
+		return fmt.Errorf("new file filter could not be compiled. reason: %s", err)
+	}
+
+	*l = append(*l, newFilter)
+	return nil
+}
+
+/*
+Skip() reports whether a file should be skipped, and the name of the filter that
+excluded it. Exclude filters are checked first, and a file matching any of them is
+skipped. If there are include filters, files that don't match at least one of them
+are skipped too, in which case the name returned is empty.
+*/
+func (l FileFilterList) Skip(file FilterFile) (bool, string) {
+	hasIncludes := false
+
+	for _, f := range l {
+		if f.Action == FILTER_EXCLUDE && f.Matches(file) {
+			return true, f.Name
+		}
+		if f.Action == FILTER_INCLUDE {
+			hasIncludes = true
+		}
+	}
+
+	if !hasIncludes {
+		return false, ""
+	}
+
+	for _, f := range l {
+		if f.Action == FILTER_INCLUDE && f.Matches(file) {
+			return false, ""
+		}
+	}
+
+	return true, ""
+}
+
+/*
+ParseSize() parses a file size such as '50KB', '1.5 MB' or '2048'. Sizes without a
+unit are in bytes, and units are powers of 1024.
+*/
+func ParseSize(size string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"TB", 1 << 40},
+		{"B", 1},
+	}
+
+	value := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := 1.0
+	for _, unit := range units {
+		if number, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value = strings.TrimSpace(number)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("size '%s' should be a number of bytes, optionally followed by KB, MB, GB or TB", size)
+	}
+
+	return int64(number * multiplier), nil
+}
+
+/*
+parseFilterDate() parses a date given as '2006-01-02' (midnight UTC) or in RFC 3339
+format.
+*/
+func parseFilterDate(date string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, date); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, date)
+	if err != nil {
+		return t, fmt.Errorf("date '%s' should look like '2006-01-02' or '2006-01-02T15:04:05Z'", date)
+	}
+	return t, nil
+}
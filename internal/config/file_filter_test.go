@@ -0,0 +1,132 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "2048", want: 2048},
+		{size: "512B", want: 512},
+		{size: "50KB", want: 50 * 1024},
+		{size: "50 kb", want: 50 * 1024},
+		{size: "1.5MB", want: 1536 * 1024},
+		{size: "2GB", want: 2 << 30},
+		{size: "", wantErr: true},
+		{size: "KB", wantErr: true},
+		{size: "-5KB", wantErr: true},
+		{size: "50 furlongs", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileFilter_IsValid(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter FileFilter
+		want   bool
+	}{
+		{"valid exclude", FileFilter{Name: "small", Action: "exclude", SizeBelow: "50KB"}, true},
+		{"valid include", FileFilter{Name: "canon", Action: "include", Conditions: []strmanip.ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "Canon"}}}, true},
+		{"valid dates", FileFilter{Name: "2023", Action: "include", TakenAfter: "2023-01-01", TakenBefore: "2024-01-01T00:00:00Z"}, true},
+		{"invalid empty name", FileFilter{Action: "exclude", SizeBelow: "50KB"}, false},
+		{"invalid action", FileFilter{Name: "small", Action: "skip", SizeBelow: "50KB"}, false},
+		{"invalid no criteria", FileFilter{Name: "everything", Action: "exclude"}, false},
+		{"invalid path glob", FileFilter{Name: "glob", Action: "exclude", Path: "[abc"}, false},
+		{"invalid mime", FileFilter{Name: "mime", Action: "exclude", MIME: "image/"}, false},
+		{"invalid size", FileFilter{Name: "size", Action: "exclude", SizeAbove: "huge"}, false},
+		{"invalid dimensions", FileFilter{Name: "dims", Action: "exclude", DimensionsBelow: -1}, false},
+		{"invalid date", FileFilter{Name: "date", Action: "exclude", TakenBefore: "06/15/2024"}, false},
+		{"invalid condition", FileFilter{Name: "cond", Action: "exclude", Conditions: []strmanip.ReplacerCondition{{Tag: "Make", Type: "regex", Pattern: "(Canon"}}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := tt.filter.IsValid(); got != tt.want {
+				t.Errorf("FileFilter.IsValid() = %v (%v), want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileFilterList_Skip(t *testing.T) {
+	tags := func(values map[string]string) strmanip.TagLookup {
+		return func(tag string) (string, bool) {
+			value, ok := values[tag]
+			return value, ok
+		}
+	}
+
+	var excludes FileFilterList
+	for _, filter := range []FileFilter{
+		{Name: "screenshots", Action: "exclude", Conditions: []strmanip.ReplacerCondition{{Tag: "Model", Type: "string", Pattern: "Screenshot"}}},
+		{Name: "tiny", Action: "exclude", SizeBelow: "50KB"},
+		{Name: "small images", Action: "exclude", MIME: "image", DimensionsBelow: 640},
+		{Name: "old", Action: "exclude", TakenBefore: "2010-01-01"},
+		{Name: "exports", Action: "exclude", Path: "**/exports"},
+	} {
+		if err := excludes.AddFilter(filter); err != nil {
+			t.Fatalf("FileFilterList.AddFilter(%v) failed: %s", filter.Name, err)
+		}
+	}
+
+	includes := append(FileFilterList{}, excludes...)
+	if err := includes.AddFilter(FileFilter{Name: "videos", Action: "include", MIME: "video"}); err != nil {
+		t.Fatal(err)
+	}
+
+	photo := FilterFile{Path: "/srv/photos/IMG_0001.JPG", Size: 4 << 20, MIMEType: "image", MIMESubType: "jpeg", Width: 6000, Height: 4000,
+		Taken: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC), Tags: tags(map[string]string{"Model": "Canon EOS R5"})}
+
+	with := func(change func(f *FilterFile)) FilterFile {
+		f := photo
+		change(&f)
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		filters  FileFilterList
+		file     FilterFile
+		wantSkip bool
+		wantName string
+	}{
+		{"photo", excludes, photo, false, ""},
+		{"screenshot", excludes, with(func(f *FilterFile) { f.Tags = tags(map[string]string{"Model": "Screenshot"}) }), true, "screenshots"},
+		{"no tags", excludes, with(func(f *FilterFile) { f.Tags = nil }), false, ""},
+		{"tiny", excludes, with(func(f *FilterFile) { f.Size = 10 << 10 }), true, "tiny"},
+		{"small image", excludes, with(func(f *FilterFile) { f.Width, f.Height = 320, 240 }), true, "small images"},
+		{"small video", excludes, with(func(f *FilterFile) { f.MIMEType, f.Width, f.Height = "video", 320, 240 }), false, ""},
+		{"unknown dimensions", excludes, with(func(f *FilterFile) { f.Width, f.Height = 0, 0 }), false, ""},
+		{"old", excludes, with(func(f *FilterFile) { f.Taken = time.Date(2009, 12, 31, 23, 0, 0, 0, time.UTC) }), true, "old"},
+		{"no timestamp", excludes, with(func(f *FilterFile) { f.Taken = time.Time{} }), false, ""},
+		{"exports", excludes, with(func(f *FilterFile) { f.Path = "/srv/photos/exports/IMG_0001.JPG" }), true, "exports"},
+		{"not included", includes, photo, true, ""},
+		{"included", includes, with(func(f *FilterFile) { f.MIMEType = "video" }), false, ""},
+		{"excluded before included", includes, with(func(f *FilterFile) { f.MIMEType, f.Size = "video", 1024 }), true, "tiny"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			skip, name := tt.filters.Skip(tt.file)
+			if skip != tt.wantSkip || name != tt.wantName {
+				t.Errorf("FileFilterList.Skip() = (%v, '%s'), want (%v, '%s')", skip, name, tt.wantSkip, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+EnvPrefix is the prefix of environment variables that hold configuration settings.
+e.g. MEDIAFILER_DRY_RUN=true or MEDIAFILER_EXIFTOOL_BINARY=/opt/bin/exiftool
+*/
+const EnvPrefix string = "MEDIAFILER_"
+
+const ENV_SOURCE string = "environment"
+
+/*
+Layer holds the settings read from a single configuration source, such as a
+configuration file, before they're merged with flags and other sources.
+*/
+type Layer struct {
+	Name     string
+	Settings map[string]interface{}
+}
+
+/*
+findConfigFiles() looks for a configuration file in each of the search paths.
+Search paths are given from the highest precedence to the lowest, and the files
+found are returned in the order they should be loaded, lowest precedence first.
+*/
+func findConfigFiles(searchPaths []string) ([]string, error) {
+	var files []string
+
+	for idx := len(searchPaths) - 1; idx >= 0; idx-- {
+		dir := os.ExpandEnv(searchPaths[idx])
+
+		for _, name := range []string{"mediafiler.yaml", "mediafiler.yml"} {
+			file := filepath.Join(dir, name)
+
+			info, err := os.Stat(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			if !info.IsDir() {
+				files = append(files, file)
+				break
+			}
+		}
+	}
+
+	return files, nil
+}
+
+/*
+envKey returns the name of the environment variable for a configuration key.
+*/
+func envKey(key string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+/*
+envLayer() collects configuration settings from environment variables, given as
+'KEY=value' strings like os.Environ() returns. Values are read as YAML, so lists
+and rules can be given in flow style, e.g.
+
+	MEDIAFILER_JUNK_FILES='[Thumbs.db, .DS_Store]'
+
+Values of settings that are strings are used as-is.
+*/
+func envLayer(environ []string) Layer {
+	layer := Layer{Name: ENV_SOURCE, Settings: make(map[string]interface{})}
+
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok {
+			env[name] = value
+		}
+	}
+
+	t := reflect.TypeOf(FileConfig{})
+	for idx := 0; idx < t.NumField(); idx++ {
+		key, _, ok := configTag(t.Field(idx))
+		if !ok {
+			continue
+		}
+
+		value, ok := env[envKey(key)]
+		if !ok {
+			continue
+		}
+
+		if t.Field(idx).Type.Kind() == reflect.String {
+			layer.Settings[key] = value
+			continue
+		}
+
+		if literalKeys()[key] {
+			var node yaml.Node
+			if err := yaml.Unmarshal([]byte(value), &node); err == nil && len(node.Content) == 1 {
+				layer.Settings[key] = literalValue(node.Content[0])
+				continue
+			}
+		}
+
+		// values that aren't valid YAML are kept as strings, and reported as the wrong
+		// type when the configuration is processed
+		var parsed interface{}
+		if err := yaml.Unmarshal([]byte(value), &parsed); err != nil {
+			layer.Settings[key] = value
+		} else {
+			layer.Settings[key] = parsed
+		}
+	}
+
+	return layer
+}
+
+/*
+LayerNames() returns the names of the loaded layers, in the order they're applied.
+*/
+func (c *Config) LayerNames() []string {
+	var names []string
+	for _, layer := range c.Layers {
+		names = append(names, layer.Name)
+	}
+	return names
+}
+
+/*
+EffectiveSettings() returns the configuration that results from merging every
+loaded layer along with command line flags, in the same form as a configuration
+file.
+*/
+func (c *Config) EffectiveSettings() map[string]interface{} {
+	settings := make(map[string]interface{})
+	keys, merge := fileConfigKeys()
+
+	for _, key := range keys {
+		switch merge[key] {
+		case "":
+			if c.IsSet(key) {
+				settings[key] = c.Get(key)
+			}
+			continue
+
+		case "map":
+			entries := make(map[string]interface{})
+			for _, layer := range c.Layers {
+				if m, ok := layer.Settings[key].(map[string]interface{}); ok {
+					for k, v := range m {
+						entries[k] = v
+					}
+				}
+			}
+
+			if len(entries) > 0 {
+				settings[key] = entries
+			}
+			continue
+		}
+
+		var entries []interface{}
+		for _, l := range layerOrder(len(c.Layers), merge[key]) {
+			if list, ok := c.Layers[l].Settings[key].([]interface{}); ok {
+				entries = append(entries, list...)
+			}
+		}
+
+		if len(entries) > 0 {
+			settings[key] = entries
+		}
+	}
+
+	return settings
+}
+
+/*
+readLiteralSettings() replaces the values of literal keys in settings with the values
+exactly as they're written in the YAML contents.
+*/
+func readLiteralSettings(contents []byte, settings map[string]interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(contents, &doc); err != nil {
+		return err
+	}
+
+	if len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+
+	literal := literalKeys()
+	root := doc.Content[0]
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		key := strings.ToLower(root.Content[idx].Value)
+		if literal[key] {
+			settings[key] = literalValue(root.Content[idx+1])
+		}
+	}
+
+	return nil
+}
+
+/*
+literalValue() converts a YAML node into a value, keeping mapping keys and scalars as
+the strings they're written as. Other values are decoded as usual.
+*/
+func literalValue(node *yaml.Node) interface{} {
+	switch {
+	case node.Kind == yaml.MappingNode:
+		m := make(map[string]interface{})
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			m[node.Content[idx].Value] = literalValue(node.Content[idx+1])
+		}
+		return m
+
+	case node.Kind == yaml.ScalarNode && node.Tag == "!!null":
+		return nil
+
+	case node.Kind == yaml.ScalarNode:
+		return node.Value
+	}
+
+	var value interface{}
+	node.Decode(&value)
+	return value
+}
+
+/*
+settingSource() returns the name of the layer a setting was last set in.
+*/
+func (c *Config) settingSource(key string) string {
+	for idx := len(c.Layers) - 1; idx >= 0; idx-- {
+		if _, ok := c.Layers[idx].Settings[key]; ok {
+			return c.Layers[idx].Name
+		}
+	}
+	return "command line"
+}
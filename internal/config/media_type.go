@@ -0,0 +1,133 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"go.uber.org/multierr"
+)
+
+/*
+MediaTypeRule describes a MIME type (e.g. 'image') or type and subtype (e.g. 'application/pdf')
+that mediafiler will file, along with where files of that type should go.
+*/
+type MediaTypeRule struct {
+	MIME         string
+	DestRoot     string
+	PathTemplate string
+	pathTemplate *nametmpl.Template
+}
+
+func (m MediaTypeRule) IsValid() (bool, error) {
+	var merr error
+	var valid = true
+
+	mimeType, mimeSubType, hasSubType := strings.Cut(m.MIME, "/")
+	if mimeType == "" || (hasSubType && mimeSubType == "") {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "MIME", Err: fmt.Errorf("MIME value '%s' should look like 'type' or 'type/subtype'", m.MIME)})
+		valid = false
+	}
+
+	if m.PathTemplate == "" {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "PathTemplate", Err: errors.New("the path template cannot be empty")})
+		valid = false
+	} else if _, err := nametmpl.Parse(m.PathTemplate); err != nil {
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "PathTemplate", Err: fmt.Errorf("path template is not valid. reason: %s", err)})
+		valid = false
+	}
+
+	return valid, merr
+}
+
+/*
+Matches() reports whether a file's MIME type and subtype are covered by the rule.
+*/
+func (m MediaTypeRule) Matches(mimeType string, mimeSubType string) bool {
+	ruleType, ruleSubType, hasSubType := strings.Cut(m.MIME, "/")
+
+	if ruleType != mimeType {
+		return false
+	}
+
+	return !hasSubType || ruleSubType == mimeSubType
+}
+
+/*
+DestinationRoot() returns the root directory files of this type are filed into.
+Relative DestRoot values are relative to defaultRoot.
+*/
+func (m MediaTypeRule) DestinationRoot(defaultRoot string) string {
+	switch {
+	case m.DestRoot == "":
+		return defaultRoot
+	case filepath.IsAbs(m.DestRoot):
+		return m.DestRoot
+	default:
+		return filepath.Join(defaultRoot, m.DestRoot)
+	}
+}
+
+/*
+RenderPath() renders the rule's path template.
+*/
+func (m MediaTypeRule) RenderPath(fields nametmpl.Fields) (string, error) {
+	if m.pathTemplate == nil {
+		return "", fmt.Errorf("media type rule for '%s' was not added via AddRule()", m.MIME)
+	}
+
+	return m.pathTemplate.RenderPath(fields)
+}
+
+/*
+Uses() reports whether the rule's path template references any of the named fields.
+*/
+func (m MediaTypeRule) Uses(fields ...string) bool {
+	return m.pathTemplate != nil && m.pathTemplate.Uses(fields...)
+}
+
+type MediaTypeRouter []MediaTypeRule
+
+func (r *MediaTypeRouter) AddRule(newRule MediaTypeRule) error {
+	valid, err := newRule.IsValid()
+	if !valid {
+		return fmt.Errorf("new media type rule is not valid. reason: %s", err)
+	}
+
+	newRule.pathTemplate, _ = nametmpl.Parse(newRule.PathTemplate)
+	*r = append(*r, newRule)
+	return nil
+}
+
+/*
+Match() returns the first rule that covers the given MIME type and subtype.
+*/
+func (r MediaTypeRouter) Match(mimeType string, mimeSubType string) (MediaTypeRule, bool) {
+	for _, v := range r {
+		if v.Matches(mimeType, mimeSubType) {
+			return v, true
+		}
+	}
+
+	return MediaTypeRule{}, false
+}
+
+/*
+DefaultMediaTypeRouter() returns the media types mediafiler handles when none are configured:
+images and videos, filed with the given path template.
+*/
+func DefaultMediaTypeRouter(pathTemplate string) (MediaTypeRouter, error) {
+	var router MediaTypeRouter
+	var merr error
+
+	for _, mime := range []string{"image", "video"} {
+		if err := router.AddRule(MediaTypeRule{MIME: mime, PathTemplate: pathTemplate}); err != nil {
+			merr = multierr.Append(merr, err)
+		}
+	}
+
+	return router, merr
+}
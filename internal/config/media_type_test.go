@@ -0,0 +1,101 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+)
+
+/*
+This test verifies the IsValid method passes/fails individual media type rules correctly
+*/
+func TestMediaTypeRule_IsValid(t *testing.T) {
+	tests := []struct {
+		name string
+		rule MediaTypeRule
+		want bool
+	}{
+		{"valid type only", MediaTypeRule{MIME: "image", PathTemplate: nametmpl.DefaultPathTemplate}, true},
+		{"valid type and subtype", MediaTypeRule{MIME: "application/pdf", PathTemplate: nametmpl.DefaultPathTemplate}, true},
+		{"valid with dest root", MediaTypeRule{MIME: "audio", DestRoot: "/srv/audio", PathTemplate: "{{.Year}}"}, true},
+
+		{"invalid empty MIME", MediaTypeRule{MIME: "", PathTemplate: nametmpl.DefaultPathTemplate}, false},
+		{"invalid empty subtype", MediaTypeRule{MIME: "image/", PathTemplate: nametmpl.DefaultPathTemplate}, false},
+		{"invalid empty type", MediaTypeRule{MIME: "/jpeg", PathTemplate: nametmpl.DefaultPathTemplate}, false},
+		{"invalid empty template", MediaTypeRule{MIME: "image", PathTemplate: ""}, false},
+		{"invalid template syntax", MediaTypeRule{MIME: "image", PathTemplate: "{{.Year"}, false},
+		{"invalid template field", MediaTypeRule{MIME: "image", PathTemplate: "{{.Yaer}}"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, e := tt.rule.IsValid(); got != tt.want {
+				t.Errorf("MediaTypeRule.IsValid() = %v, want %v", got, tt.want)
+				t.Logf("e: %v", e)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that Match picks the first rule covering a MIME type and subtype
+*/
+func TestMediaTypeRouter_Match(t *testing.T) {
+	var r MediaTypeRouter
+	r.AddRule(MediaTypeRule{MIME: "application/pdf", DestRoot: "scans", PathTemplate: "{{.Year}}"})
+	r.AddRule(MediaTypeRule{MIME: "image", PathTemplate: nametmpl.DefaultPathTemplate})
+	r.AddRule(MediaTypeRule{MIME: "image/jpeg", DestRoot: "never-used", PathTemplate: nametmpl.DefaultPathTemplate})
+	r.AddRule(MediaTypeRule{MIME: "", PathTemplate: nametmpl.DefaultPathTemplate}) // should fail to add
+
+	if l := len(r); l != 3 {
+		t.Fatalf("MediaTypeRouter.AddRule(): rule count is %v, expected 3", l)
+	}
+
+	tests := []struct {
+		mimeType    string
+		mimeSubType string
+		found       bool
+		wantMIME    string
+	}{
+		{"application", "pdf", true, "application/pdf"},
+		{"application", "zip", false, ""},
+		{"image", "jpeg", true, "image"},
+		{"image", "x-canon-cr2", true, "image"},
+		{"video", "mp4", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mimeType+"/"+tt.mimeSubType, func(t *testing.T) {
+			got, found := r.Match(tt.mimeType, tt.mimeSubType)
+			if found != tt.found {
+				t.Fatalf("MediaTypeRouter.Match() found = %v, want %v", found, tt.found)
+			}
+			if got.MIME != tt.wantMIME {
+				t.Errorf("MediaTypeRouter.Match() matched '%s', want '%s'", got.MIME, tt.wantMIME)
+			}
+		})
+	}
+}
+
+/*
+This test verifies destination roots are resolved against the default root when relative
+*/
+func TestMediaTypeRule_DestinationRoot(t *testing.T) {
+	tests := []struct {
+		destRoot string
+		want     string
+	}{
+		{"", "/dest"},
+		{"scans", "/dest/scans"},
+		{"/srv/audio", "/srv/audio"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destRoot, func(t *testing.T) {
+			rule := MediaTypeRule{MIME: "audio", DestRoot: tt.destRoot}
+			if got := rule.DestinationRoot("/dest"); got != tt.want {
+				t.Errorf("MediaTypeRule.DestinationRoot() = '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
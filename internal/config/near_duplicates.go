@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/phash"
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	NEAR_DUPLICATES_REPORT string = "report" // file near-duplicates as usual, and log what they're close to
+	NEAR_DUPLICATES_REVIEW string = "review" // move near-duplicates to 'near-duplicate-dir' for someone to look at
+)
+
+const (
+	DEFAULT_NEAR_DUPLICATE_HASH     phash.Algorithm = phash.DHASH
+	DEFAULT_NEAR_DUPLICATE_DISTANCE int             = 6
+	DEFAULT_NEAR_DUPLICATE_DIR      string          = "near-duplicates"
+)
+
+/*
+NearDuplicateRules describes how images that look the same as one already in the
+destination, but aren't identical to it, are found and handled. An empty Mode turns
+the check off.
+*/
+type NearDuplicateRules struct {
+	Mode        string
+	Algorithm   phash.Algorithm
+	MaxDistance int
+	Dir         string
+}
+
+/*
+Enabled() reports whether images are checked for near-duplicates.
+*/
+func (n NearDuplicateRules) Enabled() bool {
+	return n.Mode != ""
+}
+
+/*
+buildNearDuplicateRules() reads the near-duplicate settings, reporting problems at the key
+that needs fixing.
+*/
+func (c *Config) buildNearDuplicateRules() (NearDuplicateRules, error) {
+	rules := NearDuplicateRules{
+		Algorithm:   DEFAULT_NEAR_DUPLICATE_HASH,
+		MaxDistance: DEFAULT_NEAR_DUPLICATE_DISTANCE,
+		Dir:         DEFAULT_NEAR_DUPLICATE_DIR,
+	}
+	var merr error
+
+	invalid := func(key string, err error) {
+		merr = multierror.Append(merr, &ValidationError{Source: c.settingSource(key), Location: key, Err: err})
+	}
+
+	switch mode := c.GetString("near-duplicates"); mode {
+	case "", NEAR_DUPLICATES_REPORT, NEAR_DUPLICATES_REVIEW:
+		rules.Mode = mode
+	default:
+		invalid("near-duplicates", fmt.Errorf("'%s' should be '%s' or '%s'", mode, NEAR_DUPLICATES_REPORT, NEAR_DUPLICATES_REVIEW))
+	}
+
+	if c.IsSet("near-duplicate-hash") {
+		if algorithm := phash.Algorithm(c.GetString("near-duplicate-hash")); algorithm.IsValid() {
+			rules.Algorithm = algorithm
+		} else {
+			invalid("near-duplicate-hash", fmt.Errorf("'%s' should be '%s' or '%s'", algorithm, phash.DHASH, phash.PHASH))
+		}
+	}
+
+	if c.IsSet("near-duplicate-distance") {
+		if distance := c.GetInt("near-duplicate-distance"); distance >= 0 && distance <= 64 {
+			rules.MaxDistance = distance
+		} else {
+			invalid("near-duplicate-distance", fmt.Errorf("%d should be between 0 and 64 bits", distance))
+		}
+	}
+
+	if dir := c.GetString("near-duplicate-dir"); dir != "" {
+		rules.Dir = dir
+	}
+
+	return rules, merr
+}
@@ -0,0 +1,24 @@
+package config
+
+import (
+	"fmt"
+)
+
+const (
+	ORIGINAL_NAMES_MANIFEST string = "manifest" // record original names in a CSV file in each destination directory
+	ORIGINAL_NAMES_SIDECAR  string = "sidecar"  // record original names in an XMP sidecar next to each filed file
+)
+
+/*
+buildOriginalNames() reads where the original names of filed files are recorded. Empty if
+they aren't.
+*/
+func (c *Config) buildOriginalNames() (string, error) {
+	switch mode := c.GetString("original-names"); mode {
+	case "", ORIGINAL_NAMES_MANIFEST, ORIGINAL_NAMES_SIDECAR:
+		return mode, nil
+	default:
+		return "", &ValidationError{Source: c.settingSource("original-names"), Location: "original-names",
+			Err: fmt.Errorf("'%s' should be '%s' or '%s'", mode, ORIGINAL_NAMES_MANIFEST, ORIGINAL_NAMES_SIDECAR)}
+	}
+}
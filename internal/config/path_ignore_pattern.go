@@ -3,15 +3,51 @@ package config
 import (
 	"errors"
 	"fmt"
+	pathpkg "path"
+	"path/filepath"
 	"regexp"
 	"strings"
 
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/d0ct0rvenkman/mediafiler/internal/ignore"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
 	"go.uber.org/multierr"
 )
 
+/*
+PathIgnorePattern describes paths that mediafiler should leave alone. Patterns are
+matched against full paths, with directories ending in a separator.
+
+  - string: the path contains Pattern
+  - regex: the path matches the regular expression in Pattern
+  - glob: the path, or a directory above it, matches the glob in Pattern ('**' matches
+    any number of directories)
+  - gitignore: the path, or a directory above it, matches the gitignore-style pattern
+    in Pattern. Patterns containing a slash are anchored at the root of the filesystem.
+*/
 type PathIgnorePattern struct {
-	Type    string
-	Pattern string
+	Type      string
+	Pattern   string
+	rx        *regexp.Regexp
+	gitignore ignore.Rule
+}
+
+/*
+Equal() reports whether two patterns are the same.
+*/
+func (p PathIgnorePattern) Equal(other PathIgnorePattern) bool {
+	return p.Type == other.Type && p.Pattern == other.Pattern
+}
+
+/*
+regex() returns the pattern's compiled regular expression, compiling it if the
+pattern wasn't added through PathIgnoreFilter.AddPattern().
+*/
+func (p PathIgnorePattern) regex() (*regexp.Regexp, error) {
+	if p.rx != nil {
+		return p.rx, nil
+	}
+	return regexp.Compile(p.Pattern)
 }
 
 func (p PathIgnorePattern) IsValid() (bool, error) {
@@ -27,11 +63,24 @@ func (p PathIgnorePattern) IsValid() (bool, error) {
 	case `regex`:
 		_, err = regexp.Compile(p.Pattern)
 		if err != nil {
-			err = errors.New("value for Pattern is not a valid regex")
+			err = &strmanip.RuleError{Field: "Pattern", Err: errors.New("value for Pattern is not a valid regex")}
+			valid = false
+		}
+	case `glob`:
+		if !doublestar.ValidatePattern(p.Pattern) {
+			err = &strmanip.RuleError{Field: "Pattern", Err: errors.New("value for Pattern is not a valid glob")}
+			valid = false
+		}
+	case `gitignore`:
+		if _, ok, perr := ignore.ParseRule(p.Pattern); perr != nil {
+			err = &strmanip.RuleError{Field: "Pattern", Err: perr}
+			valid = false
+		} else if !ok && p.Pattern != "" {
+			err = &strmanip.RuleError{Field: "Pattern", Err: errors.New("value for Pattern is a comment")}
 			valid = false
 		}
 	default:
-		err = fmt.Errorf("invalid Type '%s'", p.Type)
+		err = &strmanip.RuleError{Field: "Type", Err: fmt.Errorf("invalid Type '%s'", p.Type)}
 	}
 
 	if err != nil {
@@ -41,7 +90,7 @@ func (p PathIgnorePattern) IsValid() (bool, error) {
 
 	// the thing we're searching for shouldn't be empty
 	if len(p.Pattern) == 0 {
-		merr = multierr.Append(merr, errors.New("the Pattern cannot be empty"))
+		merr = multierr.Append(merr, &strmanip.RuleError{Field: "Pattern", Err: errors.New("the Pattern cannot be empty")})
 		valid = false
 	}
 
@@ -49,34 +98,103 @@ func (p PathIgnorePattern) IsValid() (bool, error) {
 
 }
 
+/*
+PathIgnoreFilter holds a list of patterns for paths that should be ignored. Regular
+expressions are compiled when patterns are added, and a PathIgnoreFilter is safe for
+concurrent use once all of its patterns have been added.
+*/
 type PathIgnoreFilter []PathIgnorePattern
 
 func (p *PathIgnoreFilter) AddPattern(newPattern PathIgnorePattern) error {
 	valid, err := newPattern.IsValid()
 	if !valid {
 		return fmt.Errorf("new pattern is not valid. reason: %s", err)
-	} else {
-		*p = append(*p, newPattern)
-		return nil
 	}
+
+	switch newPattern.Type {
+	case "regex":
+		if newPattern.rx, err = regexp.Compile(newPattern.Pattern); err != nil {
+			return fmt.Errorf("new pattern could not be compiled. reason: %s", err)
+		}
+	case "gitignore":
+		if newPattern.gitignore, _, err = ignore.ParseRule(newPattern.Pattern); err != nil {
+			return fmt.Errorf("new pattern could not be compiled. reason: %s", err)
+		}
+	}
+
+	*p = append(*p, newPattern)
+	return nil
 }
 
 func (p *PathIgnoreFilter) IsPathFiltered(path string) (bool, error) {
 	for _, v := range *p {
-		switch v.Type {
-		case "string":
-			if strings.Contains(path, v.Pattern) {
-				return true, nil
-			}
-		case "regex":
-			rx := regexp.MustCompile(v.Pattern)
-			if rx.MatchString(path) {
-				return true, nil
-			}
-		default:
-			return false, errors.New("non-sensical Type found while executing IsPathFiltered()")
+		if matched, err := v.Matches(path); err != nil || matched {
+			return matched, err
 		}
 	}
 
 	return false, nil
 }
+
+/*
+Matches() reports whether path is matched by the pattern. Directories end in a separator.
+*/
+func (p PathIgnorePattern) Matches(path string) (bool, error) {
+	switch p.Type {
+	case "string":
+		return strings.Contains(path, p.Pattern), nil
+	case "regex":
+		rx, err := p.regex()
+		if err != nil {
+			return false, fmt.Errorf("pattern '%s' is not a valid regex. reason: %s", p.Pattern, err)
+		}
+		return rx.MatchString(path), nil
+	case "glob":
+		return matchGlob(p.Pattern, path), nil
+	case "gitignore":
+		rule := p.gitignore
+		if rule.Text == "" {
+			// the pattern wasn't added through AddPattern
+			var err error
+			if rule, _, err = ignore.ParseRule(p.Pattern); err != nil {
+				return false, err
+			}
+		}
+
+		relPath, isDir := slashPath(path)
+		return (ignore.Rules{rule}).Ignored(strings.TrimPrefix(relPath, "/"), isDir), nil
+	}
+
+	return false, errors.New("non-sensical Type found while executing IsPathFiltered()")
+}
+
+/*
+slashPath() converts path to use '/' separators, and reports whether it names a
+directory (by ending in a separator).
+*/
+func slashPath(path string) (string, bool) {
+	path = filepath.ToSlash(path)
+	if path != "/" && strings.HasSuffix(path, "/") {
+		return strings.TrimSuffix(path, "/"), true
+	}
+	return path, false
+}
+
+/*
+matchGlob() reports whether path, or any of the directories above it, match pattern.
+*/
+func matchGlob(pattern string, path string) bool {
+	path, _ = slashPath(path)
+
+	for {
+		if match, _ := doublestar.Match(pattern, path); match {
+			return true
+		}
+
+		parent := pathpkg.Dir(path)
+		if parent == path || parent == "." || parent == "/" {
+			return false
+		}
+		path = parent
+	}
+}
@@ -26,7 +26,16 @@ func TestPathIgnorePattern_IsValid(t *testing.T) {
 		{"invalid string replace empty Find", fields{Type: "string", Pattern: ""}, false},
 
 		{"valid regex replace", fields{Type: "regex", Pattern: "^$"}, true},
-		{"invalid regex replace bad Find regex", fields{Type: "regex", Pattern: "^(("}, false}}
+		{"invalid regex replace bad Find regex", fields{Type: "regex", Pattern: "^(("}, false},
+
+		{"valid glob", fields{Type: "glob", Pattern: "**/.thumbnails/**"}, true},
+		{"invalid glob", fields{Type: "glob", Pattern: "[abc"}, false},
+		{"invalid glob empty Pattern", fields{Type: "glob", Pattern: ""}, false},
+
+		{"valid gitignore", fields{Type: "gitignore", Pattern: ".git/"}, true},
+		{"valid gitignore negated", fields{Type: "gitignore", Pattern: "!keep.jpg"}, true},
+		{"invalid gitignore comment", fields{Type: "gitignore", Pattern: "# comment"}, false},
+		{"invalid gitignore empty Pattern", fields{Type: "gitignore", Pattern: ""}, false}}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			p := PathIgnorePattern{
@@ -100,7 +109,7 @@ func TestIgnoreFilterAddRule(t *testing.T) {
 		t.Errorf("PathIgnorePattern.AddRule(): rule count is %v, expected 5", l)
 	}
 
-	if p[1] != rule3 {
+	if !p[1].Equal(rule3) {
 		t.Errorf("PathIgnorePattern.AddRule(): rule 1 is expected to match test rule 3, but doesn't")
 	}
 }
@@ -146,3 +155,93 @@ func TestPathIgnoreFilter_IsPathFiltered(t *testing.T) {
 		})
 	}
 }
+
+/*
+This test verifies glob and gitignore patterns match paths and the directories above them.
+*/
+func TestPathIgnoreFilter_IsPathFiltered_Globs(t *testing.T) {
+	var p PathIgnoreFilter
+	for _, pattern := range []PathIgnorePattern{
+		{Type: "glob", Pattern: "**/.thumbnails"},
+		{Type: "glob", Pattern: "/srv/photos/*.tmp"},
+		{Type: "gitignore", Pattern: ".git/"},
+		{Type: "gitignore", Pattern: "*.xmp"},
+	} {
+		if err := p.AddPattern(pattern); err != nil {
+			t.Fatalf("PathIgnoreFilter.AddPattern(%v) failed: %s", pattern, err)
+		}
+	}
+
+	tests := []struct {
+		testPath string
+		want     bool
+	}{
+		{testPath: "/srv/photos/.thumbnails/", want: true},
+		{testPath: "/srv/photos/.thumbnails/IMG_0001.JPG", want: true},
+		{testPath: "/srv/photos/thumbnails/IMG_0001.JPG", want: false},
+		{testPath: "/srv/photos/upload.tmp", want: true},
+		{testPath: "/srv/photos/2024/upload.tmp", want: false},
+		{testPath: "/srv/photos/.git/", want: true},
+		{testPath: "/srv/photos/.git/objects/ab/cdef", want: true},
+		{testPath: "/srv/photos/.git", want: false},
+		{testPath: "/srv/photos/IMG_0001.xmp", want: true},
+		{testPath: "/srv/photos/IMG_0001.JPG", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testPath, func(t *testing.T) {
+			got, err := p.IsPathFiltered(tt.testPath)
+			if err != nil {
+				t.Fatalf("PathIgnoreFilter.IsPathFiltered() failed: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("PathIgnoreFilter.IsPathFiltered() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that patterns which bypassed AddPattern produce errors instead of panics.
+*/
+func TestPathIgnoreFilter_IsPathFiltered_Unvalidated(t *testing.T) {
+	p := PathIgnoreFilter{PathIgnorePattern{Type: "regex", Pattern: "^(("}}
+
+	if _, err := p.IsPathFiltered("/path/to/file"); err == nil {
+		t.Errorf("PathIgnoreFilter.IsPathFiltered() succeeded with an invalid regex")
+	}
+}
+
+func benchmarkFilter(b *testing.B) PathIgnoreFilter {
+	var p PathIgnoreFilter
+
+	for i := 0; i < 10; i++ {
+		if err := p.AddPattern(PathIgnorePattern{Type: "regex", Pattern: fmt.Sprintf(`/\.cache%d/.*\.tmp$`, i)}); err != nil {
+			b.Fatal(err)
+		}
+		if err := p.AddPattern(PathIgnorePattern{Type: "string", Pattern: fmt.Sprintf("/.sync%d/", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return p
+}
+
+func BenchmarkPathIgnoreFilter_IsPathFiltered(b *testing.B) {
+	p := benchmarkFilter(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.IsPathFiltered("/srv/photos/incoming/DCIM/100CANON/IMG_1234.JPG")
+	}
+}
+
+func BenchmarkPathIgnoreFilter_IsPathFiltered_Parallel(b *testing.B) {
+	p := benchmarkFilter(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.IsPathFiltered("/srv/photos/incoming/DCIM/100CANON/IMG_1234.JPG")
+		}
+	})
+}
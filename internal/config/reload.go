@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+)
+
+/*
+Rules holds everything built from the configuration that's used to decide what
+happens to each file. A Config embeds its Rules, so e.g. cfg.ModelReplacer can be
+used directly.
+*/
+type Rules struct {
+	ModelReplacer    strmanip.Replacer
+	PathIgnorer      PathIgnoreFilter
+	FileFilters      FileFilterList
+	MediaTypes       MediaTypeRouter
+	Cameras          CameraAliases
+	FilenameTemplate *nametmpl.Template
+	Events           EventRules
+	NearDuplicates   NearDuplicateRules
+	Duplicates       DuplicateRules
+	OriginalNames    string // where original names are recorded: ORIGINAL_NAMES_MANIFEST, ORIGINAL_NAMES_SIDECAR or empty
+}
+
+/*
+Reload() reads the configuration again from the same places it was first read from,
+and swaps in the rules built from it. If the new configuration can't be read or has
+any problems, it's rejected and the configuration already in use is kept.
+
+Reload() isn't safe to call while the configuration is in use, so callers should
+reload between files rather than while one is being filed.
+*/
+func (c *Config) Reload() error {
+	next := &Config{Viper: c.newReader(), FS: c.FS, configPaths: c.configPaths}
+
+	if loaded, err := next.ReadConfiguration(); !loaded {
+		return fmt.Errorf("configuration could not be loaded. reason: %s", err)
+	}
+
+	rules, err := next.buildRules()
+	if err != nil {
+		return err
+	}
+
+	c.Viper, c.Layers, c.Rules = next.Viper, next.Layers, *rules
+	return nil
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+)
+
+/*
+FileConfig describes everything that can be set in a configuration file. Keys are
+named by the 'config' struct tag, and keys marked 'required' must be present.
+
+Settings from later configuration layers replace those from earlier ones, except
+for rule lists and maps. Entries of lists marked 'append' are added after those from
+earlier layers, and entries of lists marked 'prepend' are added before them, so they're
+checked first. Entries of maps are merged, with later layers replacing entries with
+the same key.
+
+Keys and values of maps marked 'literal' are used exactly as written in YAML, so that
+e.g. serial numbers with leading zeros aren't read as numbers.
+*/
+type FileConfig struct {
+	Debug                 bool                      `config:"debug"`
+	DryRun                bool                      `config:"dry-run"`
+	ExiftoolBinary        string                    `config:"exiftool-binary"`
+	Wait                  bool                      `config:"wait"`
+	CleanupEmptyDirs      bool                      `config:"cleanup-empty-dirs"`
+	ModelReplaceRules     []ReplaceRuleConfig       `config:"model-replace-rules,append"`
+	PathIgnorePatterns    []PathIgnorePatternConfig `config:"path-ignore-patterns,append"`
+	FileFilters           []FileFilterConfig        `config:"file-filters,append"`
+	PathTemplate          string                    `config:"path-template"`
+	MediaTypes            []MediaTypeConfig         `config:"media-types,prepend"`
+	UnsortedDir           string                    `config:"unsorted-dir"`
+	UnfiledDir            string                    `config:"unfiled-dir"`
+	JunkFiles             []string                  `config:"junk-files"`
+	FilenameTemplate      string                    `config:"filename-template"`
+	Cameras               map[string]string         `config:"cameras,literal"`
+	LocationUnknown       string                    `config:"location-unknown"`
+	EventGap              string                    `config:"event-gap"`
+	EventDistance         float64                   `config:"event-distance"`
+	EventMarkerFile       string                    `config:"event-marker-file"`
+	NearDuplicates        string                    `config:"near-duplicates"`
+	NearDuplicateHash     string                    `config:"near-duplicate-hash"`
+	NearDuplicateDistance int                       `config:"near-duplicate-distance"`
+	NearDuplicateDir      string                    `config:"near-duplicate-dir"`
+	DuplicateHash         string                    `config:"duplicate-hash"`
+	DuplicateCompare      string                    `config:"duplicate-compare"`
+	WriteBackDates        bool                      `config:"write-back-dates"`
+	WriteBackSourcePath   bool                      `config:"write-back-source-path"`
+	OriginalNames         string                    `config:"original-names"`
+	Catalog               string                    `config:"catalog"`
+}
+
+/*
+fileConfigKeys returns the keys of FileConfig, along with how each key is merged
+across layers: "append", "prepend", "map" or "" for replace.
+*/
+func fileConfigKeys() ([]string, map[string]string) {
+	var keys []string
+	merge := make(map[string]string)
+
+	t := reflect.TypeOf(FileConfig{})
+	for idx := 0; idx < t.NumField(); idx++ {
+		key, opts, ok := configTag(t.Field(idx))
+		if !ok {
+			continue
+		}
+
+		keys = append(keys, key)
+		if t.Field(idx).Type.Kind() == reflect.Map {
+			merge[key] = "map"
+		}
+		for _, opt := range opts {
+			if opt == "append" || opt == "prepend" {
+				merge[key] = opt
+			}
+		}
+	}
+
+	return keys, merge
+}
+
+/*
+literalKeys returns the keys of FileConfig whose values are read exactly as written.
+*/
+func literalKeys() map[string]bool {
+	literal := make(map[string]bool)
+
+	t := reflect.TypeOf(FileConfig{})
+	for idx := 0; idx < t.NumField(); idx++ {
+		key, opts, ok := configTag(t.Field(idx))
+		if ok && slices.Contains(opts, "literal") {
+			literal[key] = true
+		}
+	}
+
+	return literal
+}
+
+/*
+layerOrder returns the indexes of numLayers layers in the order their entries of a
+rule list end up in, based on how the list is merged.
+*/
+func layerOrder(numLayers int, merge string) []int {
+	order := make([]int, numLayers)
+	for idx := range order {
+		if merge == "prepend" {
+			order[idx] = numLayers - 1 - idx
+		} else {
+			order[idx] = idx
+		}
+	}
+	return order
+}
+
+/*
+ReplaceRuleConfig is an entry in 'model-replace-rules'. Field names match
+strmanip.ReplacerRule so that problems found by IsValid() can be traced back to
+their key.
+*/
+type ReplaceRuleConfig struct {
+	Type           string                   `config:"replace_type,required"`
+	Find           string                   `config:"find_pattern,required"`
+	ReplaceWith    string                   `config:"replace_with"`
+	Conditions     []ReplaceConditionConfig `config:"conditions"`
+	StopAfterMatch bool                     `config:"stop_after_match"`
+}
+
+func (rc ReplaceRuleConfig) ReplacerRule() strmanip.ReplacerRule {
+	rule := strmanip.ReplacerRule{Type: rc.Type, Find: rc.Find, ReplaceWith: rc.ReplaceWith, StopAfterMatch: rc.StopAfterMatch}
+	for _, cc := range rc.Conditions {
+		rule.Conditions = append(rule.Conditions, strmanip.ReplacerCondition{Tag: cc.Tag, Type: cc.Type, Pattern: cc.Pattern})
+	}
+	return rule
+}
+
+/*
+ReplaceConditionConfig is an entry in the 'conditions' of a model replace rule.
+*/
+type ReplaceConditionConfig struct {
+	Tag     string `config:"tag,required"`
+	Type    string `config:"type,required"`
+	Pattern string `config:"pattern,required"`
+}
+
+/*
+PathIgnorePatternConfig is an entry in 'path-ignore-patterns'.
+*/
+type PathIgnorePatternConfig struct {
+	Type    string `config:"type,required"`
+	Pattern string `config:"pattern,required"`
+}
+
+func (pc PathIgnorePatternConfig) PathIgnorePattern() PathIgnorePattern {
+	return PathIgnorePattern{Type: pc.Type, Pattern: pc.Pattern}
+}
+
+/*
+FileFilterConfig is an entry in 'file-filters'.
+*/
+type FileFilterConfig struct {
+	Name            string                   `config:"name,required"`
+	Action          string                   `config:"action,required"`
+	Path            string                   `config:"path"`
+	MIME            string                   `config:"mime"`
+	Conditions      []ReplaceConditionConfig `config:"conditions"`
+	SizeBelow       string                   `config:"size_below"`
+	SizeAbove       string                   `config:"size_above"`
+	DimensionsBelow int                      `config:"dimensions_below"`
+	TakenBefore     string                   `config:"taken_before"`
+	TakenAfter      string                   `config:"taken_after"`
+}
+
+func (fc FileFilterConfig) FileFilter() FileFilter {
+	filter := FileFilter{Name: fc.Name, Action: fc.Action, Path: fc.Path, MIME: fc.MIME, SizeBelow: fc.SizeBelow, SizeAbove: fc.SizeAbove,
+		DimensionsBelow: fc.DimensionsBelow, TakenBefore: fc.TakenBefore, TakenAfter: fc.TakenAfter}
+	for _, cc := range fc.Conditions {
+		filter.Conditions = append(filter.Conditions, strmanip.ReplacerCondition{Tag: cc.Tag, Type: cc.Type, Pattern: cc.Pattern})
+	}
+	return filter
+}
+
+/*
+MediaTypeConfig is an entry in 'media-types'. An empty PathTemplate means the
+top-level 'path-template' is used.
+*/
+type MediaTypeConfig struct {
+	MIME         string `config:"mime,required"`
+	DestRoot     string `config:"dest_root"`
+	PathTemplate string `config:"path_template"`
+}
+
+func (mc MediaTypeConfig) MediaTypeRule(defaultPathTemplate string) MediaTypeRule {
+	rule := MediaTypeRule{MIME: mc.MIME, DestRoot: mc.DestRoot, PathTemplate: mc.PathTemplate}
+	if rule.PathTemplate == "" {
+		rule.PathTemplate = defaultPathTemplate
+	}
+	return rule
+}
@@ -0,0 +1,227 @@
+/*
+Package filehash compares files by their contents, reading as little of them as it can.
+*/
+package filehash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+/*
+Algorithm is a hash used to compare the contents of files.
+*/
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha256"
+	BLAKE3 Algorithm = "blake3"
+	XXH3   Algorithm = "xxh3" // much faster, but not cryptographic. fine for telling copies apart, not for tampering
+)
+
+/*
+Algorithms are the known algorithms, in the order they're listed to users.
+*/
+var Algorithms = []Algorithm{SHA256, BLAKE3, XXH3}
+
+/*
+BlockSize is how much of the start and the end of a file are hashed before the whole file is.
+*/
+const BlockSize int64 = 64 * 1024
+
+func (a Algorithm) IsValid() bool {
+	return a == SHA256 || a == BLAKE3 || a == XXH3
+}
+
+/*
+New() returns a new hash.Hash for the algorithm.
+*/
+func (a Algorithm) New() (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case BLAKE3:
+		return blake3.New(), nil
+	case XXH3:
+		return xxh3.New(), nil
+	}
+	return nil, fmt.Errorf("'%s' is not a known hash algorithm", a)
+}
+
+/*
+FileError is a file that couldn't be hashed.
+*/
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return fmt.Sprintf("couldn't hash '%s'. %s", e.Path, e.Err)
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+/*
+fileKey identifies a file's contents as of when it was hashed, so a file that changes during
+a run is hashed again.
+*/
+type fileKey struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+type sums struct {
+	partial        []byte
+	full           []byte
+	payload        []byte
+	payloadChecked bool // whether a payload was looked for, as files without one have a nil payload
+}
+
+/*
+Cache remembers the hashes of files, so each file is read at most once per run however many
+files it's compared with. A Cache is safe for concurrent use.
+*/
+type Cache struct {
+	Algorithm Algorithm
+	Payload   bool // whether Compare() looks only at the image or video data of files it can find it in
+
+	mu   sync.Mutex
+	sums map[fileKey]*sums
+}
+
+func NewCache(algorithm Algorithm) *Cache {
+	return &Cache{Algorithm: algorithm, sums: make(map[fileKey]*sums)}
+}
+
+/*
+Same() reports whether two files have the same contents. Files of different sizes are never
+read. Otherwise the first and last BlockSize bytes of each are hashed, and the whole files are
+only hashed if those match. Errors are *FileError, naming the file that couldn't be read.
+*/
+func (c *Cache) Same(pathA string, infoA os.FileInfo, pathB string, infoB os.FileInfo) (bool, error) {
+	if infoA.Size() != infoB.Size() {
+		return false, nil
+	}
+
+	// files small enough for the head and tail blocks to cover them are hashed in full straight away
+	if infoA.Size() > 2*BlockSize {
+		partialA, err := c.sum(pathA, infoA, true)
+		if err != nil {
+			return false, err
+		}
+		partialB, err := c.sum(pathB, infoB, true)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(partialA, partialB) {
+			return false, nil
+		}
+	}
+
+	fullA, err := c.sum(pathA, infoA, false)
+	if err != nil {
+		return false, err
+	}
+	fullB, err := c.sum(pathB, infoB, false)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(fullA, fullB), nil
+}
+
+/*
+Sum() returns the hash of a whole file, from the cache if it's been hashed already.
+*/
+func (c *Cache) Sum(path string, info os.FileInfo) ([]byte, error) {
+	return c.sum(path, info, false)
+}
+
+/*
+entry returns the cached sums of a file, adding them if the file hasn't been seen before.
+*/
+func (c *Cache) entry(path string, info os.FileInfo) *sums {
+	key := fileKey{path: path, size: info.Size(), modTime: info.ModTime().UnixNano()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.sums[key]
+	if !ok {
+		cached = &sums{}
+		c.sums[key] = cached
+	}
+	return cached
+}
+
+func (c *Cache) sum(path string, info os.FileInfo, partial bool) ([]byte, error) {
+	cached := c.entry(path, info)
+
+	c.mu.Lock()
+	sum := cached.full
+	if partial {
+		sum = cached.partial
+	}
+	c.mu.Unlock()
+
+	if sum != nil {
+		return sum, nil
+	}
+
+	sum, err := c.hashFile(path, info.Size(), partial)
+	if err != nil {
+		return nil, &FileError{Path: path, Err: err}
+	}
+
+	c.mu.Lock()
+	if partial {
+		cached.partial = sum
+	} else {
+		cached.full = sum
+	}
+	c.mu.Unlock()
+
+	return sum, nil
+}
+
+/*
+hashFile hashes a whole file, or only its head and tail blocks if partial is set.
+*/
+func (c *Cache) hashFile(path string, size int64, partial bool) ([]byte, error) {
+	h, err := c.Algorithm.New()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if !partial {
+		if _, err := io.Copy(h, file); err != nil {
+			return nil, err
+		}
+		return h.Sum(nil), nil
+	}
+
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, BlockSize)); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(file, size-BlockSize, BlockSize)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,283 @@
+package filehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+This test verifies that files are compared correctly with each algorithm, and that files are
+only read as far as they need to be
+*/
+func TestCache_Same(t *testing.T) {
+	dir := t.TempDir()
+
+	big := bytes.Repeat([]byte("0123456789abcdef"), int(BlockSize)/4)
+	middle := append([]byte(nil), big...)
+	middle[len(middle)/2] = 'x'
+	head := append([]byte(nil), big...)
+	head[0] = 'x'
+
+	files := map[string][]byte{
+		"small":        []byte("small file"),
+		"small-copy":   []byte("small file"),
+		"small-other":  []byte("small fill"),
+		"big":          big,
+		"big-copy":     big,
+		"big-middle":   middle,
+		"big-head":     head,
+		"shorter":      big[1:],
+		"unreadable-a": big,
+	}
+	infos := make(map[string]os.FileInfo)
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		infos[name] = info
+	}
+
+	tests := []struct {
+		a, b        string
+		want        bool
+		wantPartial bool // whether the head and tail blocks were hashed
+		wantFull    bool // whether the whole files were hashed
+	}{
+		{"small", "small-copy", true, false, true},
+		{"small", "small-other", false, false, true},
+		{"big", "big-copy", true, true, true},
+		{"big", "big-middle", false, true, true},
+		{"big", "big-head", false, true, false},
+		{"big", "shorter", false, false, false},
+	}
+
+	for _, algorithm := range Algorithms {
+		t.Run(string(algorithm), func(t *testing.T) {
+			for _, tt := range tests {
+				cache := NewCache(algorithm)
+
+				got, err := cache.Same(filepath.Join(dir, tt.a), infos[tt.a], filepath.Join(dir, tt.b), infos[tt.b])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("Same(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+				}
+
+				var partial, full bool
+				for _, s := range cache.sums {
+					partial = partial || s.partial != nil
+					full = full || s.full != nil
+				}
+				if partial != tt.wantPartial || full != tt.wantFull {
+					t.Errorf("Same(%s, %s) hashed partial %v and full %v, want %v and %v", tt.a, tt.b, partial, full, tt.wantPartial, tt.wantFull)
+				}
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		missing := filepath.Join(dir, "unreadable-a")
+		if err := os.Remove(missing); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := NewCache(SHA256).Same(filepath.Join(dir, "big"), infos["big"], missing, infos["unreadable-a"])
+		var ferr *FileError
+		if !errors.As(err, &ferr) || ferr.Path != missing || !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Same() with a missing file returned '%v', want a FileError for it", err)
+		}
+	})
+}
+
+/*
+This test verifies that hashes are cached, and that a file is hashed again if it changes
+*/
+func TestCache_Sum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+
+	cache := NewCache(BLAKE3)
+	first, err := cache.Sum(path, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same size and modification time look like the same contents
+	if err := os.WriteFile(path, []byte("other"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if cached, _ := cache.Sum(path, info); !bytes.Equal(cached, first) {
+		t.Errorf("Sum() hashed an unchanged file again")
+	}
+
+	if err := os.WriteFile(path, []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changedInfo, _ := os.Stat(path)
+	if changed, _ := cache.Sum(path, changedInfo); bytes.Equal(changed, first) {
+		t.Errorf("Sum() didn't hash a changed file again")
+	}
+}
+
+/*
+box builds an ISO media box, with a 64-bit size if large is set.
+*/
+func box(boxType string, contents []byte, large bool) []byte {
+	if large {
+		header := binary.BigEndian.AppendUint32(nil, 1)
+		header = append(header, boxType...)
+		header = binary.BigEndian.AppendUint64(header, uint64(16+len(contents)))
+		return append(header, contents...)
+	}
+	header := binary.BigEndian.AppendUint32(nil, uint32(8+len(contents)))
+	header = append(header, boxType...)
+	return append(header, contents...)
+}
+
+/*
+This test verifies that payload comparisons ignore metadata in JPEG and ISO media files, and
+that other files are compared by their contents
+*/
+func TestCache_Compare(t *testing.T) {
+	dir := t.TempDir()
+
+	img := image.NewGray(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	withAPP1 := func(exif string) []byte {
+		segment := append([]byte{0xFF, 0xE1}, binary.BigEndian.AppendUint16(nil, uint16(2+len(exif)))...)
+		segment = append(segment, exif...)
+		return append(append([]byte{0xFF, 0xD8}, segment...), encoded.Bytes()[2:]...)
+	}
+	edited := append([]byte(nil), encoded.Bytes()...)
+	edited[len(edited)-10] ^= 0x01
+
+	ftyp := box("ftyp", []byte("isom\x00\x00\x02\x00isom"), false)
+	mdat := []byte("video and audio samples")
+
+	files := map[string][]byte{
+		"photo.jpg":        withAPP1("Exif\x00\x00Rating=1"),
+		"rated.jpg":        withAPP1("Exif\x00\x00Rating=5, and a longer comment"),
+		"edited.jpg":       edited,
+		"truncated.jpg":    encoded.Bytes()[:len(encoded.Bytes())/2],
+		"video.mp4":        bytes.Join([][]byte{ftyp, box("moov", []byte("title=one"), false), box("mdat", mdat, false)}, nil),
+		"retitled.mp4":     bytes.Join([][]byte{ftyp, box("mdat", mdat, true), box("moov", []byte("title=a longer title"), false)}, nil),
+		"recut.mp4":        bytes.Join([][]byte{ftyp, box("moov", []byte("title=one"), false), box("mdat", mdat[1:], false)}, nil),
+		"text.txt":         []byte("not media"),
+		"text-copy.txt":    []byte("not media"),
+		"truncated-copy":   encoded.Bytes()[:len(encoded.Bytes())/2],
+		"photo-as-text.jp": []byte("not media"),
+	}
+	infos := make(map[string]os.FileInfo)
+	for name, contents := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		infos[name], _ = os.Stat(path)
+	}
+
+	tests := []struct {
+		a, b    string
+		payload bool
+		want    bool
+		wantBy  Comparison
+	}{
+		{"photo.jpg", "rated.jpg", false, false, COMPARED_CONTENT},
+		{"photo.jpg", "rated.jpg", true, true, COMPARED_PAYLOAD},
+		{"photo.jpg", "edited.jpg", true, false, COMPARED_PAYLOAD},
+		{"video.mp4", "retitled.mp4", false, false, COMPARED_CONTENT},
+		{"video.mp4", "retitled.mp4", true, true, COMPARED_PAYLOAD},
+		{"video.mp4", "recut.mp4", true, false, COMPARED_PAYLOAD},
+		{"photo.jpg", "video.mp4", true, false, COMPARED_PAYLOAD},
+		{"text.txt", "text-copy.txt", true, true, COMPARED_CONTENT},
+		{"photo.jpg", "photo-as-text.jp", true, false, COMPARED_CONTENT},
+		{"truncated.jpg", "truncated-copy", true, true, COMPARED_CONTENT},
+	}
+
+	for _, tt := range tests {
+		cache := NewCache(XXH3)
+		cache.Payload = tt.payload
+
+		got, by, err := cache.Compare(filepath.Join(dir, tt.a), infos[tt.a], filepath.Join(dir, tt.b), infos[tt.b])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want || by != tt.wantBy {
+			t.Errorf("Compare(%s, %s) with payload %v = %v by %s, want %v by %s", tt.a, tt.b, tt.payload, got, by, tt.want, tt.wantBy)
+		}
+	}
+}
+
+/*
+This test verifies that a JPEG's scan data hashes the same however long the metadata before
+it is, including when a 0xFF in the scan data ends one read and the end of image marker is
+in the next
+*/
+func Test_hashJPEGScan(t *testing.T) {
+	dir := t.TempDir()
+
+	scan := bytes.Repeat([]byte{0x5A}, 40*1024)
+	// the first read of scan data ends with the 4096th byte after the start of image marker,
+	// which this 0xFF is with 1000 bytes of metadata, and the next read takes in the rest
+	ffAt := 4096 - 1 - 4 - 1000 - 10
+	scan[ffAt], scan[ffAt+1] = 0xFF, 0x00
+
+	want, err := XXH3.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Write(scan)
+
+	sos := []byte{0xFF, 0xDA, 0x00, 0x08, 0x01, 0x01, 0x00, 0x00, 0x3F, 0x00}
+	for appLength := 990; appLength <= 1010; appLength++ {
+		contents := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+		contents = binary.BigEndian.AppendUint16(contents, uint16(2+appLength))
+		contents = append(contents, bytes.Repeat([]byte{0x20}, appLength)...)
+		contents = append(contents, sos...)
+		contents = append(contents, scan...)
+		contents = append(contents, 0xFF, 0xD9)
+
+		path := filepath.Join(dir, "photo.jpg")
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := XXH3.New()
+		err = hashJPEGScan(file, got)
+		file.Close()
+		if err != nil {
+			t.Fatalf("hashJPEGScan() with %d bytes of metadata failed: %s", appLength, err)
+		}
+		if !bytes.Equal(got.Sum(nil), want.Sum(nil)) {
+			t.Errorf("hashJPEGScan() with %d bytes of metadata didn't hash the scan data", appLength)
+		}
+	}
+}
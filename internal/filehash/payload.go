@@ -0,0 +1,268 @@
+package filehash
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"os"
+)
+
+/*
+Comparison is how two files were found to be the same, or not.
+*/
+type Comparison string
+
+const (
+	COMPARED_CONTENT Comparison = "content" // the whole files were compared
+	COMPARED_PAYLOAD Comparison = "payload" // only the image or video data was compared, ignoring metadata
+)
+
+/*
+Comparisons are the known ways of comparing files, in the order they're listed to users.
+*/
+var Comparisons = []Comparison{COMPARED_CONTENT, COMPARED_PAYLOAD}
+
+func (c Comparison) IsValid() bool {
+	return c == COMPARED_CONTENT || c == COMPARED_PAYLOAD
+}
+
+/*
+errNoPayload means a file isn't in a format whose payload can be found, or is too damaged for
+it to be found, so it has to be compared by its whole contents.
+*/
+var errNoPayload = errors.New("payload not found")
+
+/*
+Compare() reports whether two files hold the same media. When the cache's Payload is set
+and both files are JPEGs or ISO media files (MP4, MOV, etc), only their image or video data
+is compared, so copies that differ only in their metadata are the same. Otherwise they're
+compared with Same().
+*/
+func (c *Cache) Compare(pathA string, infoA os.FileInfo, pathB string, infoB os.FileInfo) (bool, Comparison, error) {
+	if c.Payload {
+		payloadA, okA, err := c.payloadSum(pathA, infoA)
+		if err != nil {
+			return false, COMPARED_PAYLOAD, err
+		}
+
+		if okA {
+			payloadB, okB, err := c.payloadSum(pathB, infoB)
+			if err != nil {
+				return false, COMPARED_PAYLOAD, err
+			}
+			if okB {
+				return bytes.Equal(payloadA, payloadB), COMPARED_PAYLOAD, nil
+			}
+		}
+	}
+
+	same, err := c.Same(pathA, infoA, pathB, infoB)
+	return same, COMPARED_CONTENT, err
+}
+
+/*
+payloadSum returns the hash of a file's image or video data. ok is false if the file's
+payload can't be found.
+*/
+func (c *Cache) payloadSum(path string, info os.FileInfo) ([]byte, bool, error) {
+	cached := c.entry(path, info)
+
+	c.mu.Lock()
+	checked, sum := cached.payloadChecked, cached.payload
+	c.mu.Unlock()
+
+	if checked {
+		return sum, sum != nil, nil
+	}
+
+	sum, err := c.hashPayload(path, info.Size())
+	if errors.Is(err, errNoPayload) {
+		sum, err = nil, nil
+	} else if err != nil {
+		return nil, false, &FileError{Path: path, Err: err}
+	}
+
+	c.mu.Lock()
+	cached.payloadChecked, cached.payload = true, sum
+	c.mu.Unlock()
+
+	return sum, sum != nil, nil
+}
+
+func (c *Cache) hashPayload(path string, size int64) ([]byte, error) {
+	h, err := c.Algorithm.New()
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var magic [8]byte
+	if _, err := file.ReadAt(magic[:], 0); err != nil {
+		return nil, errNoPayload
+	}
+
+	switch {
+	case magic[0] == 0xFF && magic[1] == 0xD8:
+		err = hashJPEGScan(file, h)
+	case string(magic[4:8]) == "ftyp":
+		err = hashMediaData(file, size, h)
+	default:
+		err = errNoPayload
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+/*
+hashJPEGScan hashes a JPEG's entropy-coded scan data: everything after the header of the
+first start of scan (SOS) segment up to the end of image (EOI) marker. Metadata is held in
+segments before the first scan, so it's left out.
+*/
+func hashJPEGScan(file *os.File, h hash.Hash) error {
+	r := bufio.NewReader(io.NewSectionReader(file, 2, 1<<62))
+
+	// walk the segments before the first scan by their lengths
+	for {
+		marker, err := nextMarker(r)
+		if err != nil {
+			return errNoPayload
+		}
+
+		switch {
+		case marker == 0xD9: // EOI before any scan
+			return errNoPayload
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // markers without a length
+			continue
+		}
+
+		var length uint16
+		if err := binary.Read(r, binary.BigEndian, &length); err != nil || length < 2 {
+			return errNoPayload
+		}
+		if _, err := r.Discard(int(length) - 2); err != nil {
+			return errNoPayload
+		}
+
+		if marker == 0xDA {
+			break
+		}
+	}
+
+	// inside scan data a 0xFF byte is always followed by 0x00 or a restart marker, so the
+	// first 0xFF 0xD9 is the end of the image. progressive JPEGs have more tables and scans
+	// between their scans, which are hashed along with them.
+	buf := make([]byte, 64*1024)
+	prevFF := false
+	for {
+		n, err := r.Read(buf)
+		chunk := buf[:n]
+
+		if prevFF && n > 0 && chunk[0] == 0xD9 {
+			return nil
+		}
+		if idx := bytes.Index(chunk, []byte{0xFF, 0xD9}); idx >= 0 {
+			if prevFF {
+				h.Write([]byte{0xFF})
+			}
+			h.Write(chunk[:idx])
+			return nil
+		}
+
+		// a trailing 0xFF is held back until it's known not to start the EOI marker
+		if prevFF {
+			h.Write([]byte{0xFF})
+		}
+		prevFF = n > 0 && chunk[n-1] == 0xFF
+		if prevFF {
+			chunk = chunk[:n-1]
+		}
+		h.Write(chunk)
+
+		if err == io.EOF {
+			return errNoPayload
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+nextMarker reads up to and including the next JPEG marker, returning its code.
+*/
+func nextMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, errNoPayload
+	}
+
+	// markers can be padded with any number of 0xFF bytes
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+/*
+hashMediaData hashes the contents of every top-level 'mdat' box of an ISO base media file
+(MP4, MOV, HEIC, etc), which hold its audio and video. Metadata is held in other boxes,
+mostly 'moov', so it's left out.
+*/
+func hashMediaData(file *os.File, size int64, h hash.Hash) error {
+	found := false
+
+	for offset := int64(0); offset < size; {
+		var header [16]byte
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return errNoPayload
+		}
+
+		boxSize := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch boxSize {
+		case 0: // the box runs to the end of the file
+			boxSize = size - offset
+		case 1: // the size is too big for 32 bits, and follows the type
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return errNoPayload
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+
+		if boxSize < headerSize || offset+boxSize > size {
+			return errNoPayload
+		}
+
+		if boxType == "mdat" {
+			if _, err := io.Copy(h, io.NewSectionReader(file, offset+headerSize, boxSize-headerSize)); err != nil {
+				return err
+			}
+			found = true
+		}
+
+		offset += boxSize
+	}
+
+	if !found {
+		return errNoPayload
+	}
+	return nil
+}
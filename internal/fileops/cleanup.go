@@ -0,0 +1,148 @@
+package fileops
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	multierr "github.com/hashicorp/go-multierror"
+)
+
+var DefaultJunkFiles = []string{"Thumbs.db", ".DS_Store", "ZbThumbnail.info"}
+
+/*
+RemoveEmptyDirs removes the directories below root that were left empty by moving the files
+in moved out of them, or only contain files named in junkFiles. Working from the directory
+each file was moved from up to root, the deepest first, directories emptied by removing their
+children are removed too. Directories that held none of the moved files are left alone, even
+if they're empty, and root itself is never removed.
+
+skipDir is called for each directory that would be removed. Directories it returns true for
+are left alone, along with everything below them.
+
+In dry-run mode nothing is removed, and the files in moved are treated as if they were
+already gone, so the directories that would be removed are still returned.
+
+Returns the directories removed (deepest first) and any errors encountered along the way.
+*/
+func RemoveEmptyDirs(root string, moved []string, junkFiles []string, skipDir func(path string) bool, dryrun bool) ([]string, error) {
+	var removed []string
+	var merr error
+
+	root = filepath.Clean(root)
+	movedFiles := make(map[string]bool)
+	candidates := make(map[string]bool)
+
+	for _, file := range moved {
+		file = filepath.Clean(file)
+		movedFiles[file] = true
+
+		var chain []string
+		for dir := filepath.Dir(file); IsBelow(root, dir); dir = filepath.Dir(dir) {
+			if skipDir != nil && skipDir(dir) {
+				chain = nil
+				break
+			}
+			chain = append(chain, dir)
+		}
+
+		for _, dir := range chain {
+			candidates[dir] = true
+		}
+	}
+
+	dirs := make([]string, 0, len(candidates))
+	for dir := range candidates {
+		dirs = append(dirs, dir)
+	}
+
+	// deepest first, so children are dealt with before their parents
+	sort.SliceStable(dirs, func(i, j int) bool {
+		depthI, depthJ := strings.Count(dirs[i], string(os.PathSeparator)), strings.Count(dirs[j], string(os.PathSeparator))
+		if depthI != depthJ {
+			return depthI > depthJ
+		}
+		return dirs[i] < dirs[j]
+	})
+
+	gone := make(map[string]bool)
+
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				merr = multierr.Append(merr, err)
+			}
+			continue
+		}
+
+		var junk []string
+		empty := true
+
+		for _, entry := range entries {
+			entryPath := filepath.Join(dir, entry.Name())
+
+			switch {
+			case entry.IsDir() && gone[entryPath]:
+				continue
+			case movedFiles[entryPath]:
+				continue
+			case entry.Type().IsRegular() && isJunkFile(entry.Name(), junkFiles):
+				junk = append(junk, entryPath)
+			default:
+				empty = false
+			}
+
+			if !empty {
+				break
+			}
+		}
+
+		if !empty {
+			continue
+		}
+
+		if !dryrun {
+			if err = removeDir(dir, junk); err != nil {
+				merr = multierr.Append(merr, err)
+				continue
+			}
+		}
+
+		gone[dir] = true
+		removed = append(removed, dir)
+	}
+
+	return removed, merr
+}
+
+/*
+IsBelow reports whether dir is inside root, and isn't root itself.
+*/
+func IsBelow(root string, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+func removeDir(dir string, junk []string) error {
+	for _, file := range junk {
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(dir)
+}
+
+func isJunkFile(name string, junkFiles []string) bool {
+	for _, junk := range junkFiles {
+		if strings.EqualFold(name, junk) {
+			return true
+		}
+	}
+
+	return false
+}
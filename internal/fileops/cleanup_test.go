@@ -0,0 +1,134 @@
+package fileops
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func makeTree(t *testing.T, root string, files []string, dirs []string) {
+	for _, dir := range dirs {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, file := range files {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func relPaths(t *testing.T, root string, paths []string) []string {
+	var rel []string
+	for _, path := range paths {
+		r, err := filepath.Rel(root, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rel = append(rel, filepath.ToSlash(r))
+	}
+	slices.Sort(rel)
+	return rel
+}
+
+func rootPaths(root string, rel []string) []string {
+	var paths []string
+	for _, path := range rel {
+		paths = append(paths, filepath.Join(root, path))
+	}
+	return paths
+}
+
+/*
+This test verifies that directories left empty or junk-only by moving files out of them are
+removed from the bottom up, while directories with other content, directories nothing was
+moved out of, ignored directories and the root itself are kept.
+*/
+func TestRemoveEmptyDirs(t *testing.T) {
+	root := t.TempDir()
+
+	makeTree(t, root,
+		[]string{
+			"DCIM/100CANON/IMG_0001.JPG",
+			"DCIM/100CANON/Thumbs.db",
+			"DCIM/101CANON/IMG_0002.JPG",
+			"DCIM/101CANON/notes.txt",
+			"DCIM/102CANON/deeper/IMG_0003.JPG",
+			"DCIM/102CANON/deeper/.ds_store",
+			"misc/ZbThumbnail.info",
+			".git/objects/IMG_0004.JPG",
+			"Thumbs.db",
+		},
+		[]string{
+			"empty",
+		},
+	)
+
+	moved := rootPaths(root, []string{"DCIM/100CANON/IMG_0001.JPG", "DCIM/101CANON/IMG_0002.JPG", "DCIM/102CANON/deeper/IMG_0003.JPG",
+		".git/objects/IMG_0004.JPG"})
+	for _, file := range moved {
+		if err := os.Remove(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	skipDir := func(path string) bool {
+		return strings.Contains(path+string(os.PathSeparator), ".git"+string(os.PathSeparator))
+	}
+
+	removed, err := RemoveEmptyDirs(root, moved, DefaultJunkFiles, skipDir, false)
+	if err != nil {
+		t.Fatalf("RemoveEmptyDirs() failed: %s", err)
+	}
+
+	want := []string{"DCIM/100CANON", "DCIM/102CANON", "DCIM/102CANON/deeper"}
+	if got := relPaths(t, root, removed); !slices.Equal(got, want) {
+		t.Errorf("RemoveEmptyDirs() removed %v, want %v", got, want)
+	}
+
+	for _, kept := range []string{".", "DCIM", "DCIM/101CANON", "misc", "empty", ".git/objects"} {
+		if _, err := os.Stat(filepath.Join(root, kept)); err != nil {
+			t.Errorf("directory '%s' should have been kept. reason: %s", kept, err)
+		}
+	}
+
+	for _, gone := range want {
+		if _, err := os.Stat(filepath.Join(root, gone)); !os.IsNotExist(err) {
+			t.Errorf("directory '%s' should have been removed", gone)
+		}
+	}
+}
+
+/*
+This test verifies that a dry run reports the directories the moves would empty without
+removing anything.
+*/
+func TestRemoveEmptyDirs_DryRun(t *testing.T) {
+	root := t.TempDir()
+
+	makeTree(t, root, []string{"a/b/IMG_0001.JPG", "a/b/Thumbs.db", "x/y/IMG_0002.JPG"}, []string{"a/c"})
+
+	removed, err := RemoveEmptyDirs(root, rootPaths(root, []string{"a/b/IMG_0001.JPG", "x/y/IMG_0002.JPG"}), DefaultJunkFiles, nil, true)
+	if err != nil {
+		t.Fatalf("RemoveEmptyDirs() failed: %s", err)
+	}
+
+	want := []string{"a/b", "x", "x/y"}
+	if got := relPaths(t, root, removed); !slices.Equal(got, want) {
+		t.Errorf("RemoveEmptyDirs() removed %v, want %v", got, want)
+	}
+
+	for _, file := range []string{"a/b/Thumbs.db", "a/b/IMG_0001.JPG", "x/y/IMG_0002.JPG"} {
+		if _, err := os.Stat(filepath.Join(root, file)); err != nil {
+			t.Errorf("dry run removed files. reason: %s", err)
+		}
+	}
+}
@@ -17,14 +17,24 @@ import (
 */
 
 func Move(source, destination string) error {
+	_, err := MoveHashing(source, destination, nil)
+	return err
+}
+
+/*
+MoveHashing() moves a file like Move(). If it has to be copied across block devices,
+what's read from the source is written to h as well, so the copy can be checked against it
+without reading the source again. copied reports whether it was.
+*/
+func MoveHashing(source, destination string, h io.Writer) (bool, error) {
 	err := os.Rename(source, destination)
 	if err != nil && strings.Contains(err.Error(), "invalid cross-device link") {
-		return moveCrossDevice(source, destination)
+		return true, moveCrossDevice(source, destination, h)
 	}
-	return err
+	return false, err
 }
 
-func moveCrossDevice(source, destination string) error {
+func moveCrossDevice(source, destination string, h io.Writer) error {
 	src, err := os.Open(source)
 	if err != nil {
 		return errors.Wrap(err, "Open(source)")
@@ -34,7 +44,11 @@ func moveCrossDevice(source, destination string) error {
 		src.Close()
 		return errors.Wrap(err, "Create(destination)")
 	}
-	_, err = io.Copy(dst, src)
+	var r io.Reader = src
+	if h != nil {
+		r = io.TeeReader(src, h)
+	}
+	_, err = io.Copy(dst, r)
 	src.Close()
 	dst.Close()
 	if err != nil {
@@ -0,0 +1,39 @@
+package fileops
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+This test verifies that a file copied across block devices is moved intact, and that what
+was copied is hashed along the way
+*/
+func TestMoveCrossDevice(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.jpg")
+	destination := filepath.Join(dir, "destination.jpg")
+
+	if err := os.WriteFile(source, []byte("image"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	h := sha256.New()
+	if err := moveCrossDevice(source, destination, h); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Errorf("source is still there. %v", err)
+	}
+	if contents, err := os.ReadFile(destination); err != nil || string(contents) != "image" {
+		t.Errorf("destination holds %q (%v), want %q", contents, err, "image")
+	}
+
+	want := sha256.Sum256([]byte("image"))
+	if got := h.Sum(nil); string(got) != string(want[:]) {
+		t.Errorf("hash = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+Package filerbridge gives the mediafiler command what it needs from pkg/filer that isn't
+part of that package's public API.
+*/
+package filerbridge
+
+import (
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Config is the configuration a filer.Filer files with. Outside of mediafiler it can only be
+loaded with filer.LoadConfig() and have its settings overridden with Set().
+*/
+type Config struct {
+	cfg *config.Config
+}
+
+/*
+Set overrides a setting from the configuration file, e.g. cfg.Set("dry-run", true).
+*/
+func (c *Config) Set(key string, value interface{}) {
+	c.cfg.Set(key, value)
+}
+
+/*
+Wrap returns a configuration that's already been read and processed, for a filer.Filer to
+use.
+*/
+func Wrap(cfg *config.Config) *Config {
+	return &Config{cfg: cfg}
+}
+
+/*
+Unwrap returns the configuration held by c. Nil if c is.
+*/
+func Unwrap(c *Config) *config.Config {
+	if c == nil {
+		return nil
+	}
+	return c.cfg
+}
+
+/*
+Destination returns the path a file described by exiftool metadata would be filed to below
+destRootDir, ignoring any file already at that path, for 'rules test'. Files that can't be
+filed because of their metadata return a *filer.SkipError. It's set by pkg/filer.
+*/
+var Destination func(cfg *Config, destRootDir string, logger *logrus.Logger, meta gjson.Result) (string, error)
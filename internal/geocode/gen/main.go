@@ -0,0 +1,397 @@
+/*
+gen builds the cities dataset bundled with the geocode package from GeoNames dump files:
+a cities file (e.g. cities1000.txt) and, optionally, admin1CodesASCII.txt for the names
+of regions. Both can be downloaded from https://download.geonames.org/export/dump/
+
+	go run ./internal/geocode/gen -cities cities1000.txt -admin1 admin1CodesASCII.txt \
+		-out internal/geocode/data/cities.tsv.gz
+
+Region names can also be taken from Natural Earth's admin 1 states and provinces GeoJSON
+(ne_10m_admin_1_states_provinces.geojson), from https://www.naturalearthdata.com/. Its
+first level regions record their GeoNames admin1 code. Where Natural Earth has lower level
+areas instead, like France's departments, each records the region it's part of, and a
+region is named after the one that nearly all of its cities fall in, as long as nearly all
+of the cities in that one belong to the region too. Names from admin1CodesASCII.txt win
+when both are given.
+
+	go run ./internal/geocode/gen -cities cities1000.txt -ne-admin1 ne_10m_admin_1_states_provinces.geojson \
+		-out internal/geocode/data/cities.tsv.gz
+*/
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// columns of the GeoNames 'geoname' table used in the cities files
+const (
+	colName        = 1
+	colLatitude    = 4
+	colLongitude   = 5
+	colCountryCode = 8
+	colAdmin1Code  = 10
+	colCount       = 19
+)
+
+/*
+minAreaShare is the share of a region's cities that have to fall in areas of the same
+parent region for it to be named after it, and the share of the cities in those areas that
+have to belong to the region.
+*/
+const minAreaShare = 0.9
+
+func main() {
+	citiesPath := flag.String("cities", "", "GeoNames cities file, e.g. cities1000.txt")
+	admin1Path := flag.String("admin1", "", "GeoNames admin1CodesASCII.txt file (optional)")
+	naturalEarthPath := flag.String("ne-admin1", "", "Natural Earth ne_10m_admin_1_states_provinces.geojson file (optional)")
+	outPath := flag.String("out", "", "where to write the gzipped dataset")
+	flag.Parse()
+
+	if *citiesPath == "" || *outPath == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := generate(*citiesPath, *admin1Path, *naturalEarthPath, *outPath); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func generate(citiesPath string, admin1Path string, naturalEarthPath string, outPath string) error {
+	in, err := os.Open(citiesPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	cities, err := readCities(in)
+	if err != nil {
+		return fmt.Errorf("could not read '%s'. %s", citiesPath, err)
+	}
+
+	regions := make(map[string]string)
+	if naturalEarthPath != "" {
+		ne, err := readNaturalEarth(naturalEarthPath)
+		if err != nil {
+			return fmt.Errorf("could not read '%s'. %s", naturalEarthPath, err)
+		}
+		regions = ne.regionNames(cities)
+	}
+	if admin1Path != "" {
+		admin1, err := readAdmin1(admin1Path)
+		if err != nil {
+			return fmt.Errorf("could not read '%s'. %s", admin1Path, err)
+		}
+		for code, name := range admin1 {
+			regions[code] = name
+		}
+	}
+
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+
+	if err = writeCities(zw, cities, regions); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+/*
+readAdmin1 reads region names keyed on 'country code.admin1 code', e.g. 'US.CA'.
+*/
+func readAdmin1(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	regions := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) >= 2 {
+			regions[cols[0]] = cols[1]
+		}
+	}
+
+	return regions, scanner.Err()
+}
+
+type city struct {
+	name        string
+	lat, lon    float64
+	countryCode string
+	admin1Code  string
+}
+
+func (c city) regionCode() string {
+	return c.countryCode + "." + c.admin1Code
+}
+
+/*
+readCities reads the cities from a GeoNames cities file.
+*/
+func readCities(r io.Reader) ([]city, error) {
+	var cities []city
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) < colCount {
+			return nil, fmt.Errorf("line %d has %d columns, expected %d", line, len(cols), colCount)
+		}
+
+		lat, err := strconv.ParseFloat(cols[colLatitude], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d has a bad latitude. %s", line, err)
+		}
+		lon, err := strconv.ParseFloat(cols[colLongitude], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d has a bad longitude. %s", line, err)
+		}
+
+		cities = append(cities, city{name: cols[colName], lat: lat, lon: lon, countryCode: cols[colCountryCode], admin1Code: cols[colAdmin1Code]})
+	}
+
+	return cities, scanner.Err()
+}
+
+/*
+naturalEarth holds what's used from Natural Earth's admin 1 data: the names of first level
+regions keyed on their GeoNames admin1 code, and lower level areas by country code.
+*/
+type naturalEarth struct {
+	names map[string]string
+	areas map[string][]area
+}
+
+/*
+area is a lower level area, e.g. a department, along with the region it's part of.
+*/
+type area struct {
+	region   string
+	bbox     [4]float64 // min lon, min lat, max lon, max lat
+	polygons [][][][]float64
+}
+
+/*
+readNaturalEarth reads Natural Earth's admin 1 GeoJSON. Lower level areas carry the admin1
+code of a region that may have been redrawn since, so only their parent region's name is
+kept. Codes given to first level regions with different names are left out.
+*/
+func readNaturalEarth(path string) (*naturalEarth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var collection struct {
+		Features []struct {
+			Properties struct {
+				CountryCode string `json:"iso_a2"`
+				Country     string `json:"admin"`
+				Code        string `json:"gn_a1_code"`
+				Level       int    `json:"gn_level"`
+				Name        string `json:"name"`
+				NameEN      string `json:"name_en"`
+				Region      string `json:"region"`
+			} `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}
+	if err := json.NewDecoder(f).Decode(&collection); err != nil {
+		return nil, err
+	}
+
+	ne := &naturalEarth{names: make(map[string]string), areas: make(map[string][]area)}
+	conflicts := make(map[string]bool)
+
+	for _, feature := range collection.Features {
+		props := feature.Properties
+
+		switch props.Level {
+		case 1:
+			countryCode, admin1Code, ok := strings.Cut(props.Code, ".")
+			if !ok || len(countryCode) != 2 || admin1Code == "" {
+				continue
+			}
+
+			// a few regions have the country's name in place of their English one
+			name := props.NameEN
+			if name == "" || name == props.Country {
+				name = props.Name
+			}
+			if existing, ok := ne.names[props.Code]; ok && existing != name {
+				conflicts[props.Code] = true
+			}
+			ne.names[props.Code] = name
+
+		case 2:
+			if props.Region == "" || len(props.CountryCode) != 2 {
+				continue
+			}
+
+			var polygons [][][][]float64
+			switch feature.Geometry.Type {
+			case "Polygon":
+				var polygon [][][]float64
+				if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+					return nil, err
+				}
+				polygons = append(polygons, polygon)
+			case "MultiPolygon":
+				if err := json.Unmarshal(feature.Geometry.Coordinates, &polygons); err != nil {
+					return nil, err
+				}
+			default:
+				continue
+			}
+
+			ne.areas[props.CountryCode] = append(ne.areas[props.CountryCode], newArea(props.Region, polygons))
+		}
+	}
+
+	for code := range conflicts {
+		delete(ne.names, code)
+	}
+	return ne, nil
+}
+
+func newArea(region string, polygons [][][][]float64) area {
+	a := area{region: region, bbox: [4]float64{180, 90, -180, -90}, polygons: polygons}
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for _, p := range ring {
+				a.bbox[0], a.bbox[1] = min(a.bbox[0], p[0]), min(a.bbox[1], p[1])
+				a.bbox[2], a.bbox[3] = max(a.bbox[2], p[0]), max(a.bbox[3], p[1])
+			}
+		}
+	}
+	return a
+}
+
+/*
+contains reports whether a position is inside the area, by the even-odd rule so holes
+are left out.
+*/
+func (a area) contains(lat float64, lon float64) bool {
+	if lon < a.bbox[0] || lat < a.bbox[1] || lon > a.bbox[2] || lat > a.bbox[3] {
+		return false
+	}
+
+	inside := false
+	for _, polygon := range a.polygons {
+		for _, ring := range polygon {
+			for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+				xi, yi, xj, yj := ring[i][0], ring[i][1], ring[j][0], ring[j][1]
+				if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+					inside = !inside
+				}
+			}
+		}
+	}
+	return inside
+}
+
+/*
+regionNames returns region names keyed on 'country code.admin1 code'. Regions Natural Earth
+doesn't name are named after the parent region of the areas their cities fall in, when at
+least minAreaShare of their cities fall in that parent region's areas and at least
+minAreaShare of the cities in those areas belong to them.
+*/
+func (ne *naturalEarth) regionNames(cities []city) map[string]string {
+	regions := make(map[string]string)
+	for code, name := range ne.names {
+		regions[code] = name
+	}
+
+	// how many of each region's cities fall in each parent region's areas, and the reverse
+	byRegion := make(map[string]map[string]int)
+	byParent := make(map[string]map[string]int)
+	for _, c := range cities {
+		if c.admin1Code == "" || len(ne.areas[c.countryCode]) == 0 {
+			continue
+		}
+
+		parent := ""
+		for _, a := range ne.areas[c.countryCode] {
+			if a.contains(c.lat, c.lon) {
+				parent = a.region
+				break
+			}
+		}
+
+		code, parentKey := c.regionCode(), c.countryCode+"."+parent
+		if byRegion[code] == nil {
+			byRegion[code] = make(map[string]int)
+		}
+		if byParent[parentKey] == nil {
+			byParent[parentKey] = make(map[string]int)
+		}
+		byRegion[code][parent]++
+		byParent[parentKey][code]++
+	}
+
+	share := func(counts map[string]int, key string) float64 {
+		total := 0
+		for _, count := range counts {
+			total += count
+		}
+		return float64(counts[key]) / float64(total)
+	}
+
+	for code, parents := range byRegion {
+		if regions[code] != "" {
+			continue
+		}
+
+		countryCode, _, _ := strings.Cut(code, ".")
+		for parent := range parents {
+			if parent != "" && share(parents, parent) >= minAreaShare && share(byParent[countryCode+"."+parent], code) >= minAreaShare {
+				regions[code] = parent
+				break
+			}
+		}
+	}
+
+	return regions
+}
+
+/*
+writeCities writes one line per city: name, latitude, longitude, country code, admin1
+code and region name, separated by tabs.
+*/
+func writeCities(w io.Writer, cities []city, regions map[string]string) error {
+	bw := bufio.NewWriter(w)
+
+	for _, c := range cities {
+		fmt.Fprintf(bw, "%s\t%.4f\t%.4f\t%s\t%s\t%s\n", c.name, c.lat, c.lon, c.countryCode, c.admin1Code, regions[c.regionCode()])
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,213 @@
+package geocode
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+//go:embed data/cities.tsv.gz
+var citiesData []byte
+
+/*
+DefaultMaxDistance is how far, in kilometres, a position can be from the nearest city
+for that city to be used.
+*/
+const DefaultMaxDistance float64 = 100
+
+const earthRadius float64 = 6371 // km
+
+/*
+Place is where a position is, as the nearest city in the dataset.
+*/
+type Place struct {
+	City        string
+	Region      string
+	Country     string
+	CountryCode string
+}
+
+type city struct {
+	name        string
+	countryCode string
+	region      string
+}
+
+/*
+Geocoder finds the place nearest to a position, without needing a network connection.
+A Geocoder is safe for concurrent use.
+*/
+type Geocoder struct {
+	MaxDistance float64
+	cities      []city
+	tree        *kdTree
+}
+
+var (
+	defaultGeocoder *Geocoder
+	defaultErr      error
+	defaultOnce     sync.Once
+)
+
+/*
+Default() returns a Geocoder using the bundled GeoNames cities dataset. The dataset is
+only read the first time it's needed.
+*/
+func Default() (*Geocoder, error) {
+	defaultOnce.Do(func() {
+		var zr *gzip.Reader
+		if zr, defaultErr = gzip.NewReader(bytes.NewReader(citiesData)); defaultErr != nil {
+			return
+		}
+		defaultGeocoder, defaultErr = Load(zr)
+	})
+
+	return defaultGeocoder, defaultErr
+}
+
+/*
+Load() builds a Geocoder from a dataset in the format written by internal/geocode/gen:
+one city per line, with its name, latitude, longitude, country code, admin1 code and
+region name separated by tabs.
+*/
+func Load(r io.Reader) (*Geocoder, error) {
+	g := &Geocoder{MaxDistance: DefaultMaxDistance}
+	var points []point
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) != 6 {
+			return nil, fmt.Errorf("line %d of the cities dataset has %d columns, expected 6", line, len(cols))
+		}
+
+		lat, err := strconv.ParseFloat(cols[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of the cities dataset has a bad latitude. %s", line, err)
+		}
+		lon, err := strconv.ParseFloat(cols[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d of the cities dataset has a bad longitude. %s", line, err)
+		}
+
+		// without region names, the admin1 code is the best there is
+		region := cols[5]
+		if region == "" {
+			region = cols[4]
+		}
+
+		g.cities = append(g.cities, city{name: cols[0], countryCode: cols[3], region: region})
+		points = append(points, toPoint(lat, lon))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("the cities dataset is empty")
+	}
+
+	g.tree = newKDTree(points)
+	return g, nil
+}
+
+/*
+Lookup() returns the place nearest to a latitude and longitude in decimal degrees. ok
+is false if the position isn't valid, or there's no city within MaxDistance of it.
+*/
+func (g *Geocoder) Lookup(lat float64, lon float64) (Place, bool) {
+	if math.IsNaN(lat) || math.IsNaN(lon) || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return Place{}, false
+	}
+
+	idx, distSq := g.tree.nearest(toPoint(lat, lon))
+	if g.MaxDistance > 0 && surfaceDistance(distSq) > g.MaxDistance {
+		return Place{}, false
+	}
+
+	c := g.cities[idx]
+	return Place{City: c.name, Region: c.region, Country: countryName(c.countryCode), CountryCode: c.countryCode}, true
+}
+
+/*
+Distance() returns the distance in kilometres between two positions along the Earth's surface.
+*/
+func Distance(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	return surfaceDistance(toPoint(lat1, lon1).distSq(toPoint(lat2, lon2)))
+}
+
+/*
+surfaceDistance converts the squared straight-line distance between two points on the unit
+sphere, as the tree measures it, to kilometres along the Earth's surface.
+*/
+func surfaceDistance(distSq float64) float64 {
+	return 2 * math.Asin(math.Min(1, math.Sqrt(distSq)/2)) * earthRadius
+}
+
+/*
+countryName returns the English name of a country from its ISO 3166 code, or the code
+itself if it isn't known.
+*/
+func countryName(code string) string {
+	region, err := language.ParseRegion(code)
+	if err != nil {
+		return code
+	}
+
+	if name := display.English.Regions().Name(region); name != "" {
+		return name
+	}
+	return code
+}
+
+var coordinatePattern = regexp.MustCompile(`^([+-]?\d+(?:\.\d+)?)(?:\s*deg\s*(\d+(?:\.\d+)?)'(?:\s*(\d+(?:\.\d+)?)")?)?\s*([NSEW])?$`)
+
+/*
+ParseCoordinate() parses a latitude or longitude as exiftool writes it, e.g. '-33.8568',
+'33.8568 S' or '33 deg 51\' 24.48" S', into signed decimal degrees. ref is the matching
+GPSLatitudeRef or GPSLongitudeRef value, used when the coordinate itself has no sign or
+direction, and can be empty.
+*/
+func ParseCoordinate(value string, ref string) (float64, error) {
+	match := coordinatePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return 0, fmt.Errorf("'%s' is not a coordinate", value)
+	}
+
+	degrees, _ := strconv.ParseFloat(match[1], 64)
+	negative := strings.HasPrefix(match[1], "-")
+	degrees = math.Abs(degrees)
+
+	if match[2] != "" {
+		minutes, _ := strconv.ParseFloat(match[2], 64)
+		degrees += minutes / 60
+	}
+	if match[3] != "" {
+		seconds, _ := strconv.ParseFloat(match[3], 64)
+		degrees += seconds / 3600
+	}
+
+	direction := match[4]
+	if direction == "" && !negative && !strings.HasPrefix(match[1], "+") {
+		direction = strings.ToUpper(strings.TrimSpace(ref))
+	}
+	if direction == "S" || direction == "W" || strings.HasPrefix(direction, "SOUTH") || strings.HasPrefix(direction, "WEST") {
+		negative = true
+	}
+
+	if negative {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
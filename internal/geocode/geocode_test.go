@@ -0,0 +1,145 @@
+package geocode
+
+import (
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+/*
+This test verifies that the k-d tree finds the same nearest point as checking every point
+*/
+func Test_kdTree_nearest(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomPoint := func() point {
+		return toPoint(rng.Float64()*180-90, rng.Float64()*360-180)
+	}
+
+	points := make([]point, 2000)
+	for idx := range points {
+		points[idx] = randomPoint()
+	}
+	tree := newKDTree(points)
+
+	for probe := 0; probe < 500; probe++ {
+		target := randomPoint()
+
+		want, wantDist := -1, math.Inf(1)
+		for idx, p := range points {
+			if d := p.distSq(target); d < wantDist {
+				want, wantDist = idx, d
+			}
+		}
+
+		if got, gotDist := tree.nearest(target); got != want && gotDist != wantDist {
+			t.Fatalf("nearest(%v) = %d (%f), want %d (%f)", target, got, gotDist, want, wantDist)
+		}
+	}
+}
+
+/*
+This test verifies that positions are matched to the nearest city in the bundled dataset and
+its named region, including either side of the antimeridian, that regions without a name fall
+back to their code, and that positions far from any city aren't matched
+*/
+func TestGeocoder_Lookup(t *testing.T) {
+	g, err := Default()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		want     Place
+		wantOK   bool
+	}{
+		{"Paris", 48.8566, 2.3522, Place{City: "Paris", Region: "Île-de-France", Country: "France", CountryCode: "FR"}, true},
+		{"San Francisco", 37.7793, -122.4193, Place{City: "San Francisco", Region: "California", Country: "United States", CountryCode: "US"}, true},
+		{"Sydney", -33.8679, 151.2073, Place{City: "Sydney", Region: "New South Wales", Country: "Australia", CountryCode: "AU"}, true},
+		{"Suva", -18.1416, 178.4419, Place{City: "Suva", Region: "Central", Country: "Fiji", CountryCode: "FJ"}, true},
+		{"London", 51.5074, -0.1278, Place{City: "London", Region: "ENG", Country: "United Kingdom", CountryCode: "GB"}, true},
+		{"middle of the Pacific", 0, -140, Place{}, false},
+		{"not a position", 91, 0, Place{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := g.Lookup(tt.lat, tt.lon)
+			if ok != tt.wantOK || got != tt.want {
+				t.Errorf("Lookup(%f, %f) = %+v, %v, want %+v, %v", tt.lat, tt.lon, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+/*
+This test verifies distances between positions, including across the antimeridian
+*/
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lat1, lon1, lat2, lon2 float64
+		want                   float64
+	}{
+		{"same place", 48.8566, 2.3522, 48.8566, 2.3522, 0},
+		{"Paris to London", 48.8566, 2.3522, 51.5074, -0.1278, 343.5},
+		{"across the antimeridian", 0, 179.5, 0, -179.5, 111.2},
+		{"pole to pole", 90, 0, -90, 0, 20015.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.lat1, tt.lon1, tt.lat2, tt.lon2); math.Abs(got-tt.want) > 0.5 {
+				t.Errorf("Distance() = %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that datasets with the wrong number of columns are rejected
+*/
+func TestLoad_Invalid(t *testing.T) {
+	for _, data := range []string{"", "Paris\t48.8566\t2.3522\tFR\n", "Paris\tnorth\t2.3522\tFR\t11\t\n"} {
+		if _, err := Load(strings.NewReader(data)); err == nil {
+			t.Errorf("Load(%q) succeeded when it should have failed", data)
+		}
+	}
+}
+
+/*
+This test verifies that coordinates are parsed from the formats exiftool writes them in
+*/
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		value   string
+		ref     string
+		want    float64
+		wantErr bool
+	}{
+		{"+37.779300", "", 37.7793, false},
+		{"-122.419300", "West", -122.4193, false},
+		{"37.7793", "North", 37.7793, false},
+		{"122.4193", "West", -122.4193, false},
+		{"33.8679 S", "", -33.8679, false},
+		{`37 deg 46' 45.48" N`, "", 37.7793, false},
+		{`122 deg 25' 9.48" W`, "", -122.4193, false},
+		{`33 deg 52' 4.44"`, "S", -33.8679, false},
+		{"redacted", "North", 0, true},
+		{"", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseCoordinate(tt.value, tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCoordinate() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if math.Abs(got-tt.want) > 1e-6 {
+				t.Errorf("ParseCoordinate(%q, %q) = %f, want %f", tt.value, tt.ref, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package geocode
+
+import (
+	"math"
+	"sort"
+)
+
+/*
+point is a position on the unit sphere. Cities are stored this way so that the
+straight-line distance between two points grows with the distance along the Earth's
+surface, with no special cases at the poles or the antimeridian.
+*/
+type point [3]float64
+
+func toPoint(lat float64, lon float64) point {
+	latRad, lonRad := lat*math.Pi/180, lon*math.Pi/180
+	return point{
+		math.Cos(latRad) * math.Cos(lonRad),
+		math.Cos(latRad) * math.Sin(lonRad),
+		math.Sin(latRad),
+	}
+}
+
+func (p point) distSq(other point) float64 {
+	var sum float64
+	for axis := range p {
+		d := p[axis] - other[axis]
+		sum += d * d
+	}
+	return sum
+}
+
+/*
+kdTree finds the nearest of a set of points. The tree is stored in the order of its
+points: the median of each range is the node splitting it, on the axis for its depth.
+*/
+type kdTree struct {
+	points []point
+	ids    []int
+}
+
+/*
+newKDTree builds a tree over points. ids holds the index in points each tree node
+came from.
+*/
+func newKDTree(points []point) *kdTree {
+	t := &kdTree{points: make([]point, len(points)), ids: make([]int, len(points))}
+	for idx := range points {
+		t.ids[idx] = idx
+	}
+
+	t.build(t.ids, points, 0)
+
+	for idx, id := range t.ids {
+		t.points[idx] = points[id]
+	}
+	return t
+}
+
+func (t *kdTree) build(ids []int, points []point, depth int) {
+	if len(ids) <= 1 {
+		return
+	}
+
+	axis := depth % 3
+	sort.Slice(ids, func(a, b int) bool { return points[ids[a]][axis] < points[ids[b]][axis] })
+
+	mid := len(ids) / 2
+	t.build(ids[:mid], points, depth+1)
+	t.build(ids[mid+1:], points, depth+1)
+}
+
+/*
+nearest returns the index of the point closest to target, and the squared distance
+to it. The tree must not be empty.
+*/
+func (t *kdTree) nearest(target point) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+	t.search(0, len(t.points), 0, target, &best, &bestDist)
+	return t.ids[best], bestDist
+}
+
+func (t *kdTree) search(lo int, hi int, depth int, target point, best *int, bestDist *float64) {
+	if lo >= hi {
+		return
+	}
+
+	mid := lo + (hi-lo)/2
+	if d := t.points[mid].distSq(target); d < *bestDist {
+		*best, *bestDist = mid, d
+	}
+
+	axis := depth % 3
+	diff := target[axis] - t.points[mid][axis]
+
+	// search the side the target is on first, and the other side only if it could be closer
+	nearLo, nearHi, farLo, farHi := lo, mid, mid+1, hi
+	if diff > 0 {
+		nearLo, nearHi, farLo, farHi = mid+1, hi, lo, mid
+	}
+
+	t.search(nearLo, nearHi, depth+1, target, best, bestDist)
+	if diff*diff < *bestDist {
+		t.search(farLo, farHi, depth+1, target, best, bestDist)
+	}
+}
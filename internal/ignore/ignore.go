@@ -0,0 +1,170 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+/*
+FileName is the name of the per-directory files holding ignore rules. Rules in
+these files use gitignore syntax and apply to the directory they're found in and
+everything below it.
+*/
+const FileName = ".mediafilerignore"
+
+/*
+Rule is a single gitignore-style pattern. Patterns without a slash (other than a
+trailing one) match at any depth below the directory the rule belongs to. Other
+patterns are anchored to that directory. A trailing slash only matches directories,
+and a leading '!' re-includes paths excluded by an earlier rule.
+*/
+type Rule struct {
+	Text    string
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+/*
+ParseRule parses a single line of a gitignore-style file. ok is false for blank
+lines and comments.
+*/
+func ParseRule(line string) (Rule, bool, error) {
+	rule := Rule{Text: line}
+
+	line = strings.TrimRight(line, "\r")
+	if !strings.HasSuffix(line, `\ `) {
+		line = strings.TrimRight(line, " ")
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false, nil
+	}
+
+	switch {
+	case strings.HasPrefix(line, "!"):
+		rule.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return rule, false, fmt.Errorf("pattern '%s' doesn't match anything", rule.Text)
+	}
+
+	if strings.Contains(line, "/") {
+		rule.glob = strings.TrimPrefix(line, "/")
+	} else {
+		rule.glob = "**/" + line
+	}
+
+	if !doublestar.ValidatePattern(rule.glob) {
+		return rule, false, fmt.Errorf("pattern '%s' is not a valid pattern", rule.Text)
+	}
+
+	return rule, true, nil
+}
+
+/*
+Match reports whether the rule matches relPath, a slash-separated path relative to
+the directory the rule belongs to.
+*/
+func (r Rule) Match(relPath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	match, _ := doublestar.Match(r.glob, relPath)
+	return match
+}
+
+/*
+Rules is an ordered list of rules. When more than one rule matches a path, the last
+one wins.
+*/
+type Rules []Rule
+
+/*
+ParseRules reads gitignore-style rules, one per line.
+*/
+func ParseRules(r io.Reader) (Rules, error) {
+	var rules Rules
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		rule, ok, err := ParseRule(scanner.Text())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %s", lineNum, err))
+		} else if ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return rules, errors.Join(errs...)
+}
+
+/*
+ReadFile reads the rules in a gitignore-style file.
+*/
+func ReadFile(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules, err := ParseRules(f)
+	if err != nil {
+		return rules, fmt.Errorf("%s: %w", file, err)
+	}
+	return rules, nil
+}
+
+/*
+Match reports whether any of the rules match relPath, and if so, whether the last
+one to match excludes it (true) or re-includes it (false).
+*/
+func (rs Rules) Match(relPath string, isDir bool) (matched bool, excluded bool) {
+	for _, rule := range rs {
+		if rule.Match(relPath, isDir) {
+			matched = true
+			excluded = !rule.negate
+		}
+	}
+	return matched, excluded
+}
+
+/*
+Ignored reports whether relPath is ignored by the rules, either directly or because
+one of the directories above it is. Like git, a path can't be re-included if one of
+the directories above it is ignored.
+*/
+func (rs Rules) Ignored(relPath string, isDir bool) bool {
+	parts := strings.Split(path.Clean(relPath), "/")
+
+	for idx := range parts {
+		partIsDir := isDir || idx < len(parts)-1
+		if _, excluded := rs.Match(strings.Join(parts[:idx+1], "/"), partIsDir); excluded {
+			return true
+		}
+	}
+	return false
+}
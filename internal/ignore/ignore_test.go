@@ -0,0 +1,87 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		line    string
+		wantOk  bool
+		wantErr bool
+	}{
+		{line: "", wantOk: false},
+		{line: "   ", wantOk: false},
+		{line: "# comment", wantOk: false},
+		{line: `\#notacomment`, wantOk: true},
+		{line: "*.tmp", wantOk: true},
+		{line: "!keep.tmp", wantOk: true},
+		{line: "cache/", wantOk: true},
+		{line: "/", wantErr: true},
+		{line: "[abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			_, ok, err := ParseRule(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("ParseRule() ok = %v, want %v", ok, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestParseRules_Errors(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader("*.tmp\n[abc\n\n# comment\nraw/\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("ParseRules() error = %v, want an error for line 2", err)
+	}
+	if len(rules) != 2 {
+		t.Errorf("ParseRules() returned %d rules, want 2", len(rules))
+	}
+}
+
+func TestRules_Ignored(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(strings.Join([]string{
+		"*.tmp",
+		"!keep.tmp",
+		"cache/",
+		"/exports",
+		"raw/**/*.dng",
+		"private/",
+		"!private/share.jpg",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{path: "upload.tmp", want: true},
+		{path: "2024/06/upload.tmp", want: true},
+		{path: "2024/06/keep.tmp", want: false},
+		{path: "cache", isDir: true, want: true},
+		{path: "cache", isDir: false, want: false},
+		{path: "2024/cache/IMG_0001.JPG", want: true},
+		{path: "exports", isDir: true, want: true},
+		{path: "exports/IMG_0001.JPG", want: true},
+		{path: "2024/exports/IMG_0001.JPG", want: false},
+		{path: "raw/2024/06/IMG_0001.dng", want: true},
+		{path: "raw/2024/06/IMG_0001.JPG", want: false},
+		{path: "private/share.jpg", want: true},
+		{path: "IMG_0001.JPG", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := rules.Ignored(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("Rules.Ignored(%q, %v) = %v, want %v", tt.path, tt.isDir, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package ignore
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+/*
+Tree holds the ignore rules found in FileName files below a root directory, along
+with the directories that were pruned while walking it.
+*/
+type Tree struct {
+	Root   string
+	Pruned []string
+	rules  map[string]Rules // keyed by directory, relative to Root ("." for Root)
+}
+
+/*
+Walk walks the directory tree below root, reading the FileName file in each
+directory. Directories that are ignored, either by those files or because skipDir
+returns true for them, aren't walked and are listed in Pruned. Hidden directories
+below root are skipped as well, since exiftool doesn't look in them.
+
+Problems reading individual ignore files or directories don't stop the walk. They're
+returned together once the walk is done.
+*/
+func Walk(root string, skipDir func(path string) bool) (*Tree, error) {
+	tree := &Tree{Root: filepath.Clean(root), rules: make(map[string]Rules)}
+	var errs []error
+
+	err := filepath.WalkDir(tree.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			if d != nil && d.IsDir() && path != tree.Root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != tree.Root {
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+
+			if (skipDir != nil && skipDir(path)) || tree.Ignored(path, true) {
+				tree.Pruned = append(tree.Pruned, path)
+				return filepath.SkipDir
+			}
+		}
+
+		rules, err := ReadFile(filepath.Join(path, FileName))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+		if len(rules) > 0 {
+			rel, _ := filepath.Rel(tree.Root, path)
+			tree.rules[filepath.ToSlash(rel)] = rules
+		}
+
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return tree, errors.Join(errs...)
+}
+
+/*
+Contains reports whether path is inside the tree.
+*/
+func (t *Tree) Contains(path string) bool {
+	rel, err := filepath.Rel(t.Root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+/*
+Ignored reports whether path is ignored by the rules in the tree's ignore files.
+Rules in deeper directories take precedence over rules in the directories above
+them, and a path is ignored if any of the directories above it are.
+*/
+func (t *Tree) Ignored(path string, isDir bool) bool {
+	if len(t.rules) == 0 || !t.Contains(path) {
+		return false
+	}
+
+	rel, _ := filepath.Rel(t.Root, path)
+	if rel == "." {
+		return false
+	}
+
+	dirs := t.ruleDirs()
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+
+	for idx := range parts {
+		sub := strings.Join(parts[:idx+1], "/")
+		subIsDir := isDir || idx < len(parts)-1
+		excluded := false
+
+		for _, dir := range dirs {
+			subRel, ok := relativeTo(dir, sub)
+			if !ok {
+				continue
+			}
+
+			if matched, ex := t.rules[dir].Match(subRel, subIsDir); matched {
+				excluded = ex
+			}
+		}
+
+		if excluded {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ruleDirs returns the directories holding rules, shallowest first.
+*/
+func (t *Tree) ruleDirs() []string {
+	dirs := make([]string, 0, len(t.rules))
+	for dir := range t.rules {
+		dirs = append(dirs, dir)
+	}
+
+	depth := func(dir string) int {
+		if dir == "." {
+			return 0
+		}
+		return strings.Count(dir, "/") + 1
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		return depth(dirs[i]) < depth(dirs[j])
+	})
+	return dirs
+}
+
+/*
+relativeTo returns sub relative to dir, if dir is above it. Both are slash-separated
+and relative to the tree root.
+*/
+func relativeTo(dir string, sub string) (string, bool) {
+	if dir == "." {
+		return sub, true
+	}
+
+	if rel, ok := strings.CutPrefix(sub, dir+"/"); ok {
+		return rel, true
+	}
+	return "", false
+}
@@ -0,0 +1,99 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, contents := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWalk(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		FileName:                        "*.tmp\ncache/\n",
+		"2024/IMG_0001.JPG":             "",
+		"2024/upload.tmp":               "",
+		"2024/cache/thumb.jpg":          "",
+		"2024/" + FileName:              "!keep.tmp\nscans/\n",
+		"2024/keep.tmp":                 "",
+		"2024/scans/page1.png":          "",
+		"2023/scans/page1.png":          "",
+		"skipped/IMG_0002.JPG":          "",
+		".hidden/" + FileName:           "*\n",
+		".hidden/IMG_0003.JPG":          "",
+		"2024/nested/deeper/IMG.JPG":    "",
+		"2024/nested/deeper/upload.tmp": "",
+	})
+
+	tree, err := Walk(root, func(path string) bool {
+		return filepath.Base(path) == "skipped"
+	})
+	if err != nil {
+		t.Fatalf("Walk() failed: %s", err)
+	}
+
+	wantPruned := []string{
+		filepath.Join(root, "2024", "cache"),
+		filepath.Join(root, "2024", "scans"),
+		filepath.Join(root, "skipped"),
+	}
+	if !reflect.DeepEqual(tree.Pruned, wantPruned) {
+		t.Errorf("Walk() pruned %v, want %v", tree.Pruned, wantPruned)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "2024/IMG_0001.JPG", want: false},
+		{path: "2024/upload.tmp", want: true},
+		{path: "2024/keep.tmp", want: false},
+		{path: "2024/cache/thumb.jpg", want: true},
+		{path: "2024/scans/page1.png", want: true},
+		{path: "2023/scans/page1.png", want: false},
+		{path: "2024/nested/deeper/IMG.JPG", want: false},
+		{path: "2024/nested/deeper/upload.tmp", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path := filepath.Join(root, filepath.FromSlash(tt.path))
+			if got := tree.Ignored(path, false); got != tt.want {
+				t.Errorf("Tree.Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if tree.Ignored(filepath.Join(filepath.Dir(root), "upload.tmp"), false) {
+		t.Errorf("Tree.Ignored() matched a path outside the tree")
+	}
+}
+
+func TestWalk_BadIgnoreFile(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		FileName:       "[abc\n*.tmp\n",
+		"upload.tmp":   "",
+		"IMG_0001.JPG": "",
+	})
+
+	tree, err := Walk(root, nil)
+	if err == nil {
+		t.Errorf("Walk() succeeded with an invalid ignore file")
+	}
+	if !tree.Ignored(filepath.Join(root, "upload.tmp"), false) {
+		t.Errorf("Walk() dropped the valid rules of an ignore file with errors")
+	}
+}
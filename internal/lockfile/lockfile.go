@@ -0,0 +1,203 @@
+package lockfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FileName string = ".mediafiler.lock"
+)
+
+/*
+Holder describes the process that wrote a lock file. The same information is
+written to the lock file itself so that other processes (or humans) can tell
+who is holding it.
+*/
+type Holder struct {
+	PID      int
+	Hostname string
+	Started  time.Time
+}
+
+func (h Holder) String() string {
+	return fmt.Sprintf("pid %d on host '%s' (started %s)", h.PID, h.Hostname, h.Started.Format(time.RFC3339))
+}
+
+/*
+HeldError is returned by Acquire when the lock is held by another process and
+we weren't asked to wait for it.
+*/
+type HeldError struct {
+	Path   string
+	Holder Holder
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("lock file '%s' is held by another process", e.Path)
+	}
+
+	msg := fmt.Sprintf("lock file '%s' is held by %s", e.Path, e.Holder)
+
+	if !e.Holder.isRunning() {
+		msg += ". that process no longer appears to be running, so the lock may be held by one of its" +
+			" children or by a stale network filesystem lock"
+	}
+
+	return msg
+}
+
+/*
+Lock represents a held lock on a directory. Stale is populated if a lock file
+left behind by a previous process (that didn't release it cleanly) was found
+and taken over.
+*/
+type Lock struct {
+	Path  string
+	Stale *Holder
+	file  *os.File
+}
+
+/*
+Acquire takes an exclusive lock on the lock file in dir, creating it if needed.
+
+If wait is false and the lock is held by another process, a *HeldError is returned.
+If wait is true, Acquire blocks until the lock is released.
+*/
+func Acquire(dir string, wait bool) (*Lock, error) {
+	path := filepath.Join(dir, FileName)
+
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open lock file '%s'. reason: %s", path, err)
+		}
+
+		held, err := lockFile(f, wait)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		if held {
+			holder, _ := readHolder(f)
+			f.Close()
+			return nil, &HeldError{Path: path, Holder: holder}
+		}
+
+		// the previous holder removes the lock file when releasing it, so the file we
+		// opened may no longer be the one at the path. if that's the case, start over.
+		if !isCurrentFile(f, path) {
+			f.Close()
+			continue
+		}
+
+		lock := &Lock{Path: path, file: f}
+
+		// a clean release removes the file, so any content we find was left behind by a
+		// process that didn't get the chance to clean up after itself.
+		if stale, err := readHolder(f); err == nil {
+			lock.Stale = &stale
+		}
+
+		if err = writeHolder(f); err != nil {
+			lock.Release()
+			return nil, fmt.Errorf("could not write to lock file '%s'. reason: %s", path, err)
+		}
+
+		return lock, nil
+	}
+}
+
+/*
+Release removes the lock file and releases the lock. The file is removed while the
+lock is still held so that a waiting process can't lock a file that is about to vanish.
+*/
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+
+	err := os.Remove(l.Path)
+	unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+
+	return err
+}
+
+func isCurrentFile(f *os.File, path string) bool {
+	openInfo, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	pathInfo, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(openInfo, pathInfo)
+}
+
+func writeHolder(f *os.File) error {
+	hostname, _ := os.Hostname()
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("pid: %d\nhostname: %s\nstarted: %s\n", os.Getpid(), hostname, time.Now().Format(time.RFC3339))
+	if _, err := f.WriteAt([]byte(content), 0); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
+
+func readHolder(f *os.File) (Holder, error) {
+	var holder Holder
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return holder, err
+	}
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.TrimSpace(key) {
+		case "pid":
+			holder.PID, _ = strconv.Atoi(value)
+			found = true
+		case "hostname":
+			holder.Hostname = value
+			found = true
+		case "started":
+			holder.Started, _ = time.Parse(time.RFC3339, value)
+			found = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return holder, err
+	}
+
+	if !found {
+		return holder, fmt.Errorf("lock file '%s' does not contain holder information", f.Name())
+	}
+
+	return holder, nil
+}
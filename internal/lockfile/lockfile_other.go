@@ -0,0 +1,21 @@
+//go:build !unix
+
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+func lockFile(f *os.File, wait bool) (bool, error) {
+	return false, fmt.Errorf("could not lock '%s': %w", f.Name(), errors.ErrUnsupported)
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
+
+func (h Holder) isRunning() bool {
+	return true
+}
@@ -0,0 +1,115 @@
+//go:build unix
+
+package lockfile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/*
+This test verifies that a lock can't be taken twice and that it can be taken again once released.
+*/
+func TestAcquire_Exclusive(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := Acquire(dir, false)
+	if err != nil {
+		t.Fatalf("Acquire() failed: %s", err)
+	}
+
+	if lock.Stale != nil {
+		t.Errorf("Acquire() reported a stale lock in an empty directory: %v", lock.Stale)
+	}
+
+	_, err = Acquire(dir, false)
+	var heldErr *HeldError
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("second Acquire() err = %v, wanted a *HeldError", err)
+	}
+
+	if heldErr.Holder.PID != os.Getpid() {
+		t.Errorf("HeldError holder pid = %d, wanted %d", heldErr.Holder.PID, os.Getpid())
+	}
+
+	if err = lock.Release(); err != nil {
+		t.Errorf("Release() failed: %s", err)
+	}
+
+	if _, err = os.Stat(filepath.Join(dir, FileName)); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after Release(). err: %v", err)
+	}
+
+	lock, err = Acquire(dir, false)
+	if err != nil {
+		t.Fatalf("Acquire() after Release() failed: %s", err)
+	}
+	lock.Release()
+}
+
+/*
+This test verifies that a waiting Acquire returns once the lock is released.
+*/
+func TestAcquire_Wait(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := Acquire(dir, false)
+	if err != nil {
+		t.Fatalf("Acquire() failed: %s", err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		waited, err := Acquire(dir, true)
+		if err == nil {
+			waited.Release()
+		}
+		acquired <- err
+	}()
+
+	select {
+	case err = <-acquired:
+		t.Fatalf("waiting Acquire() returned while the lock was held. err: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lock.Release()
+
+	select {
+	case err = <-acquired:
+		if err != nil {
+			t.Errorf("waiting Acquire() failed: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting Acquire() did not return after the lock was released")
+	}
+}
+
+/*
+This test verifies that a lock file left behind by a process that didn't release it is reported as stale.
+*/
+func TestAcquire_Stale(t *testing.T) {
+	dir := t.TempDir()
+
+	content := "pid: 4242\nhostname: somewhere-else\nstarted: 2024-06-15T10:11:12Z\n"
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatalf("could not write stale lock file: %s", err)
+	}
+
+	lock, err := Acquire(dir, false)
+	if err != nil {
+		t.Fatalf("Acquire() failed: %s", err)
+	}
+	defer lock.Release()
+
+	if lock.Stale == nil {
+		t.Fatal("Acquire() did not report the stale lock")
+	}
+
+	if lock.Stale.PID != 4242 || lock.Stale.Hostname != "somewhere-else" {
+		t.Errorf("stale holder = %v, wanted pid 4242 on host 'somewhere-else'", lock.Stale)
+	}
+}
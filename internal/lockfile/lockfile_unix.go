@@ -0,0 +1,52 @@
+//go:build unix
+
+package lockfile
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+/*
+lockFile takes an exclusive flock on f. held is true if the lock is owned by
+someone else and wait is false.
+*/
+func lockFile(f *os.File, wait bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		switch {
+		case err == nil:
+			return false, nil
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return true, nil
+		default:
+			return false, &os.PathError{Op: "flock", Path: f.Name(), Err: err}
+		}
+	}
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+/*
+isRunning reports whether the holder process still exists. Processes on other hosts
+can't be checked, so they are assumed to be running.
+*/
+func (h Holder) isRunning() bool {
+	hostname, err := os.Hostname()
+	if err != nil || hostname != h.Hostname {
+		return true
+	}
+
+	err = syscall.Kill(h.PID, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,161 @@
+/*
+Package metadata reads what mediafiler needs to know about a file from the metadata
+exiftool reports for it.
+*/
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/tidwall/gjson"
+)
+
+/*
+ErrNoMIMEType is returned by SplitMIMEType for files without a MIME type. Other errors it
+returns are for MIME types that can't be split.
+*/
+var ErrNoMIMEType = errors.New("MIME type for this file was not found")
+
+/*
+SplitMIMEType pulls the MIME type and subtype out of a file's metadata.
+*/
+func SplitMIMEType(meta gjson.Result) (string, string, error) {
+	if !meta.Get("MIMEType").Exists() {
+		return "", "", ErrNoMIMEType
+	}
+
+	mimeType, mimeSubType, ok := strings.Cut(meta.Get("MIMEType").String(), "/")
+	if !ok {
+		return "", "", fmt.Errorf("MIMEType string '%s' could not be cut", meta.Get("MIMEType").String())
+	}
+
+	if mimeType == "" || mimeSubType == "" {
+		return "", "", fmt.Errorf("MIME Type ('%s') or Subtype ('%s') cannot be empty", mimeType, mimeSubType)
+	}
+
+	return mimeType, mimeSubType, nil
+}
+
+/*
+Tags looks up tags in the exiftool metadata for a file, so model replace rules can match
+on tags other than the model.
+*/
+func Tags(meta gjson.Result) strmanip.TagLookup {
+	return func(tag string) (string, bool) {
+		value := meta.Get(gjson.Escape(tag))
+		return value.String(), value.Exists()
+	}
+}
+
+/*
+timestampTags are the tags a file's timestamp is taken from, in order of preference.
+*/
+var timestampTags = []string{
+	"SubSecDateTimeOriginal",
+	"DateTimeOriginal",
+	"CreateDate",
+	"ModifyDate",  // damnit, DROID3!
+	"GPSDateTime", // damnit, Nexus6!
+}
+
+/*
+exiftoolDateLayouts are the layouts of dates in exiftool output that wasn't produced with
+mediafiler's date format, e.g. when saved by hand for 'rules test'.
+*/
+var exiftoolDateLayouts = []string{
+	"2006:01:02 15:04:05.999999999Z07:00",
+	"2006:01:02 15:04:05.999999999",
+}
+
+/*
+Timestamp returns the time a file was created, along with the tag it was taken from: the
+first of the timestamp tags the file has. exiftool is run with a date format that gives
+these tags in milliseconds since the epoch, but dates in exiftool's default format are
+understood as well. parsed is false if the tag couldn't be parsed, like the
+'0000:00:00 00:00:00' written by cameras whose clock was never set, and the time is zero
+then. The tag is empty if the file has none of the timestamp tags.
+*/
+func Timestamp(meta gjson.Result) (t time.Time, tag string, parsed bool) {
+	for _, tag := range timestampTags {
+		value := meta.Get(tag)
+		if !value.Exists() {
+			continue
+		}
+
+		if timeInput, err := strconv.ParseInt(value.String(), 10, 64); err == nil {
+			return time.UnixMilli(timeInput), tag, true
+		}
+
+		for _, layout := range exiftoolDateLayouts {
+			if t, err := time.Parse(layout, value.String()); err == nil {
+				return t, tag, true
+			}
+		}
+		return time.Time{}, tag, false
+	}
+
+	return time.Time{}, "", false
+}
+
+/*
+Model returns the camera model from a file's metadata, before any model replace rules are
+applied.
+*/
+func Model(meta gjson.Result) string {
+	switch {
+	case meta.Get("Model").Exists():
+		return meta.Get("Model").String()
+	case meta.Get("AndroidModel").Exists():
+		return meta.Get("AndroidModel").String()
+	}
+	return "unknown"
+}
+
+/*
+CameraSerial returns the serial number of the camera body a file was taken with, from the
+first of config.CameraSerialTags it has. Empty if it has none.
+*/
+func CameraSerial(meta gjson.Result) string {
+	if serials := CameraSerials(meta); len(serials) > 0 {
+		return serials[0]
+	}
+	return ""
+}
+
+/*
+CameraSerials returns the serial numbers in each of config.CameraSerialTags a file has, in
+the order the tags are checked.
+*/
+func CameraSerials(meta gjson.Result) []string {
+	var serials []string
+	for _, tag := range config.CameraSerialTags {
+		if serial := strings.TrimSpace(meta.Get(gjson.Escape(tag)).String()); serial != "" {
+			serials = append(serials, serial)
+		}
+	}
+	return serials
+}
+
+/*
+FilterFile collects what the file filters need to know about a file from its metadata.
+*/
+func FilterFile(meta gjson.Result, sourceFile string, size int64) config.FilterFile {
+	file := config.FilterFile{
+		Path:   sourceFile,
+		Size:   size,
+		Width:  int(meta.Get("ImageWidth").Int()),
+		Height: int(meta.Get("ImageHeight").Int()),
+		Tags:   Tags(meta),
+	}
+
+	file.MIMEType, file.MIMESubType, _ = SplitMIMEType(meta)
+	file.Taken, _, _ = Timestamp(meta)
+
+	return file
+}
@@ -0,0 +1,63 @@
+package metadata
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestTags(t *testing.T) {
+	meta := gjson.Parse(`{"Make": "DJI", "Model": "FC330", "SerialNumber": "0123456789", "ISO": 100}`)
+	tags := Tags(meta)
+
+	tests := []struct {
+		tag   string
+		want  string
+		found bool
+	}{
+		{"Make", "DJI", true},
+		{"SerialNumber", "0123456789", true},
+		{"ISO", "100", true},
+		{"LensModel", "", false},
+		{"Ma*", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got, found := tags(tt.tag); got != tt.want || found != tt.found {
+				t.Errorf("Tags()('%s') = '%s', %v, want '%s', %v", tt.tag, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
+
+/*
+TestTimestamp makes sure timestamps are taken from the preferred tag a file has, and that
+tags that can't be parsed are reported as such.
+*/
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		name    string
+		meta    string
+		wantTag string
+		wantMs  int64
+		parsed  bool
+	}{
+		{"original", `{"DateTimeOriginal": 1718452800000, "CreateDate": 1718452801000}`, "DateTimeOriginal", 1718452800000, true},
+		{"subsec", `{"SubSecDateTimeOriginal": 1718452800123, "DateTimeOriginal": 1718452800000}`, "SubSecDateTimeOriginal", 1718452800123, true},
+		{"gps", `{"GPSDateTime": 1718452800000}`, "GPSDateTime", 1718452800000, true},
+		{"exiftool format", `{"DateTimeOriginal": "2024:06:15 12:00:00"}`, "DateTimeOriginal", 1718452800000, true},
+		{"exiftool format with zone", `{"DateTimeOriginal": "2024:06:15 14:00:00.123+02:00"}`, "DateTimeOriginal", 1718452800123, true},
+		{"zeroed", `{"CreateDate": "0000:00:00 00:00:00", "ModifyDate": 1718452800000}`, "CreateDate", 0, false},
+		{"none", `{"FileModifyDate": 1718452800000}`, "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, tag, parsed := Timestamp(gjson.Parse(tt.meta))
+			if parsed != tt.parsed || tag != tt.wantTag || (parsed && got.UnixMilli() != tt.wantMs) || (!parsed && !got.IsZero()) {
+				t.Errorf("Timestamp() = %v, '%s', %v, want %d, '%s', %v", got, tag, parsed, tt.wantMs, tt.wantTag, tt.parsed)
+			}
+		})
+	}
+}
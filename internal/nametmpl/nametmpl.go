@@ -0,0 +1,183 @@
+package nametmpl
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+const (
+	DefaultPathTemplate     string = "{{.MIMEType}}/{{.MIMESubType}}/{{.Year}}/{{.Month}}"
+	DefaultFilenameTemplate string = "{{.Year}}{{.Month}}{{.Day}}T{{.Hour}}{{.Minute}}{{.Second}}.{{.Millisecond}}Z-{{.Model}}"
+)
+
+/*
+Fields holds the values that can be referenced from templates, e.g. '{{.Year}}'.
+All values are strings that are already formatted for use in paths.
+*/
+type Fields struct {
+	MIMEType    string
+	MIMESubType string
+	Year        string
+	Month       string
+	Day         string
+	Hour        string
+	Minute      string
+	Second      string
+	Millisecond string
+	Model       string
+	Extension   string
+
+	// Camera is the name given to the camera body in the 'cameras' configuration,
+	// or Model if the camera's serial number isn't listed.
+	Camera       string
+	CameraSerial string
+	LensSerial   string
+
+	// Country, Region and City are where the file was taken, from its GPS position.
+	Country string
+	Region  string
+	City    string
+
+	// Event is the name of the event the file was grouped into, e.g. '2024-06-15_event-01'.
+	Event string
+}
+
+/*
+LocationFields are the fields filled in by reverse geocoding a file's GPS position.
+*/
+var LocationFields = []string{"Country", "Region", "City"}
+
+type Template struct {
+	text string
+	tmpl *template.Template
+}
+
+/*
+Parse() compiles a template for building paths. Referencing a field that doesn't
+exist is an error at render time.
+*/
+func Parse(text string) (*Template, error) {
+	tmpl, err := template.New("path").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// render against empty fields to catch references to fields that don't exist
+	if err = tmpl.Execute(&bytes.Buffer{}, Fields{}); err != nil {
+		return nil, err
+	}
+
+	return &Template{text: text, tmpl: tmpl}, nil
+}
+
+func (t *Template) String() string {
+	return t.text
+}
+
+/*
+Uses() reports whether the template references any of the named fields, so values
+that are expensive to work out can be skipped when they aren't needed.
+*/
+func (t *Template) Uses(fields ...string) bool {
+	for _, tmpl := range t.tmpl.Templates() {
+		if tmpl.Tree != nil && usesField(tmpl.Tree.Root, fields) {
+			return true
+		}
+	}
+	return false
+}
+
+func usesField(node parse.Node, fields []string) bool {
+	switch n := node.(type) {
+	case *parse.FieldNode:
+		for _, field := range fields {
+			if len(n.Ident) > 0 && n.Ident[0] == field {
+				return true
+			}
+		}
+	case *parse.ListNode:
+		if n == nil {
+			return false
+		}
+		for _, child := range n.Nodes {
+			if usesField(child, fields) {
+				return true
+			}
+		}
+	case *parse.ActionNode:
+		return usesField(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return false
+		}
+		for _, cmd := range n.Cmds {
+			if usesField(cmd, fields) {
+				return true
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if usesField(arg, fields) {
+				return true
+			}
+		}
+	case *parse.IfNode:
+		return usesField(n.Pipe, fields) || usesField(n.List, fields) || usesField(n.ElseList, fields)
+	case *parse.RangeNode:
+		return usesField(n.Pipe, fields) || usesField(n.List, fields) || usesField(n.ElseList, fields)
+	case *parse.WithNode:
+		return usesField(n.Pipe, fields) || usesField(n.List, fields) || usesField(n.ElseList, fields)
+	case *parse.TemplateNode:
+		return usesField(n.Pipe, fields)
+	}
+	return false
+}
+
+/*
+RenderPath() renders the template as a relative path using '/' separators.
+The result can't escape the directory it's rendered into.
+*/
+func (t *Template) RenderPath(fields Fields) (string, error) {
+	var buf bytes.Buffer
+
+	if err := t.tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+
+	for _, part := range strings.Split(buf.String(), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("rendered path '%s' cannot contain '..'", buf.String())
+		}
+	}
+
+	// collapse empty components and drop leading/trailing separators
+	rendered := strings.TrimPrefix(path.Clean("/"+buf.String()), "/")
+
+	return rendered, nil
+}
+
+/*
+RenderName() renders the template as a single file name component. The result
+can't be empty or contain path separators.
+*/
+func (t *Template) RenderName(fields Fields) (string, error) {
+	var buf bytes.Buffer
+
+	if err := t.tmpl.Execute(&buf, fields); err != nil {
+		return "", err
+	}
+
+	rendered := buf.String()
+	switch {
+	case rendered == "" || rendered == "." || rendered == "..":
+		return "", fmt.Errorf("rendered name '%s' is not a usable file name", rendered)
+	case strings.Contains(rendered, "/"):
+		return "", fmt.Errorf("rendered name '%s' cannot contain '/'", rendered)
+	}
+
+	return rendered, nil
+}
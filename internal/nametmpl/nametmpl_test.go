@@ -0,0 +1,141 @@
+package nametmpl
+
+import "testing"
+
+/*
+This test verifies that templates are parsed and rendered into clean relative paths
+*/
+func TestTemplate_RenderPath(t *testing.T) {
+	fields := Fields{
+		MIMEType:    "image",
+		MIMESubType: "jpeg",
+		Year:        "2024",
+		Month:       "06",
+		Day:         "15",
+		Model:       "Canon800D",
+		Extension:   "jpg",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"default", DefaultPathTemplate, "image/jpeg/2024/06", false},
+		{"flat", "{{.Year}}-{{.Month}}-{{.Day}}", "2024-06-15", false},
+		{"leading and trailing separators", "/{{.Model}}/", "Canon800D", false},
+		{"empty component", "{{.MIMEType}}/{{.Hour}}/{{.Year}}", "image/2024", false},
+		{"empty result", "{{.Hour}}", "", false},
+		{"parent directory", "../{{.Year}}", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() failed: %s", err)
+			}
+
+			got, err := tmpl.RenderPath(fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Template.RenderPath() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Template.RenderPath() = '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that templates referencing unknown fields are rejected when parsed
+*/
+func TestParse_Invalid(t *testing.T) {
+	for _, text := range []string{"{{.Year", "{{.Yaer}}", `{{template "nope"}}`} {
+		t.Run(text, func(t *testing.T) {
+			if _, err := Parse(text); err == nil {
+				t.Errorf("Parse(%q) succeeded when it should have failed", text)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that file name templates render into a single usable name
+*/
+func TestTemplate_RenderName(t *testing.T) {
+	fields := Fields{
+		Year:         "2024",
+		Month:        "06",
+		Day:          "15",
+		Hour:         "13",
+		Minute:       "04",
+		Second:       "05",
+		Millisecond:  "007",
+		Model:        "Canon800D",
+		Camera:       "Canon800D-B",
+		CameraSerial: "012345",
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"default", DefaultFilenameTemplate, "20240615T130405.007Z-Canon800D", false},
+		{"camera", "{{.Year}}{{.Month}}{{.Day}}-{{.Camera}}", "20240615-Canon800D-B", false},
+		{"serial", "{{.Model}}_CS{{.CameraSerial}}", "Canon800D_CS012345", false},
+		{"empty", "{{.LensSerial}}", "", true},
+		{"separator", "{{.Year}}/{{.Model}}", "", true},
+		{"parent directory", "..", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() failed: %s", err)
+			}
+
+			got, err := tmpl.RenderName(fields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Template.RenderName() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Template.RenderName() = '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that templates report the fields they reference
+*/
+func TestTemplate_Uses(t *testing.T) {
+	tests := []struct {
+		template string
+		want     bool
+	}{
+		{DefaultPathTemplate, false},
+		{DefaultFilenameTemplate, false},
+		{"{{.Country}}/{{.Year}}", true},
+		{"{{.Year}}-{{.City | printf \"%s\"}}", true},
+		{"{{if .Region}}{{.Region}}{{else}}none{{end}}", true},
+		{"{{with .Model}}{{.}}{{end}}", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := Parse(tt.template)
+			if err != nil {
+				t.Fatalf("Parse() failed: %s", err)
+			}
+
+			if got := tmpl.Uses(LocationFields...); got != tt.want {
+				t.Errorf("Template.Uses() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -3,102 +3,150 @@ package paths
 import (
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"strings"
 
 	multierr "github.com/hashicorp/go-multierror"
 )
 
-const (
-	E_VALIDATE_PERMS    string = "permission to access path was denied"
-	E_VALIDATE_NO_EXIST string = "path does not exist"
-	E_AVAIL_PERMS       string = "permission denied"
-	E_AVAIL_PATH_EXISTS string = "path exists"
-	E_AVAIL_UNKNOWN     string = "unknown error from validatePath()"
+/*
+Errors reported when checking paths. They're wrapped in a *PathError, so they should be
+checked for with errors.Is().
+*/
+var (
+	ErrPermission         = errors.New("permission to access path was denied")
+	ErrNotExist           = errors.New("path does not exist")
+	ErrPathExists         = errors.New("path exists")
+	ErrNotDirectory       = errors.New("path is not a directory")
+	ErrNotFileOrDirectory = errors.New("path is not a directory or a file")
 )
 
 /*
-GetMediaPaths() returns the working and media destination directories
-based on command line arguments and/or environment variables
+PathError reports a problem with a path. Kind is one of the Err variables above, and Err
+is the underlying error from the OS, if there was one. errors.Is() matches either, so
+e.g. both ErrPermission and fs.ErrPermission can be checked for.
+*/
+type PathError struct {
+	Path string
+	Kind error
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	switch {
+	case e.Kind == nil:
+		return fmt.Sprintf("could not check path '%s'. %s", e.Path, e.Err)
+	case e.Err != nil && e.Kind != ErrPermission && e.Kind != ErrNotExist:
+		return fmt.Sprintf("%s: '%s'. %s", e.Kind, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: '%s'", e.Kind, e.Path)
+}
+
+func (e *PathError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Kind, e.Err} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+/*
+GetMediaPaths() returns the source paths and media destination directory
+based on command line arguments. Any number of sources (files or directories)
+can be given, followed by the destination directory. extraSources are added
+to the sources found in args, e.g. paths read via ReadPathList().
 
-returns workDir, destRootDir, err
+returns sources, destRootDir, err
 */
-func GetMediaPaths(args []string) (string, string, error) {
-	var workDir string
+func GetMediaPaths(args []string, extraSources []string) ([]string, string, error) {
+	var sources []string
 	var destRootDir string
 	var err error
 
 	badPath := "UNDEFINED"
-	workDir = badPath
 	destRootDir = badPath
 	err = nil
 
-	// TODO: we'll work up to stuff other than positional arguments later
 	argc := len(args)
-	switch {
-	case argc > 1:
-		destRootDir = args[1]
-		fallthrough
-	case argc > 0:
-		workDir = args[0]
-	default:
-
+	if argc > 0 {
+		destRootDir = args[argc-1]
+		sources = append(sources, args[:argc-1]...)
 	}
+	sources = append(sources, extraSources...)
 
-	if workDir == badPath {
-		err = multierr.Append(err, fmt.Errorf("working directory was not found in arguments"))
+	if len(sources) == 0 {
+		err = multierr.Append(err, fmt.Errorf("no source files or directories were found in arguments"))
 	}
 
 	if destRootDir == badPath {
 		err = multierr.Append(err, fmt.Errorf("destination root directory was not found in arguments"))
 	}
 
-	return workDir, destRootDir, err
+	return sources, destRootDir, err
+}
+
+/*
+ReadPathList() reads a list of NUL-separated paths, as written by 'find -print0'.
+Empty entries are ignored.
+*/
+func ReadPathList(r io.Reader) ([]string, error) {
+	var paths []string
+
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range strings.Split(string(contents), "\x00") {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths, nil
 }
 
 /*
 	validatePath determines whether the given path exists, and is minimally readable
 
 	input[0]: path, string
-	output[0]: nil if directory is okay, error message if not
+	output[0]: nil if directory is okay, a *PathError if not
 */
 
 func validatePath(path string) (os.FileInfo, error) {
-	var err error
-	var info os.FileInfo
+	info, err := os.Stat(path)
 
-	info, err = os.Stat(path)
-
-	if os.IsPermission(err) {
-		err = errors.New("permission to access path was denied")
-		return info, err
-	}
-
-	if os.IsNotExist(err) {
-		err = errors.New("path does not exist")
-		return info, err
+	switch {
+	case err == nil:
+		return info, nil
+	case errors.Is(err, fs.ErrPermission):
+		return info, &PathError{Path: path, Kind: ErrPermission, Err: err}
+	case errors.Is(err, fs.ErrNotExist):
+		return info, &PathError{Path: path, Kind: ErrNotExist, Err: err}
 	}
 
-	return info, nil
+	return info, &PathError{Path: path, Err: err}
 }
 
 /*
 	ValidateDirectory determines whether the given path exists, is a directory, and is minimally readable
 
 	input[0]: path, string
-	output[0]: nil if path is okay, error message if not
+	output[0]: nil if path is okay, a *PathError if not
 */
 
 func ValidateDirectory(path string) error {
-	var err error
-
 	info, err := validatePath(path)
 	if err != nil {
 		return err
 	}
 
 	if !info.IsDir() {
-		err = fmt.Errorf("path '%s' is not a directory", path)
-		return err
+		return &PathError{Path: path, Kind: ErrNotDirectory}
 	}
 
 	return nil
@@ -108,40 +156,37 @@ func ValidateDirectory(path string) error {
 	ValidateFileOrDirectory determines whether the given path exists, is a directory OR a file, and is minimally readable
 
 	input[0]: path, string
-	output[0]: nil if path is okay, error message if not
+	output[0]: nil if path is okay, a *PathError if not
 */
 
 func ValidateFileOrDirectory(path string) error {
-	var err error
-
 	info, err := validatePath(path)
 	if err != nil {
 		return err
 	}
 
 	if !info.Mode().IsDir() && !info.Mode().IsRegular() {
-		err = errors.New("path is not a directory or a file")
-		return err
+		return &PathError{Path: path, Kind: ErrNotFileOrDirectory}
 	}
 
 	return nil
 }
 
+/*
+IsPathAvailable reports whether nothing exists at path yet, so something can be put
+there. When the path isn't available, the error says why: ErrPathExists along with the
+FileInfo of what's there, or the problem that kept the path from being checked.
+*/
 func IsPathAvailable(path string) (bool, os.FileInfo, error) {
-	info, err := validatePath((path))
+	info, err := validatePath(path)
 
+	if errors.Is(err, ErrNotExist) {
+		return true, info, nil
+	}
 	if err != nil {
-		switch err.Error() {
-		case E_VALIDATE_NO_EXIST:
-			return true, info, nil
-		case E_VALIDATE_PERMS:
-			return false, info, errors.New(E_AVAIL_PERMS)
-		default:
-			return false, info, errors.New(E_AVAIL_UNKNOWN)
-		}
-	} else {
-		// path exists in some shape or form
-		return false, info, errors.New(E_AVAIL_PATH_EXISTS)
+		return false, info, err
 	}
 
+	// path exists in some shape or form
+	return false, info, &PathError{Path: path, Kind: ErrPathExists}
 }
@@ -0,0 +1,130 @@
+package paths
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+This test verifies that sources and the destination are pulled from arguments correctly.
+*/
+func TestGetMediaPaths(t *testing.T) {
+	tests := []struct {
+		name         string
+		args         []string
+		extraSources []string
+		wantSources  []string
+		wantDest     string
+		wantErr      bool
+	}{
+		{"one source", []string{"src", "dest"}, nil, []string{"src"}, "dest", false},
+		{"many sources", []string{"src1", "src2/IMG_0001.JPG", "src3", "dest"}, nil, []string{"src1", "src2/IMG_0001.JPG", "src3"}, "dest", false},
+		{"extra sources only", []string{"dest"}, []string{"a", "b"}, []string{"a", "b"}, "dest", false},
+		{"sources and extra sources", []string{"src", "dest"}, []string{"a"}, []string{"src", "a"}, "dest", false},
+		{"destination only", []string{"dest"}, nil, nil, "dest", true},
+		{"no arguments", nil, nil, nil, "UNDEFINED", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sources, dest, err := GetMediaPaths(tt.args, tt.extraSources)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetMediaPaths() err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(sources, tt.wantSources) {
+				t.Errorf("GetMediaPaths() sources = %v, want %v", sources, tt.wantSources)
+			}
+			if dest != tt.wantDest {
+				t.Errorf("GetMediaPaths() dest = '%s', want '%s'", dest, tt.wantDest)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that NUL-separated path lists are split correctly.
+*/
+func TestReadPathList(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"find -print0", "./a/IMG_0001.JPG\x00./b/with space.jpg\x00", []string{"./a/IMG_0001.JPG", "./b/with space.jpg"}},
+		{"no trailing NUL", "one\x00two", []string{"one", "two"}},
+		{"newline in name", "line\nbreak.jpg\x00", []string{"line\nbreak.jpg"}},
+		{"empty entries", "\x00\x00one\x00\x00", []string{"one"}},
+		{"empty input", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadPathList(strings.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("ReadPathList() failed: %s", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ReadPathList() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that problems with paths can be told apart with errors.Is() and
+errors.As().
+*/
+func TestPathErrors(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "IMG_0001.JPG")
+	if err := os.WriteFile(file, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	tests := []struct {
+		name     string
+		check    func() error
+		wantKind error
+		wantPath string
+	}{
+		{"directory", func() error { return ValidateDirectory(dir) }, nil, ""},
+		{"file as directory", func() error { return ValidateDirectory(file) }, ErrNotDirectory, file},
+		{"missing directory", func() error { return ValidateDirectory(missing) }, ErrNotExist, missing},
+		{"file", func() error { return ValidateFileOrDirectory(file) }, nil, ""},
+		{"missing file", func() error { return ValidateFileOrDirectory(missing) }, ErrNotExist, missing},
+		{"available", func() error { _, _, err := IsPathAvailable(missing); return err }, nil, ""},
+		{"taken", func() error { _, _, err := IsPathAvailable(file); return err }, ErrPathExists, file},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.check()
+			if tt.wantKind == nil {
+				if err != nil {
+					t.Errorf("got error '%s', want none", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("got error '%v', want one matching '%s'", err, tt.wantKind)
+			}
+
+			var pathErr *PathError
+			if !errors.As(err, &pathErr) || pathErr.Path != tt.wantPath {
+				t.Errorf("got error '%v', want a *PathError for '%s'", err, tt.wantPath)
+			}
+		})
+	}
+
+	// the underlying error from the OS is kept too
+	if err := ValidateDirectory(missing); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error '%v', want one matching fs.ErrNotExist", err)
+	}
+}
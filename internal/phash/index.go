@@ -0,0 +1,173 @@
+package phash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Entry is the hash of a file, along with the size and modification time it had when it was
+hashed, so that files changed since can be hashed again.
+*/
+type Entry struct {
+	Path    string
+	Size    int64
+	ModTime int64 // nanoseconds since the Unix epoch
+	Hash    uint64
+}
+
+/*
+Current() reports whether the entry still matches the file it was made from.
+*/
+func (e Entry) Current(info fs.FileInfo) bool {
+	return e.Size == info.Size() && e.ModTime == info.ModTime().UnixNano()
+}
+
+/*
+Match is an indexed file that's close to a hash.
+*/
+type Match struct {
+	Entry
+	Distance int
+}
+
+/*
+Index holds the perceptual hashes of files, using a single algorithm.
+*/
+type Index struct {
+	Algorithm Algorithm
+	entries   map[string]Entry
+}
+
+func NewIndex(algorithm Algorithm) *Index {
+	return &Index{Algorithm: algorithm, entries: make(map[string]Entry)}
+}
+
+/*
+IndexFileName() returns the name of the file an index using the algorithm is saved in.
+*/
+func IndexFileName(algorithm Algorithm) string {
+	return fmt.Sprintf(".mediafiler-%s.tsv", algorithm)
+}
+
+/*
+LoadIndex() reads an index saved with Save(). An index that doesn't exist yet is empty.
+*/
+func LoadIndex(path string, algorithm Algorithm) (*Index, error) {
+	index := NewIndex(algorithm)
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		cols := strings.Split(scanner.Text(), "\t")
+		if len(cols) != 4 {
+			return nil, fmt.Errorf("line %d of index %s has %d columns, expected 4", line, path, len(cols))
+		}
+
+		var entry Entry
+		var errs [3]error
+		entry.Hash, errs[0] = strconv.ParseUint(cols[0], 16, 64)
+		entry.Size, errs[1] = strconv.ParseInt(cols[1], 10, 64)
+		entry.ModTime, errs[2] = strconv.ParseInt(cols[2], 10, 64)
+		if err := errors.Join(errs[:]...); err != nil {
+			return nil, fmt.Errorf("line %d of index %s is not valid. %s", line, path, err)
+		}
+
+		if entry.Path, err = strconv.Unquote(cols[3]); err != nil {
+			return nil, fmt.Errorf("line %d of index %s has a bad path. %s", line, path, err)
+		}
+		index.entries[entry.Path] = entry
+	}
+
+	return index, scanner.Err()
+}
+
+/*
+Save() writes the index to path, replacing it in a single step so a failed write doesn't
+lose the previous index.
+*/
+func (i *Index) Save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writer := bufio.NewWriter(tmp)
+	for _, entry := range i.Entries() {
+		fmt.Fprintf(writer, "%016x\t%d\t%d\t%s\n", entry.Hash, entry.Size, entry.ModTime, strconv.Quote(entry.Path))
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (i *Index) Get(path string) (Entry, bool) {
+	entry, ok := i.entries[path]
+	return entry, ok
+}
+
+func (i *Index) Set(entry Entry) {
+	i.entries[entry.Path] = entry
+}
+
+func (i *Index) Remove(path string) {
+	delete(i.entries, path)
+}
+
+/*
+Entries() returns every entry, sorted by path.
+*/
+func (i *Index) Entries() []Entry {
+	entries := make([]Entry, 0, len(i.entries))
+	for _, entry := range i.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a int, b int) bool { return entries[a].Path < entries[b].Path })
+	return entries
+}
+
+/*
+Closest() returns the indexed file whose hash is closest to hash, if it's within
+maxDistance bits. Files in exclude aren't considered, e.g. so a file isn't matched to
+itself. Ties go to the file whose path sorts first.
+*/
+func (i *Index) Closest(hash uint64, maxDistance int, exclude ...string) (Match, bool) {
+	var best Match
+	found := false
+
+	for _, entry := range i.entries {
+		distance := Distance(hash, entry.Hash)
+		if distance > maxDistance || slices.Contains(exclude, entry.Path) {
+			continue
+		}
+
+		if !found || distance < best.Distance || (distance == best.Distance && entry.Path < best.Path) {
+			best, found = Match{Entry: entry, Distance: distance}, true
+		}
+	}
+
+	return best, found
+}
@@ -0,0 +1,187 @@
+/*
+Package phash computes perceptual hashes of images, which stay close to each other when an
+image is resized, re-compressed or has its metadata stripped, unlike checksums of its
+contents.
+*/
+package phash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+/*
+Algorithm is a way of hashing an image.
+*/
+type Algorithm string
+
+const (
+	DHASH Algorithm = "dhash" // compares the brightness of neighbouring pixels. fast, and good at re-encoded copies
+	PHASH Algorithm = "phash" // compares frequencies from a discrete cosine transform. slower, but copes better with edits
+)
+
+/*
+IsValid() reports whether the algorithm is known.
+*/
+func (a Algorithm) IsValid() bool {
+	return a == DHASH || a == PHASH
+}
+
+/*
+Hash() returns the perceptual hash of an image.
+*/
+func Hash(img image.Image, algorithm Algorithm) (uint64, error) {
+	switch algorithm {
+	case DHASH:
+		return dHash(img), nil
+	case PHASH:
+		return pHash(img), nil
+	}
+	return 0, fmt.Errorf("'%s' is not a perceptual hash algorithm", algorithm)
+}
+
+/*
+HashFile() decodes a JPEG or PNG file and returns its perceptual hash.
+*/
+func HashFile(path string, algorithm Algorithm) (uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return Hash(img, algorithm)
+}
+
+/*
+Distance() returns the number of bits that differ between two hashes. Copies of the same
+image are usually within a few bits of each other.
+*/
+func Distance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+/*
+dHash shrinks the image to 9x8 and sets a bit for each pixel that's brighter than the one
+to its right.
+*/
+func dHash(img image.Image) uint64 {
+	pixels := grayscale(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+/*
+pHash shrinks the image to 32x32, takes the lowest 8x8 frequencies of its discrete cosine
+transform, and sets a bit for each one above their median.
+*/
+func pHash(img image.Image) uint64 {
+	const size, keep = 32, 8
+	pixels := grayscale(img, size, size)
+
+	// a 2D DCT-II is a 1D DCT of each row, then of each column
+	cosines := make([]float64, size*keep)
+	for k := 0; k < keep; k++ {
+		for n := 0; n < size; n++ {
+			cosines[k*size+n] = math.Cos(math.Pi / size * (float64(n) + 0.5) * float64(k))
+		}
+	}
+
+	rows := make([]float64, size*keep)
+	for y := 0; y < size; y++ {
+		for k := 0; k < keep; k++ {
+			var sum float64
+			for x := 0; x < size; x++ {
+				sum += pixels[y*size+x] * cosines[k*size+x]
+			}
+			rows[y*keep+k] = sum
+		}
+	}
+
+	coeffs := make([]float64, keep*keep)
+	for kx := 0; kx < keep; kx++ {
+		for ky := 0; ky < keep; ky++ {
+			var sum float64
+			for y := 0; y < size; y++ {
+				sum += rows[y*keep+kx] * cosines[ky*size+y]
+			}
+			coeffs[ky*keep+kx] = sum
+		}
+	}
+
+	// the first coefficient is the image's average brightness, which would skew the median
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, coeff := range coeffs {
+		hash <<= 1
+		if coeff > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+/*
+grayscale shrinks an image to width x height by averaging the brightness of the pixels
+covered by each cell, returning the cells row by row.
+*/
+func grayscale(img image.Image, width int, height int) []float64 {
+	bounds := img.Bounds()
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+
+	sums := make([]float64, width*height)
+	counts := make([]float64, width*height)
+	if srcWidth == 0 || srcHeight == 0 {
+		return sums
+	}
+
+	// decoded JPEGs hold their brightness in a plane of their own, which is much faster to read
+	luma := func(x int, y int) float64 {
+		return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+	}
+	if ycbcr, ok := img.(*image.YCbCr); ok {
+		luma = func(x int, y int) float64 {
+			return float64(ycbcr.Y[ycbcr.YOffset(x, y)])
+		}
+	}
+
+	for y := 0; y < srcHeight; y++ {
+		row := (y * height / srcHeight) * width
+		for x := 0; x < srcWidth; x++ {
+			cell := row + x*width/srcWidth
+			sums[cell] += luma(bounds.Min.X+x, bounds.Min.Y+y)
+			counts[cell]++
+		}
+	}
+
+	for idx := range sums {
+		if counts[idx] > 0 {
+			sums[idx] /= counts[idx]
+		}
+	}
+	return sums
+}
@@ -0,0 +1,163 @@
+package phash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+testImage draws a picture made of soft shapes, with seed changing what's in it.
+*/
+func testImage(width int, height int, seed float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			r := 128 + 127*math.Sin(6*u*seed+v*3)
+			g := 128 + 127*math.Cos(5*v*seed-u*2)
+			b := 128 + 127*math.Sin(9*(u-0.5)*(v-0.5)*seed)
+			img.Set(x, y, color.RGBA{uint8(r), uint8(g), uint8(b), 255})
+		}
+	}
+	return img
+}
+
+/*
+This test verifies that resized and re-compressed copies of an image hash close to it,
+and that a different image doesn't
+*/
+func TestHash(t *testing.T) {
+	original := testImage(640, 480, 1)
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(320, 240, 1), &jpeg.Options{Quality: 40}); err != nil {
+		t.Fatal(err)
+	}
+	recompressed, err := jpeg.Decode(&encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	different := testImage(640, 480, 2.7)
+
+	for _, algorithm := range []Algorithm{DHASH, PHASH} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			hash := func(img image.Image) uint64 {
+				h, err := Hash(img, algorithm)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return h
+			}
+
+			if d := Distance(hash(original), hash(recompressed)); d > 6 {
+				t.Errorf("a smaller, re-compressed copy is %d bits away, want 6 or fewer", d)
+			}
+			if d := Distance(hash(original), hash(different)); d < 16 {
+				t.Errorf("a different image is %d bits away, want 16 or more", d)
+			}
+		})
+	}
+
+	if _, err := Hash(original, "md5"); err == nil {
+		t.Errorf("Hash() with an unknown algorithm succeeded when it should have failed")
+	}
+}
+
+/*
+This test verifies that PNG and JPEG files can be hashed
+*/
+func TestHashFile(t *testing.T) {
+	dir := t.TempDir()
+	img := testImage(200, 150, 1)
+
+	files := map[string]func(*os.File) error{
+		"a.png": func(f *os.File) error { return png.Encode(f, img) },
+		"a.jpg": func(f *os.File) error { return jpeg.Encode(f, img, nil) },
+		"a.txt": func(f *os.File) error { _, err := f.WriteString("not an image"); return err },
+	}
+
+	hashes := make(map[string]uint64)
+	for name, encode := range files {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := encode(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		hash, err := HashFile(filepath.Join(dir, name), DHASH)
+		if (err != nil) != (name == "a.txt") {
+			t.Fatalf("HashFile(%s) err = %v", name, err)
+		}
+		hashes[name] = hash
+	}
+
+	if d := Distance(hashes["a.png"], hashes["a.jpg"]); d > 4 {
+		t.Errorf("the PNG and JPEG copies are %d bits apart, want 4 or fewer", d)
+	}
+}
+
+/*
+This test verifies that indexes are saved and loaded, and find the closest hash
+*/
+func TestIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), IndexFileName(DHASH))
+
+	index, err := LoadIndex(path, DHASH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries()) != 0 {
+		t.Fatalf("an index that hasn't been saved has %d entries, want 0", len(index.Entries()))
+	}
+
+	index.Set(Entry{Path: "/photos/a.jpg", Size: 100, ModTime: 1718456645000000000, Hash: 0xff00ff00ff00ff00})
+	index.Set(Entry{Path: "/photos/b\tc.jpg", Size: 200, ModTime: 1718456645000000001, Hash: 0xff00ff00ff00ff0f})
+	index.Set(Entry{Path: "/photos/d.jpg", Size: 300, ModTime: 1718456645000000002, Hash: 0x00ff00ff00ff00ff})
+	index.Remove("/photos/d.jpg")
+
+	if err := index.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadIndex(path, DHASH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := loaded.Entries(), index.Entries(); len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("loaded entries %+v, want %+v", got, want)
+	}
+
+	tests := []struct {
+		hash     uint64
+		want     string
+		distance int
+		found    bool
+	}{
+		{0xff00ff00ff00ff01, "/photos/a.jpg", 1, true},
+		{0xff00ff00ff00ff70, "/photos/a.jpg", 3, true},
+		{0xff00ff00ff00ff0e, "/photos/b\tc.jpg", 1, true},
+		{0x00ff00ff00ff00ff, "", 0, false},
+	}
+
+	if match, found := loaded.Closest(0xff00ff00ff00ff01, 4, "/photos/a.jpg"); !found || match.Path != "/photos/b\tc.jpg" {
+		t.Errorf("Closest() with a.jpg excluded = %+v, %v, want b\tc.jpg", match, found)
+	}
+
+	for _, tt := range tests {
+		match, found := loaded.Closest(tt.hash, 4)
+		if found != tt.found || match.Path != tt.want || match.Distance != tt.distance {
+			t.Errorf("Closest(%016x) = %+v, %v, want '%s' at %d, %v", tt.hash, match, found, tt.want, tt.distance, tt.found)
+		}
+	}
+}
@@ -4,15 +4,144 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"slices"
 	"strings"
 
 	multierr "github.com/hashicorp/go-multierror"
 )
 
+/*
+RuleError describes a problem with a single field of a rule, so that callers can
+point users at the setting that needs fixing. Problems with an entry of a list field
+(such as Conditions) are described by a nested RuleError, with Index set to the
+position of the entry.
+*/
+type RuleError struct {
+	Field string
+	Index int
+	Err   error
+}
+
+func (e *RuleError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
+}
+
+/*
+TagLookup returns the value of a metadata tag (e.g. "Make" or "SerialNumber") for
+the thing being renamed, and whether the tag is present.
+*/
+type TagLookup func(tag string) (string, bool)
+
+/*
+ReplacerCondition limits a ReplacerRule to inputs whose metadata tag Tag matches
+Pattern. String conditions match the tag's value exactly, and regex conditions
+match it using a regular expression.
+*/
+type ReplacerCondition struct {
+	Tag     string
+	Type    string
+	Pattern string
+	pattern *regexp.Regexp
+}
+
+func (rc ReplacerCondition) IsValid() (bool, error) {
+	var merr error
+
+	if rc.Tag == "" {
+		merr = multierr.Append(merr, &RuleError{Field: "Tag", Err: errors.New("the Tag cannot be empty")})
+	}
+
+	switch rc.Type {
+	case "regex":
+		if _, err := regexp.Compile(rc.Pattern); err != nil {
+			merr = multierr.Append(merr, &RuleError{Field: "Pattern", Err: errors.New("value Pattern is not a valid regex")})
+		}
+	case "string":
+	default:
+		merr = multierr.Append(merr, &RuleError{Field: "Type", Err: fmt.Errorf("invalid ReplacerCondition type '%s'. valid types are 'regex' or 'string'", rc.Type)})
+	}
+
+	return merr == nil, merr
+}
+
+/*
+Matches reports whether the condition holds for the metadata available from tags.
+A condition never holds for a tag that isn't present.
+*/
+func (rc ReplacerCondition) Matches(tags TagLookup) bool {
+	if tags == nil {
+		return false
+	}
+
+	value, ok := tags(rc.Tag)
+	if !ok {
+		return false
+	}
+
+	switch rc.Type {
+	case "string":
+		return value == rc.Pattern
+	case "regex":
+		rx := rc.pattern
+		if rx == nil {
+			// the condition wasn't added through Replacer.AddRule
+			var err error
+			if rx, err = regexp.Compile(rc.Pattern); err != nil {
+				return false
+			}
+		}
+		return rx.MatchString(value)
+	}
+	return false
+}
+
+/*
+Compile returns a copy of the condition with its pattern compiled, so it doesn't need
+to be compiled every time the condition is checked.
+*/
+func (rc ReplacerCondition) Compile() (ReplacerCondition, error) {
+	var err error
+
+	if rc.Type == "regex" {
+		rc.pattern, err = regexp.Compile(rc.Pattern)
+	}
+	return rc, err
+}
+
+/*
+Equal reports whether two conditions are the same.
+*/
+func (rc ReplacerCondition) Equal(other ReplacerCondition) bool {
+	return rc.Tag == other.Tag && rc.Type == other.Type && rc.Pattern == other.Pattern
+}
+
+/*
+ReplacerRule replaces Find with ReplaceWith. If Conditions are given, the rule is
+only applied when all of them hold. If StopAfterMatch is set, no further rules are
+applied once this one has matched.
+*/
 type ReplacerRule struct {
-	Type        string
-	Find        string
-	ReplaceWith string
+	Type           string
+	Find           string
+	ReplaceWith    string
+	Conditions     []ReplacerCondition
+	StopAfterMatch bool
+	find           *regexp.Regexp
+}
+
+/*
+Equal reports whether two rules are the same.
+*/
+func (rr ReplacerRule) Equal(other ReplacerRule) bool {
+	return rr.Type == other.Type &&
+		rr.Find == other.Find &&
+		rr.ReplaceWith == other.ReplaceWith &&
+		rr.StopAfterMatch == other.StopAfterMatch &&
+		slices.EqualFunc(rr.Conditions, other.Conditions, ReplacerCondition.Equal)
 }
 
 func (rr ReplacerRule) IsValid() (bool, error) {
@@ -27,13 +156,13 @@ func (rr ReplacerRule) IsValid() (bool, error) {
 	case "regex":
 		_, err = regexp.Compile(rr.Find)
 		if err != nil {
-			err = errors.New("value Find is not a valid regex")
+			err = &RuleError{Field: "Find", Err: errors.New("value Find is not a valid regex")}
 			valid = false
 		}
 	case "string":
 		err = nil
 	default:
-		err = fmt.Errorf("invalid ReplacerRule type '%s'. valid types are 'regex' or 'string'", rr.Type)
+		err = &RuleError{Field: "Type", Err: fmt.Errorf("invalid ReplacerRule type '%s'. valid types are 'regex' or 'string'", rr.Type)}
 	}
 
 	if err != nil {
@@ -43,45 +172,189 @@ func (rr ReplacerRule) IsValid() (bool, error) {
 
 	// the thing we're searching for shouldn't be empty
 	if len(rr.Find) == 0 {
-		merr = multierr.Append(merr, errors.New("the Find term cannot be empty"))
+		merr = multierr.Append(merr, &RuleError{Field: "Find", Err: errors.New("the Find term cannot be empty")})
 		valid = false
 	}
 
+	for idx, condition := range rr.Conditions {
+		if ok, err := condition.IsValid(); !ok {
+			for _, e := range err.(*multierr.Error).WrappedErrors() {
+				merr = multierr.Append(merr, &RuleError{Field: "Conditions", Index: idx, Err: e})
+			}
+			valid = false
+		}
+	}
+
 	return valid, merr
 
 }
 
+/*
+conditionsMatch reports whether all of the rule's conditions hold, given the
+metadata available from tags.
+*/
+func (rr ReplacerRule) conditionsMatch(tags TagLookup) bool {
+	for _, condition := range rr.Conditions {
+		if !condition.Matches(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+compile returns a copy of the rule with its regular expressions compiled, so they
+don't need to be compiled for every input. The rule must be valid.
+*/
+func (rr ReplacerRule) compile() (ReplacerRule, error) {
+	var err error
+
+	if rr.Type == "regex" {
+		if rr.find, err = regexp.Compile(rr.Find); err != nil {
+			return rr, err
+		}
+	}
+
+	conditions := make([]ReplacerCondition, len(rr.Conditions))
+	for idx, condition := range rr.Conditions {
+		if conditions[idx], err = condition.Compile(); err != nil {
+			return rr, err
+		}
+	}
+	rr.Conditions = conditions
+
+	return rr, nil
+}
+
+/*
+regex returns the rule's compiled Find expression, compiling it if the rule wasn't
+added through AddRule.
+*/
+func (rr ReplacerRule) regex() (*regexp.Regexp, error) {
+	if rr.find != nil {
+		return rr.find, nil
+	}
+	return regexp.Compile(rr.Find)
+}
+
+/*
+Replacer applies a list of rules in order. Rules are compiled when they're added,
+and a Replacer is safe for concurrent use once all of its rules have been added.
+*/
 type Replacer []ReplacerRule
 
 func (r *Replacer) AddRule(Rule ReplacerRule) error {
 	valid, err := Rule.IsValid()
 	if !valid {
 		return fmt.Errorf("new replacer rule is not valid. reason: %s", err)
-	} else {
-		*r = append(*r, Rule)
-		return nil
 	}
+
+	Rule, err = Rule.compile()
+	if err != nil {
+		return fmt.Errorf("new replacer rule could not be compiled. reason: %s", err)
+	}
+
+	*r = append(*r, Rule)
+	return nil
 }
 
 func (r Replacer) Replace(input string) (string, error) {
+	return r.ReplaceMatching(input, nil)
+}
+
+/*
+ReplaceMatching applies the rules to input in order, like Replace, using tags to
+check the conditions of conditional rules. Conditional rules are never applied if
+tags is nil.
+*/
+func (r Replacer) ReplaceMatching(input string, tags TagLookup) (string, error) {
+	return r.replace(input, tags, nil)
+}
+
+const (
+	STEP_REPLACED          string = "replaced"
+	STEP_NO_MATCH          string = "no match"
+	STEP_CONDITIONS_FAILED string = "conditions not met"
+)
+
+/*
+ReplaceStep describes what a single rule did while a Replacer was applied. Outcome
+is one of the STEP_ constants.
+*/
+type ReplaceStep struct {
+	Index   int
+	Rule    ReplacerRule
+	Input   string
+	Output  string
+	Outcome string
+}
+
+/*
+Trace applies the rules to input like ReplaceMatching, and also returns a step for
+each rule that was checked. Rules after one that stopped the chain aren't checked.
+*/
+func (r Replacer) Trace(input string, tags TagLookup) (string, []ReplaceStep, error) {
+	var steps []ReplaceStep
+
+	output, err := r.replace(input, tags, func(step ReplaceStep) {
+		steps = append(steps, step)
+	})
+	return output, steps, err
+}
+
+func (r Replacer) replace(input string, tags TagLookup, trace func(ReplaceStep)) (string, error) {
 	output := input
 
-	// fmt.Printf("before modification: '%s'\n", input)
+	for k, v := range r {
+		step := ReplaceStep{Index: k, Rule: v, Input: output, Output: output, Outcome: STEP_NO_MATCH}
+
+		switch {
+		case !v.conditionsMatch(tags):
+			step.Outcome = STEP_CONDITIONS_FAILED
+
+		case v.Type == "string":
+			if strings.Contains(output, v.Find) {
+				output = strings.ReplaceAll(output, v.Find, v.ReplaceWith)
+				step.Outcome = STEP_REPLACED
+			}
+
+		case v.Type == "regex":
+			rx, err := v.regex()
+			if err != nil {
+				return "", fmt.Errorf("rule find pattern '%s' is not a valid regex. reason: %s", v.Find, err)
+			}
+			if rx.MatchString(output) {
+				output = rx.ReplaceAllString(output, v.ReplaceWith)
+				step.Outcome = STEP_REPLACED
+			}
 
-	for _, v := range r {
-		switch v.Type {
-		case "string":
-			output = strings.ReplaceAll(output, v.Find, v.ReplaceWith)
-		case "regex":
-			rx := regexp.MustCompile(v.Find)
-			output = rx.ReplaceAllString(output, v.ReplaceWith)
 		default:
 			// this *should* get caught by AddRule/IsValid, but a sufficiently motivated individual could get around these
 			return "", fmt.Errorf("rule type '%s' is non-sensical", v.Type)
 		}
 
-		// fmt.Printf("after modification #%d: '%s'\n", k, output)
+		step.Output = output
+		if trace != nil {
+			trace(step)
+		}
+
+		if step.Outcome == STEP_REPLACED && v.StopAfterMatch {
+			break
+		}
 	}
 
 	return output, nil
 }
+
+/*
+NewSpecialReplacer returns the replacer for characters that can't be used in file and
+directory names.
+*/
+func NewSpecialReplacer() Replacer {
+	var specialReplacer Replacer
+
+	specialReplacer.AddRule(ReplacerRule{Type: "string", Find: `/`, ReplaceWith: "_"})
+	specialReplacer.AddRule(ReplacerRule{Type: "string", Find: `\`, ReplaceWith: "_"})
+
+	return specialReplacer
+}
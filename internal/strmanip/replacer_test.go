@@ -1,6 +1,10 @@
 package strmanip
 
-import "testing"
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
 
 /*
 This test verfies the IsValid method passes/fails individual rules correctly
@@ -20,6 +24,11 @@ func TestReplacerIsValid(t *testing.T) {
 
 		{"valid regex replace", ReplacerRule{Type: "regex", Find: "^$", ReplaceWith: "replacewithme"}, true},
 		{"invalid regex replace bad Find regex", ReplacerRule{Type: "regex", Find: "^((", ReplaceWith: "replacewithme"}, false},
+
+		{"valid conditional replace", ReplacerRule{Type: "string", Find: "findme", Conditions: []ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "DJI"}}}, true},
+		{"invalid conditional replace empty Tag", ReplacerRule{Type: "string", Find: "findme", Conditions: []ReplacerCondition{{Tag: "", Type: "string", Pattern: "DJI"}}}, false},
+		{"invalid conditional replace bad type", ReplacerRule{Type: "string", Find: "findme", Conditions: []ReplacerCondition{{Tag: "Make", Type: "glob", Pattern: "DJI"}}}, false},
+		{"invalid conditional replace bad Pattern regex", ReplacerRule{Type: "string", Find: "findme", Conditions: []ReplacerCondition{{Tag: "Make", Type: "regex", Pattern: "^(("}}}, false},
 	}
 
 	for _, tt := range tests {
@@ -91,7 +100,7 @@ func TestReplacerAddRule(t *testing.T) {
 		t.Errorf("ReplacerRule.AddRule(): rule count is %v, expected 5", l)
 	}
 
-	if r[1] != rule3 {
+	if !r[1].Equal(rule3) {
 		t.Errorf("ReplacerRule.AddRule(): rule 1 is expected to match test rule 3, but doesn't")
 	}
 
@@ -286,3 +295,168 @@ func TestReplacerReplace_Complex(t *testing.T) {
 	}
 
 }
+
+/*
+This test verifies that rule conditions are checked against metadata tags, and that
+rules marked StopAfterMatch end processing once they've matched.
+*/
+func TestReplacerReplace_Conditional(t *testing.T) {
+	tags := func(values map[string]string) TagLookup {
+		return func(tag string) (string, bool) {
+			value, ok := values[tag]
+			return value, ok
+		}
+	}
+
+	dji := tags(map[string]string{"Make": "DJI", "Model": "FC330"})
+	canonA := tags(map[string]string{"Make": "Canon", "SerialNumber": "012345"})
+	canonB := tags(map[string]string{"Make": "Canon", "SerialNumber": "067890"})
+
+	var r Replacer
+	r.AddRule(ReplacerRule{Type: "regex", Find: "^FC330$", ReplaceWith: "Phantom4", StopAfterMatch: true,
+		Conditions: []ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "DJI"}}})
+	r.AddRule(ReplacerRule{Type: "string", Find: "Canon EOS Rebel T7i", ReplaceWith: "Canon800D-A", StopAfterMatch: true,
+		Conditions: []ReplacerCondition{{Tag: "SerialNumber", Type: "regex", Pattern: "^0123"}}})
+	r.AddRule(ReplacerRule{Type: "string", Find: "Canon EOS Rebel T7i", ReplaceWith: "Canon800D"})
+	r.AddRule(ReplacerRule{Type: "string", Find: " ", ReplaceWith: "_"})
+
+	tests := []struct {
+		name  string
+		input string
+		tags  TagLookup
+		want  string
+	}{
+		{"conditional match and stop", "FC330", dji, "Phantom4"},
+		{"conditional no match on other tag", "FC330", canonA, "FC330"},
+		{"conditional regex match and stop", "Canon EOS Rebel T7i", canonA, "Canon800D-A"},
+		{"conditional regex no match", "Canon EOS Rebel T7i", canonB, "Canon800D"},
+		{"conditional no tags", "Canon EOS Rebel T7i", nil, "Canon800D"},
+		{"stop only after a match", "Canon EOS 5D", canonA, "Canon_EOS_5D"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := r.ReplaceMatching(tt.input, tt.tags); got != tt.want {
+				t.Errorf("Replacer.ReplaceMatching() = '%v', want '%v'", got, tt.want)
+			}
+		})
+	}
+
+}
+
+/*
+This test verifies that Trace reports what each rule did, and stops reporting once a rule
+stops the chain.
+*/
+func TestReplacerTrace(t *testing.T) {
+	canon := func(tag string) (string, bool) {
+		if tag == "Make" {
+			return "Canon", true
+		}
+		return "", false
+	}
+
+	var r Replacer
+	r.AddRule(ReplacerRule{Type: "string", Find: "Nikon", ReplaceWith: "NK"})
+	r.AddRule(ReplacerRule{Type: "regex", Find: "^FC330$", ReplaceWith: "Phantom4",
+		Conditions: []ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "DJI"}}})
+	r.AddRule(ReplacerRule{Type: "string", Find: "Canon EOS Rebel T7i", ReplaceWith: "Canon 800D", StopAfterMatch: true})
+	r.AddRule(ReplacerRule{Type: "regex", Find: `\s+`, ReplaceWith: ""})
+
+	got, steps, err := r.Trace("Canon EOS Rebel T7i", canon)
+	if err != nil {
+		t.Fatalf("Replacer.Trace() failed: %s", err)
+	}
+	if got != "Canon 800D" {
+		t.Errorf("Replacer.Trace() = '%s', want 'Canon 800D'", got)
+	}
+
+	want := []ReplaceStep{
+		{Index: 0, Input: "Canon EOS Rebel T7i", Output: "Canon EOS Rebel T7i", Outcome: STEP_NO_MATCH},
+		{Index: 1, Input: "Canon EOS Rebel T7i", Output: "Canon EOS Rebel T7i", Outcome: STEP_CONDITIONS_FAILED},
+		{Index: 2, Input: "Canon EOS Rebel T7i", Output: "Canon 800D", Outcome: STEP_REPLACED},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("Replacer.Trace() returned %d steps, want %d", len(steps), len(want))
+	}
+	for idx, step := range steps {
+		if step.Index != want[idx].Index || step.Input != want[idx].Input || step.Output != want[idx].Output ||
+			step.Outcome != want[idx].Outcome || !step.Rule.Equal(r[step.Index]) {
+			t.Errorf("step %d is %+v, want %+v", idx, step, want[idx])
+		}
+	}
+}
+
+/*
+This test verifies that rules which bypassed AddRule produce errors instead of panics.
+*/
+func TestReplacerReplace_Unvalidated(t *testing.T) {
+	r := Replacer{ReplacerRule{Type: "regex", Find: "^((", ReplaceWith: "oops"}}
+
+	if _, err := r.Replace("input"); err == nil {
+		t.Errorf("Replacer.Replace() succeeded with an invalid regex")
+	}
+}
+
+/*
+This test verifies that a Replacer can be used from multiple goroutines at once. It's most
+useful when run with -race.
+*/
+func TestReplacerReplace_Concurrent(t *testing.T) {
+	var r Replacer
+	r.AddRule(ReplacerRule{Type: "regex", Find: `^Canon EOS (\d+)D$`, ReplaceWith: "Canon${1}D"})
+	r.AddRule(ReplacerRule{Type: "string", Find: " ", ReplaceWith: "_"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got, _ := r.Replace("Canon EOS 800D"); got != "Canon800D" {
+					t.Errorf("Replacer.Replace() = '%v', want 'Canon800D'", got)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+/*
+benchmarkRules is a model replace rule set roughly the size of a real configuration.
+*/
+func benchmarkRules(b *testing.B) Replacer {
+	var r Replacer
+
+	for i := 0; i < 20; i++ {
+		if err := r.AddRule(ReplacerRule{Type: "regex", Find: fmt.Sprintf(`^Canon EOS %dD$`, i), ReplaceWith: fmt.Sprintf("Canon%dD", i)}); err != nil {
+			b.Fatal(err)
+		}
+		if err := r.AddRule(ReplacerRule{Type: "string", Find: fmt.Sprintf("NIKON D%d", i), ReplaceWith: fmt.Sprintf("NikonD%d", i)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	return r
+}
+
+func BenchmarkReplacerReplace(b *testing.B) {
+	r := benchmarkRules(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Replace("Canon EOS 19D")
+	}
+}
+
+func BenchmarkReplacerReplace_Parallel(b *testing.B) {
+	r := benchmarkRules(b)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Replace("Canon EOS 19D")
+		}
+	})
+}
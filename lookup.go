@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/filerbridge"
+	"github.com/d0ct0rvenkman/mediafiler/pkg/filer"
+)
+
+/*
+lookupCommand() recognizes 'lookup' in the positional arguments, followed by the original
+name to look for and the destination directory to look in.
+*/
+func lookupCommand(args []string) (string, string, bool) {
+	if len(args) != 3 || args[0] != "lookup" {
+		return "", "", false
+	}
+	return args[1], args[2], true
+}
+
+/*
+runLookup() finds the files in destRootDir whose original names match pattern, writing
+them to out. Returns the exit status for the process, which is 1 if nothing was found.
+*/
+func runLookup(cfg *config.Config, out io.Writer, pattern string, destRootDir string) int {
+	if status := loadCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	return lookupOriginalNames(cfg, out, pattern, destRootDir)
+}
+
+/*
+lookupOriginalNames() writes out each file found for pattern, along with the path it was
+filed from. Files that have been moved or removed since they were filed are marked as
+missing.
+*/
+func lookupOriginalNames(cfg *config.Config, out io.Writer, pattern string, destRootDir string) int {
+	f, err := filer.New(filerbridge.Wrap(cfg), filer.Options{Destination: destRootDir})
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	found, err := f.FindOriginalNames(pattern)
+	for _, e := range config.Errors(err) {
+		fmt.Fprintf(out, "warning: %s\n", e)
+	}
+
+	for _, original := range found {
+		missing := ""
+		if _, err := os.Stat(original.Path); err != nil {
+			missing = " (missing)"
+		}
+		fmt.Fprintf(out, "%s <- %s%s\n", original.Path, original.SourcePath, missing)
+	}
+
+	if len(found) == 0 {
+		fmt.Fprintf(out, "no filed files were found with an original name matching '%s'\n", pattern)
+		return 1
+	}
+	return 0
+}
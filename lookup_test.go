@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_lookupCommand(t *testing.T) {
+	tests := []struct {
+		args        []string
+		wantPattern string
+		wantDest    string
+		wantOk      bool
+	}{
+		{[]string{"lookup", "IMG_1234.JPG", "/dest"}, "IMG_1234.JPG", "/dest", true},
+		{[]string{"lookup", "IMG_1234.JPG"}, "", "", false},
+		{[]string{"lookup", "IMG_1234.JPG", "/dest", "extra"}, "", "", false},
+		{[]string{"/src", "IMG_1234.JPG", "/dest"}, "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			pattern, dest, ok := lookupCommand(tt.args)
+			if pattern != tt.wantPattern || dest != tt.wantDest || ok != tt.wantOk {
+				t.Errorf("lookupCommand() = '%s', '%s', %v, want '%s', '%s', %v", pattern, dest, ok, tt.wantPattern, tt.wantDest, tt.wantOk)
+			}
+		})
+	}
+}
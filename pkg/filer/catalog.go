@@ -0,0 +1,100 @@
+package filer
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/catalog"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+catalogPath returns the path of the catalog filed files are added to. Empty if the
+'catalog' setting isn't set.
+*/
+func (f *Filer) catalogPath() string {
+	return catalog.Path(f.cfg.GetString("catalog"), f.destRootDir)
+}
+
+/*
+planCatalogEntry notes what the catalog holds about a file that's planned to be filed,
+from its metadata. The rest is filled in once the file is in place.
+*/
+func (f *Filer) planCatalogEntry(plan *Plan, meta gjson.Result, planned PlannedFile) {
+	if planned.Action != ACTION_FILE || f.catalogPath() == "" {
+		return
+	}
+
+	entry := catalog.Entry{
+		OriginalName: filepath.Base(planned.Source),
+		SourcePath:   filepath.ToSlash(relativeSourcePath(plan.sources, planned.Source)),
+		Taken:        planned.Timestamp,
+		TimestampTag: planned.TimestampTag,
+		MIMEType:     meta.Get("MIMEType").String(),
+		Model:        metadata.Model(meta),
+		CameraSerial: metadata.CameraSerial(meta),
+		LensSerial:   meta.Get("LensSerialNumber").String(),
+	}
+	entry.Latitude, entry.Longitude, entry.HasPosition = filePosition(meta)
+
+	if plan.catalogEntries == nil {
+		plan.catalogEntries = make(map[string]catalog.Entry)
+	}
+	plan.catalogEntries[planned.Source] = entry
+}
+
+/*
+openCatalog opens the catalog for a plan's files to be added to. Files are still filed if
+it can't be opened, they just aren't catalogued.
+*/
+func (f *Filer) openCatalog() *catalog.Catalog {
+	path := f.catalogPath()
+	if path == "" {
+		return nil
+	}
+
+	cat, err := catalog.Open(path)
+	if err != nil {
+		f.log.WithFields(logrus.Fields{"verb": "catalog:"}).Warnf("couldn't open the catalog, so files won't be added to it. %s", err)
+		return nil
+	}
+	return cat
+}
+
+/*
+catalogFile adds a file that was filed to the catalog, hashing it where it ended up so the
+hash matches the file in the library.
+*/
+func (f *Filer) catalogFile(cat *catalog.Catalog, plan *Plan, result Result) error {
+	if result.Outcome != OUTCOME_MOVED || result.Action != ACTION_FILE {
+		return nil
+	}
+
+	entry, ok := plan.catalogEntries[result.Source]
+	if !ok {
+		return nil
+	}
+
+	info, err := os.Stat(result.Destination)
+	if err != nil {
+		return err
+	}
+
+	hashes := f.fileHashes()
+	sum, err := hashes.Sum(result.Destination, info)
+	if err != nil {
+		return err
+	}
+
+	entry.Path = result.Destination
+	entry.Size = info.Size()
+	entry.Hash = hex.EncodeToString(sum)
+	entry.HashAlgorithm = string(hashes.Algorithm)
+	entry.FiledAt = time.Now()
+
+	return cat.Put(entry)
+}
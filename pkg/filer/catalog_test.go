@@ -0,0 +1,83 @@
+package filer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/catalog"
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Test_catalogFile makes sure filed files are added to the catalog with what was known about
+them when they were planned, and that dry runs leave it alone.
+*/
+func Test_catalogFile(t *testing.T) {
+	for _, dryrun := range []bool{false, true} {
+		f := testFiler(t)
+		var err error
+		if f.cfg.MediaTypes, err = config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate); err != nil {
+			t.Fatal(err)
+		}
+		f.cfg.Set("catalog", "catalog.db")
+		f.cfg.Set("dry-run", dryrun)
+
+		workDir := t.TempDir()
+		sourceFile := filepath.Join(workDir, "DCIM", "IMG_1234.JPG")
+		if err := os.MkdirAll(filepath.Dir(sourceFile), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(sourceFile, []byte("image"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		meta := gjson.Parse(`{"SourceFile": "` + sourceFile + `", "FileTypeExtension": "JPG", "MIMEType": "image/jpeg",
+			"CreateDate": 1718456645000, "Model": "Canon EOS Rebel T7i", "SerialNumber": " 012345 ",
+			"GPSLatitude": "+48.856600", "GPSLongitude": "+2.352200"}`)
+
+		plan := &Plan{sources: []string{workDir}}
+		planned, err := f.planFile(logrus.NewEntry(logrus.New()), meta, plan, map[string]string{}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Files = append(plan.Files, planned)
+
+		if _, err := f.Execute(context.Background(), plan); err != nil {
+			t.Fatal(err)
+		}
+
+		path := filepath.Join(f.destRootDir, "catalog.db")
+		if dryrun {
+			if _, err := os.Stat(path); err == nil {
+				t.Error("a dry run created the catalog")
+			}
+			continue
+		}
+
+		cat, err := catalog.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := cat.Find(catalog.Query{TimestampTag: "CreateDate"})
+		cat.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 {
+			t.Fatalf("catalog holds %+v, want the filed file", entries)
+		}
+		entry := entries[0]
+		if entry.Path != planned.Destination || entry.OriginalName != "IMG_1234.JPG" || entry.SourcePath != "DCIM/IMG_1234.JPG" ||
+			entry.Size != 5 || entry.HashAlgorithm != "sha256" || len(entry.Hash) != 64 || entry.Taken.UnixMilli() != 1718456645000 ||
+			entry.MIMEType != "image/jpeg" || entry.Model != "Canon EOS Rebel T7i" || entry.CameraSerial != "012345" ||
+			!entry.HasPosition || entry.Latitude != 48.8566 || entry.Longitude != 2.3522 {
+			t.Errorf("catalog entry = %+v, doesn't match the filed file", entry)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package filer
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/geocode"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+eventFile is a file being grouped into an event by the time, and possibly place, it was
+taken.
+*/
+type eventFile struct {
+	source   string
+	taken    time.Time
+	lat, lon float64
+	hasGPS   bool
+}
+
+/*
+usesEvents reports whether any template references the '{{.Event}}' field.
+*/
+func (f *Filer) usesEvents() bool {
+	if f.cfg.FilenameTemplate != nil && f.cfg.FilenameTemplate.Uses("Event") {
+		return true
+	}
+
+	for _, mediaType := range f.cfg.MediaTypes {
+		if mediaType.Uses("Event") {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+planEvents works out the event each file in the exiftool output belongs to, keyed by
+source file. Files in a directory with an event marker file belong to the event it
+names. The rest are grouped across every camera in the run, if 'event-gap' is set.
+Files that would be skipped or not filed by their metadata aren't grouped, so they can't
+join two events together.
+*/
+func (f *Filer) planEvents(planLog *logrus.Entry, files []gjson.Result, plan *Plan) map[string]string {
+	events := make(map[string]string)
+	markers := make(map[string]string)
+	var grouped []eventFile
+
+	for _, meta := range files {
+		sourceFile := meta.Get("SourceFile").String()
+		if !f.isEventCandidate(meta, sourceFile, plan) {
+			continue
+		}
+
+		if name := f.eventMarker(filepath.Dir(sourceFile), plan.sources, markers); name != "" {
+			events[sourceFile] = name
+			continue
+		}
+
+		if !f.cfg.Events.Enabled() {
+			continue
+		}
+
+		taken, _, ok := metadata.Timestamp(meta)
+		if !ok {
+			continue
+		}
+
+		file := eventFile{source: sourceFile, taken: taken}
+		file.lat, file.lon, file.hasGPS = filePosition(meta)
+		grouped = append(grouped, file)
+	}
+
+	for sourceFile, name := range groupEvents(grouped, f.cfg.Events) {
+		events[sourceFile] = name
+	}
+
+	planLog.Infof("grouped %d files into events", len(events))
+	return events
+}
+
+/*
+isEventCandidate reports whether a file could be filed by its metadata, and so should be
+grouped into an event.
+*/
+func (f *Filer) isEventCandidate(meta gjson.Result, sourceFile string, plan *Plan) bool {
+	if filepath.Base(sourceFile) == f.cfg.Events.MarkerFile {
+		return false
+	}
+
+	if ignore, err := f.isPathIgnored(sourceFile, plan.ignores); err != nil || ignore {
+		return false
+	}
+
+	info, err := os.Stat(sourceFile)
+	if err != nil {
+		return false
+	}
+	if skip, _ := f.cfg.FileFilters.Skip(metadata.FilterFile(meta, sourceFile, info.Size())); skip {
+		return false
+	}
+
+	mimeType, mimeSubType, err := splitMIMEType(meta)
+	if err != nil {
+		return false
+	}
+	_, ok := f.cfg.MediaTypes.Match(mimeType, mimeSubType)
+	return ok
+}
+
+/*
+groupEvents puts files into events by the time they were taken. A file starts a new
+event if it was taken more than the gap after the file before it, or if it's further
+than the distance from the last position seen in the current event. Events are named
+by the UTC date of their first file, numbered from 1 on each date, e.g.
+'2024-06-15_event-01'. files is sorted in place.
+*/
+func groupEvents(files []eventFile, rules config.EventRules) map[string]string {
+	sort.Slice(files, func(a int, b int) bool {
+		if !files[a].taken.Equal(files[b].taken) {
+			return files[a].taken.Before(files[b].taken)
+		}
+		return files[a].source < files[b].source
+	})
+
+	names := make(map[string]string)
+	perDate := make(map[string]int)
+	var name string
+	var lastLat, lastLon float64
+	var lastGPS bool
+
+	for idx, file := range files {
+		newEvent := idx == 0 || file.taken.Sub(files[idx-1].taken) > rules.Gap
+		if !newEvent && rules.Distance > 0 && file.hasGPS && lastGPS && geocode.Distance(lastLat, lastLon, file.lat, file.lon) > rules.Distance {
+			newEvent = true
+		}
+
+		if newEvent {
+			date := file.taken.UTC().Format("2006-01-02")
+			perDate[date]++
+			name = fmt.Sprintf("%s_event-%02d", date, perDate[date])
+			lastGPS = false
+		}
+
+		if file.hasGPS {
+			lastLat, lastLon, lastGPS = file.lat, file.lon, true
+		}
+		names[file.source] = name
+	}
+
+	return names
+}
+
+/*
+eventMarker returns the event named by the marker file closest to dir, looking in dir and
+then its parents up to the source directory it's in. A marker's first non-empty line is
+the event's name, and an empty marker uses the name of the directory it's in. Empty if
+there's no marker. Results are cached by directory in found.
+*/
+func (f *Filer) eventMarker(dir string, sources []string, found map[string]string) string {
+	if name, ok := found[dir]; ok {
+		return name
+	}
+
+	name := ""
+	if contents, err := os.ReadFile(filepath.Join(dir, f.cfg.Events.MarkerFile)); err == nil {
+		name = markerName(contents, dir)
+		name, _ = f.specialReplacer.Replace(name)
+	} else if parent := filepath.Dir(dir); parent != dir && withinSources(parent, sources) {
+		name = f.eventMarker(parent, sources, found)
+	}
+
+	found[dir] = name
+	return name
+}
+
+func markerName(contents []byte, dir string) string {
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			return line
+		}
+	}
+	return filepath.Base(dir)
+}
+
+/*
+withinSources reports whether dir is one of the source directories, or inside one.
+*/
+func withinSources(dir string, sources []string) bool {
+	for _, source := range sources {
+		if rel, err := filepath.Rel(source, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
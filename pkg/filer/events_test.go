@@ -0,0 +1,116 @@
+package filer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+)
+
+/*
+Test_groupEvents makes sure files are split into events by time gaps and, when a distance
+is set, by how far apart they were taken.
+*/
+func Test_groupEvents(t *testing.T) {
+	at := func(clock string) time.Time {
+		taken, err := time.Parse(time.RFC3339, "2024-06-15T"+clock+"Z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return taken
+	}
+
+	// a morning in Paris, a train to London, and an evening there
+	files := []eventFile{
+		{source: "c.jpg", taken: at("10:30:00"), lat: 48.8606, lon: 2.3376, hasGPS: true},
+		{source: "a.jpg", taken: at("09:00:00"), lat: 48.8584, lon: 2.2945, hasGPS: true},
+		{source: "b.jpg", taken: at("09:45:00")},
+		{source: "d.jpg", taken: at("12:00:00"), lat: 51.5033, lon: -0.1196, hasGPS: true},
+		{source: "e.jpg", taken: at("17:00:00"), lat: 51.5081, lon: -0.0759, hasGPS: true},
+		{source: "f.jpg", taken: at("23:30:00")},
+	}
+
+	tests := []struct {
+		name  string
+		rules config.EventRules
+		want  map[string]string
+	}{
+		{"time only", config.EventRules{Gap: 2 * time.Hour}, map[string]string{
+			"a.jpg": "2024-06-15_event-01", "b.jpg": "2024-06-15_event-01", "c.jpg": "2024-06-15_event-01", "d.jpg": "2024-06-15_event-01",
+			"e.jpg": "2024-06-15_event-02", "f.jpg": "2024-06-15_event-03",
+		}},
+		{"time and distance", config.EventRules{Gap: 2 * time.Hour, Distance: 50}, map[string]string{
+			"a.jpg": "2024-06-15_event-01", "b.jpg": "2024-06-15_event-01", "c.jpg": "2024-06-15_event-01", "d.jpg": "2024-06-15_event-02",
+			"e.jpg": "2024-06-15_event-03", "f.jpg": "2024-06-15_event-04",
+		}},
+		{"long gap", config.EventRules{Gap: 24 * time.Hour, Distance: 50}, map[string]string{
+			"a.jpg": "2024-06-15_event-01", "b.jpg": "2024-06-15_event-01", "c.jpg": "2024-06-15_event-01", "d.jpg": "2024-06-15_event-02",
+			"e.jpg": "2024-06-15_event-02", "f.jpg": "2024-06-15_event-02",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := groupEvents(append([]eventFile(nil), files...), tt.rules)
+			for source, want := range tt.want {
+				if got[source] != want {
+					t.Errorf("%s is in event '%s', want '%s'", source, got[source], want)
+				}
+			}
+		})
+	}
+}
+
+/*
+Test_eventMarker makes sure marker files name the events of files below them, up to the
+source directory.
+*/
+func Test_eventMarker(t *testing.T) {
+	f := testFiler(t)
+	f.cfg.Events.MarkerFile = config.DEFAULT_EVENT_MARKER_FILE
+
+	root := t.TempDir()
+	source := filepath.Join(root, "import")
+	dirs := map[string]string{
+		"wedding":           "Sam & Alex's wedding\n",
+		"wedding/ceremony":  "",
+		"birthday":          "\n  \n",
+		"phone/2024/screen": "-",
+	}
+	for dir, marker := range dirs {
+		if err := os.MkdirAll(filepath.Join(source, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if marker == "-" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(source, dir, config.DEFAULT_EVENT_MARKER_FILE), []byte(marker), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a marker above the source directory doesn't count
+	if err := os.WriteFile(filepath.Join(root, config.DEFAULT_EVENT_MARKER_FILE), []byte("everything"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir  string
+		want string
+	}{
+		{"wedding", "Sam & Alex's wedding"},
+		{"wedding/ceremony", "ceremony"},
+		{"birthday", "birthday"},
+		{"phone/2024/screen", ""},
+		{".", ""},
+	}
+
+	found := make(map[string]string)
+	for _, tt := range tests {
+		if got := f.eventMarker(filepath.Join(source, tt.dir), []string{source}, found); got != tt.want {
+			t.Errorf("eventMarker(%s) = '%s', want '%s'", tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,783 @@
+/*
+Package filer files photos and videos into a destination directory, named and sorted by
+the metadata exiftool reads from them. Filing happens in two steps: Plan() works out what
+would happen to each file without changing anything, and Execute() carries out a plan.
+*/
+package filer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/filehash"
+	"github.com/d0ct0rvenkman/mediafiler/internal/fileops"
+	"github.com/d0ct0rvenkman/mediafiler/internal/filerbridge"
+	"github.com/d0ct0rvenkman/mediafiler/internal/lockfile"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/paths"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	dirSep string = "/" // TODO: find a way to determine this programmatically
+)
+
+/*
+Config is mediafiler's configuration, as loaded by LoadConfig(). Settings from the
+configuration file can be overridden with Set(), e.g. cfg.Set("dry-run", true).
+*/
+type Config = filerbridge.Config
+
+/*
+PathError reports a problem with a path, and the errors it wraps can be checked for with
+errors.Is(), e.g. errors.Is(result.Err, filer.ErrPathExists).
+*/
+type PathError = paths.PathError
+
+var (
+	ErrPermission         = paths.ErrPermission
+	ErrNotExist           = paths.ErrNotExist
+	ErrPathExists         = paths.ErrPathExists
+	ErrNotDirectory       = paths.ErrNotDirectory
+	ErrNotFileOrDirectory = paths.ErrNotFileOrDirectory
+)
+
+/*
+LoadConfig reads and processes the configuration in configFile. If configFile is empty,
+the configuration is looked for in the default paths, falling back to the default
+configuration if none is found.
+*/
+func LoadConfig(configFile string) (*Config, error) {
+	cfg, err := config.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if configFile != "" {
+		cfg.FS.Set("config-file", configFile)
+	} else {
+		cfg.UseDefaultConfigPaths()
+		cfg.FS.Set("use-default-config", "true")
+	}
+
+	if loaded, err := cfg.ReadConfiguration(); !loaded {
+		return nil, fmt.Errorf("configuration could not be loaded. reason: %s", err)
+	}
+
+	if err := cfg.ProcessConfiguration(); err != nil {
+		return nil, err
+	}
+
+	return filerbridge.Wrap(cfg), nil
+}
+
+/*
+Options holds what a Filer needs beyond its configuration.
+
+  - ExiftoolBinary: the path to exiftool. it's looked for in PATH if empty
+  - Destination: the directory files are filed into
+  - Logger: where progress is logged. a Logger that discards everything is used if nil
+  - Hooks: functions called as files are planned and filed
+*/
+type Options struct {
+	ExiftoolBinary string
+	Destination    string
+	Logger         *logrus.Logger
+	Hooks          Hooks
+}
+
+/*
+Hooks are called as each file is handled, so callers can follow a Filer's progress.
+index counts from 0 up to count-1. Hooks are called on the goroutine running Plan() or
+Execute(), and either can be left nil.
+*/
+type Hooks struct {
+	Planned  func(index int, count int, file PlannedFile)
+	Executed func(index int, count int, result Result)
+}
+
+/*
+Filer files media from source paths into a destination directory, following the rules
+in the configuration it was given. A Filer shouldn't be used for more than one Plan()
+or Execute() at a time.
+*/
+type Filer struct {
+	cfg                  *config.Config
+	log                  *logrus.Logger
+	exiftoolbin          string
+	destRootDir          string
+	hooks                Hooks
+	specialReplacer      strmanip.Replacer
+	unknownCameraSerials map[string]bool
+	events               map[string]string // the event each source file in the current plan belongs to
+	hashes               *filehash.Cache   // hashes of files compared while looking for duplicates in the current plan
+	reload               chan struct{}
+	running              atomic.Bool // set while planning or executing, when reloads are put off
+	locked               bool
+}
+
+/*
+New returns a Filer using cfg, which must already have been read and processed.
+*/
+func New(cfg *Config, opts Options) (*Filer, error) {
+	if filerbridge.Unwrap(cfg) == nil {
+		return nil, errors.New("a configuration is required")
+	}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = logrus.New()
+		logger.SetOutput(io.Discard)
+	}
+
+	if opts.Destination == "" {
+		return nil, errors.New("a destination directory is required")
+	}
+
+	return &Filer{
+		cfg:                  filerbridge.Unwrap(cfg),
+		log:                  logger,
+		exiftoolbin:          opts.ExiftoolBinary,
+		destRootDir:          opts.Destination,
+		hooks:                opts.Hooks,
+		specialReplacer:      strmanip.NewSpecialReplacer(),
+		unknownCameraSerials: make(map[string]bool),
+		reload:               make(chan struct{}, 1),
+	}, nil
+}
+
+/*
+RequestReload asks the Filer to read its configuration again. It's safe to call from
+any goroutine. The new configuration is picked up before the next Run() or Plan() starts,
+so a plan is never made or carried out with a mix of two configurations.
+*/
+func (f *Filer) RequestReload() {
+	select {
+	case f.reload <- struct{}{}:
+	default:
+	}
+
+	if f.running.Load() {
+		f.log.WithFields(logrus.Fields{"verb": "reload:"}).Info("files are being filed, so the configuration will be reloaded before the next run")
+	}
+}
+
+/*
+begin marks the start of a run, returning the function that marks its end. If reload is
+set, a reload requested since the last run is applied first. Nothing is done for calls
+made during a run, like Run() planning and executing.
+*/
+func (f *Filer) begin(reload bool) func() {
+	if f.running.Load() {
+		return func() {}
+	}
+
+	if reload {
+		select {
+		case <-f.reload:
+			f.reloadConfiguration()
+		default:
+		}
+	}
+
+	f.running.Store(true)
+	return func() { f.running.Store(false) }
+}
+
+/*
+Run plans how to file everything in sources and carries the plan out, holding the lock
+on the destination directory for the whole run. In dry-run mode nothing is locked or
+moved. Problems with individual files are logged and reported in the results, while
+problems that keep the run from going ahead at all are returned.
+*/
+func (f *Filer) Run(ctx context.Context, sources []string) ([]Result, error) {
+	defer f.begin(true)()
+
+	// keep other mediafiler processes from filing into the same destination while we do.
+	// dry runs don't change anything, so they don't need to hold the lock.
+	if !f.cfg.GetBool("dry-run") {
+		lock, err := f.acquireLock()
+		if err != nil {
+			return nil, err
+		}
+		defer f.releaseLock(lock)
+	}
+
+	plan, err := f.Plan(ctx, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Execute(ctx, plan)
+}
+
+/*
+acquireLock takes the lock on the destination directory for the Filer, and on each
+directory outside of it that files can be moved to.
+*/
+func (f *Filer) acquireLock() ([]*lockfile.Lock, error) {
+	lockLog := f.log.WithFields(logrus.Fields{"verb": "startup:"})
+
+	var locks []*lockfile.Lock
+	destRootDir := filepath.Clean(f.destRootDir)
+	for _, root := range f.lockRoots() {
+		if root != destRootDir {
+			// the directory has to be there to hold the lock file
+			if err := os.MkdirAll(root, 0755); err != nil {
+				f.releaseLock(locks)
+				return nil, fmt.Errorf("could not create '%s' to lock it. %s", root, err)
+			}
+		}
+
+		lock, err := acquireLock(root, f.cfg.GetBool("wait"), lockLog)
+		if err != nil {
+			f.releaseLock(locks)
+			if root == destRootDir {
+				return nil, fmt.Errorf("could not lock destination directory. %s", err)
+			}
+			return nil, fmt.Errorf("could not lock '%s'. %s", root, err)
+		}
+		locks = append(locks, lock)
+	}
+
+	f.locked = true
+	return locks, nil
+}
+
+func (f *Filer) releaseLock(locks []*lockfile.Lock) {
+	for _, lock := range locks {
+		lock.Release()
+	}
+	f.locked = false
+}
+
+/*
+lockRoots returns the directories to lock while filing: the destination directory, and
+the destination roots of media types, 'unsorted-dir', 'unfiled-dir' and the near-duplicate
+review directory that are outside of it. They're sorted, so processes waiting for each
+other's locks take them in the same order.
+*/
+func (f *Filer) lockRoots() []string {
+	candidates := f.destinationRoots()
+	candidates = append(candidates, f.groupDir("unsorted-dir"), f.groupDir("unfiled-dir"))
+	if f.cfg.NearDuplicates.Enabled() {
+		candidates = append(candidates, f.reviewDir())
+	}
+	for idx := range candidates {
+		if candidates[idx] != "" {
+			candidates[idx] = filepath.Clean(candidates[idx])
+		}
+	}
+	slices.Sort(candidates)
+
+	destRootDir := filepath.Clean(f.destRootDir)
+
+	var roots []string
+	for _, dir := range candidates {
+		// directories inside the destination are covered by its lock
+		if dir == "" || fileops.IsBelow(destRootDir, dir) || slices.Contains(roots, dir) {
+			continue
+		}
+		roots = append(roots, dir)
+	}
+	return roots
+}
+
+func init() {
+	filerbridge.Destination = func(cfg *Config, destRootDir string, logger *logrus.Logger, meta gjson.Result) (string, error) {
+		f, err := New(cfg, Options{Destination: destRootDir, Logger: logger})
+		if err != nil {
+			return "", err
+		}
+		return f.destination(meta)
+	}
+}
+
+/*
+destination returns the path a file described by exiftool metadata would be filed to,
+ignoring any file already at that path. Files that can't be filed because of their
+metadata return a *SkipError.
+*/
+func (f *Filer) destination(meta gjson.Result) (string, error) {
+	newPathSuffix, newFileName, fileExtension, err := f.generateFilenameBase(meta, f.cfg.MediaTypes, f.cfg.ModelReplacer, f.specialReplacer,
+		f.cfg.Cameras, f.cfg.FilenameTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	mimeType, mimeSubType, _ := splitMIMEType(meta)
+	mediaType, _ := f.cfg.MediaTypes.Match(mimeType, mimeSubType)
+
+	return destFilePath(filepath.Join(mediaType.DestinationRoot(f.destRootDir), newPathSuffix), newFileName, 0, fileExtension), nil
+}
+
+/*
+reloadConfiguration reads the configuration again once a reload has been requested. A
+configuration with problems is rejected, and the one already in use is kept.
+*/
+func (f *Filer) reloadConfiguration() {
+	reloadLog := f.log.WithFields(logrus.Fields{"verb": "reload:"})
+
+	if err := f.cfg.Reload(); err != nil {
+		for _, e := range config.Errors(err) {
+			reloadLog.Error(e)
+		}
+		reloadLog.Warn("the new configuration was rejected. keeping the configuration already in use")
+		return
+	}
+
+	reloadLog.Infof("configuration reloaded from: %s", strings.Join(f.cfg.LayerNames(), ", "))
+}
+
+/*
+cleanupEmptyDirs removes the directories below workDir that were left empty (or only holding
+junk files) once the files in moved were moved out of them. Ignored directories are left alone.
+*/
+func (f *Filer) cleanupEmptyDirs(workDir string, moved []string, ignores sourceIgnores, dryrun bool) {
+	cleanupLog := f.log.WithFields(logrus.Fields{"verb": "cleanup:"})
+
+	if info, err := os.Stat(workDir); err != nil || !info.IsDir() {
+		cleanupLog.Debugf("working directory '%s' is not a directory. nothing to clean up", workDir)
+		return
+	}
+
+	junkFiles := fileops.DefaultJunkFiles
+	if f.cfg.IsSet("junk-files") {
+		junkFiles = f.cfg.GetStringSlice("junk-files")
+	}
+
+	skipDir := func(path string) bool {
+		// directory patterns like '.git/' expect the trailing separator
+		ignore, err := f.isPathIgnored(path+dirSep, ignores)
+		if err != nil {
+			cleanupLog.Errorf("Path Ignore Filter execution failed: reason ('%s')", err)
+			return true
+		}
+		return ignore
+	}
+
+	removed, err := fileops.RemoveEmptyDirs(workDir, moved, junkFiles, skipDir, dryrun)
+	for _, dir := range removed {
+		if dryrun {
+			cleanupLog.WithFields(logrus.Fields{"verb": "dry-run:"}).Infof("would remove empty directory %s", dir)
+		} else {
+			cleanupLog.Infof("removed empty directory %s", dir)
+		}
+	}
+
+	if err != nil {
+		cleanupLog.Warnf("some directories could not be cleaned up. %s", err)
+	}
+}
+
+/*
+findDestFile works out a destination path in destDir for sourceFile that isn't already taken, appending a
+numeric suffix to fileBase if needed. Paths in claimed have been picked for other files that haven't been
+moved yet, and are treated as if those files were already there. If the file is already present at one of
+the candidate paths (as the same file or as a duplicate, by its hashes), a *SkipError says why.
+*/
+func findDestFile(fileLogger *logrus.Entry, hashes *filehash.Cache, sourceFile string, sourceFileInfo os.FileInfo, claimed map[string]string,
+	destDir string, fileBase string, fileExtension string) (string, error) {
+
+	// the file at a candidate path, which is the file it was claimed for if it hasn't been moved yet
+	checkPath := func(destFile string) (bool, string, os.FileInfo, error) {
+		if claimedBy, ok := claimed[destFile]; ok {
+			info, err := os.Stat(claimedBy)
+			if err != nil {
+				return false, claimedBy, nil, &paths.PathError{Path: claimedBy, Err: err}
+			}
+			return false, claimedBy, info, &paths.PathError{Path: claimedBy, Kind: paths.ErrPathExists}
+		}
+
+		available, info, err := paths.IsPathAvailable(destFile)
+		return available, destFile, info, err
+	}
+
+	suffixIndex := 0
+	destFile := destFilePath(destDir, fileBase, suffixIndex, fileExtension)
+	pathAvailable, existingFile, pathInfo, pathErr := checkPath(destFile)
+	if !pathAvailable {
+		fileLogger.Debug("initial destFile isn't available")
+	}
+
+	for !pathAvailable && (suffixIndex < 1000) {
+		testLogger := fileLogger.WithFields(logrus.Fields{
+			"destFile":    destFile,
+			"suffixIndex": suffixIndex,
+			"verb":        "    ",
+		})
+
+		// path isn't available, lets figure out if we should try again with an updated suffix
+		switch {
+		case errors.Is(pathErr, paths.ErrPathExists):
+			// see if the file is a duplicate. if not, try a new path.
+
+			if os.SameFile(sourceFileInfo, pathInfo) {
+				testLogger.WithFields(logrus.Fields{"verb": "skip:"}).Warn("the OS says that sourceFile and destFile are the same file")
+				skipErr := newSkipError(SKIP_SAME_FILE, "the OS says that sourceFile and destFile are the same file")
+				skipErr.Path = existingFile
+				return "", skipErr
+			}
+
+			// the cache only reads as much of each file as it needs to, and each file only once per run
+			same, comparison, err := hashes.Compare(sourceFile, sourceFileInfo, existingFile, pathInfo)
+
+			var hashErr *filehash.FileError
+			switch {
+			case errors.As(err, &hashErr) && hashErr.Path == sourceFile:
+				fileLogger.WithFields(logrus.Fields{"verb": "skip:"}).Error("couldn't checksum the source file.")
+				return "", &SkipError{Reason: SKIP_CHECKSUM_FAILED, Path: sourceFile, Err: hashErr.Err, msg: "couldn't checksum the source file"}
+			case err != nil:
+				testLogger.Warn("couldn't checksum the File at destFile. try another destFile")
+			case same:
+				if comparison == filehash.COMPARED_PAYLOAD {
+					testLogger.WithFields(logrus.Fields{"verb": "duplicate:"}).Infof("sourceFile and destFile have the same %s sums of their image or video data", hashes.Algorithm)
+				} else {
+					testLogger.WithFields(logrus.Fields{"verb": "duplicate:"}).Infof("sourceFile and destFile have the same size and %s sums", hashes.Algorithm)
+				}
+				skipErr := newSkipError(SKIP_DUPLICATE, "sourceFile is a duplicate of '%s'", existingFile)
+				skipErr.Path = existingFile
+				return "", skipErr
+			default:
+				testLogger.Debug("doesn't look like a duplicate. try another destFile")
+			}
+
+		case errors.Is(pathErr, paths.ErrPermission):
+			testLogger.Error("permission was denied while testing if path was available")
+		default:
+			testLogger.Errorf("got an unknown error from IsPathAvailable(). %s", pathErr)
+		}
+
+		suffixIndex++
+		destFile = destFilePath(destDir, fileBase, suffixIndex, fileExtension)
+		pathAvailable, existingFile, pathInfo, pathErr = checkPath(destFile)
+	}
+
+	if !pathAvailable {
+		fileLogger.WithFields(logrus.Fields{"verb": "skip:"}).Errorf("could not find an available destination path in %s", destDir)
+		return "", newSkipError(SKIP_NO_DESTINATION, "could not find an available destination path in %s", destDir)
+	}
+
+	return destFile, nil
+}
+
+/*
+destFilePath builds the path for a destination file, with the numeric suffix used to avoid collisions.
+*/
+func destFilePath(destDir string, fileBase string, suffixIndex int, fileExtension string) string {
+	fileName := fileBase
+	if suffixIndex > 0 {
+		fileName = fmt.Sprintf("%s-%03d", fileName, suffixIndex)
+	}
+
+	if fileExtension != "" {
+		fileName = fileName + "." + fileExtension
+	}
+
+	return filepath.Join(destDir, fileName)
+}
+
+/*
+moveFile creates the destination directory and moves sourceFile into place. If check is
+given, a file copied across block devices is hashed on the way, so the move can be verified.
+*/
+func moveFile(fileLogger *logrus.Entry, sourceFile string, destFile string, verb string, check *moveCheck) error {
+	targetDir := filepath.Dir(destFile)
+
+	fileLogger.Debugf("creating target directory: %s", targetDir)
+	err := os.MkdirAll(targetDir, 0755)
+	if err != nil {
+		fileLogger.Errorf("could not create destination directory! reason: %s", err)
+	}
+
+	if check != nil {
+		check.copied, err = fileops.MoveHashing(sourceFile, destFile, check.hash)
+	} else {
+		err = fileops.Move(sourceFile, destFile)
+	}
+	if err != nil {
+		fileLogger.WithFields(logrus.Fields{"verb": "error:"}).Errorf("could not rename file! reason: %s", err)
+		return err
+	}
+
+	fileLogger.WithFields(logrus.Fields{"verb": verb}).Infof(">> %s", destFile)
+	return nil
+}
+
+/*
+relativeSourcePath returns the path of sourceFile relative to the source directory it was found in.
+If it doesn't belong to a source directory (e.g. the file was a source itself), just the file name is
+returned. The most specific source directory wins if sources are nested.
+*/
+func relativeSourcePath(sources []string, sourceFile string) string {
+	relPath := filepath.Base(sourceFile)
+	bestLen := -1
+
+	for _, source := range sources {
+		rel, err := filepath.Rel(source, sourceFile)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+
+		if len(source) > bestLen {
+			relPath = rel
+			bestLen = len(source)
+		}
+	}
+
+	return relPath
+}
+
+/*
+exiftoolArgFile builds the contents of an exiftool argument file (see '-@' in the exiftool docs)
+listing each argument (options and paths) on its own line. Paths that exiftool would otherwise mangle (leading or trailing
+white space, line breaks) are written as C strings.
+*/
+func exiftoolArgFile(argPaths []string) (string, error) {
+	var sb strings.Builder
+
+	cstr := strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+	for _, path := range argPaths {
+		if path == "" {
+			return "", errors.New("source paths cannot be empty")
+		}
+
+		if strings.TrimSpace(path) != path || strings.ContainsAny(path, "\r\n") || strings.HasPrefix(path, "#") {
+			sb.WriteString("#[CSTR]" + cstr.Replace(path) + "\n")
+		} else {
+			sb.WriteString(path + "\n")
+		}
+	}
+
+	return sb.String(), nil
+}
+
+/*
+splitExtension splits a file name into its base and extension (without the dot).
+*/
+func splitExtension(fileName string) (string, string) {
+	fileExtension := filepath.Ext(fileName)
+	if fileExtension == "" || fileExtension == fileName {
+		return fileName, ""
+	}
+
+	return strings.TrimSuffix(fileName, fileExtension), fileExtension[1:]
+}
+
+/*
+acquireLock takes the lock on the destination directory, waiting for it if asked to.
+Stale locks left behind by processes that didn't exit cleanly are taken over and reported.
+*/
+func acquireLock(destRootDir string, wait bool, lockLog *logrus.Entry) (*lockfile.Lock, error) {
+	lock, err := lockfile.Acquire(destRootDir, false)
+
+	var heldErr *lockfile.HeldError
+	if errors.As(err, &heldErr) && wait {
+		lockLog.Warnf("%s. waiting for it to be released", heldErr)
+		lock, err = lockfile.Acquire(destRootDir, true)
+	}
+
+	if errors.Is(err, errors.ErrUnsupported) {
+		lockLog.Warnf("destination directory will not be locked. %s", err)
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if lock.Stale != nil {
+		lockLog.Warnf("found a stale lock file left behind by %s. it has been taken over", lock.Stale)
+	}
+
+	lockLog.Debugf("acquired lock file: %s", lock.Path)
+
+	return lock, nil
+}
+
+/*
+splitMIMEType pulls the MIME type and subtype out of a file's metadata, as a SkipError
+saying why the file can't be filed if that can't be done.
+*/
+func splitMIMEType(meta gjson.Result) (string, string, error) {
+	mimeType, mimeSubType, err := metadata.SplitMIMEType(meta)
+	switch {
+	case errors.Is(err, metadata.ErrNoMIMEType):
+		return "", "", newSkipError(UNFILED_NO_MIME_TYPE, "%s", err)
+	case err != nil:
+		return "", "", newSkipError(UNFILED_BAD_MIME_TYPE, "%s", err)
+	}
+	return mimeType, mimeSubType, nil
+}
+
+/*
+reportUnknownCamera logs a camera serial number that hasn't been given a name in the
+'cameras' configuration, once per run, so it can be added.
+*/
+func (f *Filer) reportUnknownCamera(logger *logrus.Entry, cameras config.CameraAliases, serial string, model string) {
+	if f.unknownCameraSerials[serial] {
+		return
+	}
+	f.unknownCameraSerials[serial] = true
+
+	if len(cameras) == 0 {
+		logger.Debugf("camera serial number '%s' (model '%s') has no name in 'cameras'", serial, model)
+		return
+	}
+
+	logger.WithFields(logrus.Fields{"verb": "camera:"}).Infof("unknown camera serial number '%s' (model '%s'). add it to 'cameras' to give it a name", serial, model)
+}
+
+func (f *Filer) generateFilenameBase(meta gjson.Result, mediaTypes config.MediaTypeRouter, modelReplacer strmanip.Replacer, specialReplacer strmanip.Replacer,
+	cameras config.CameraAliases, filenameTemplate *nametmpl.Template) (string, string, string, error) {
+	var timeObj time.Time
+	var timeTag string
+	var timestampParsed bool
+	var serr error
+
+	gfbLogger := f.log.WithFields(logrus.Fields{
+		"sourceFile": meta.Get("SourceFile").String(),
+	})
+
+	cameraSerial := ""
+	lensSerial := ""
+	mimeType := ""
+	mimeSubType := ""
+	newPathSuffix := ""
+	fileExtension := ""
+
+	if meta.Get("FileTypeExtension").Exists() {
+		fileExtension = strings.ToLower(meta.Get("FileTypeExtension").String())
+	} else {
+		serr = newSkipError(UNFILED_NO_EXTENSION, "file metadata doesn't contain an extension")
+		return "", "", "", serr
+	}
+
+	mimeType, mimeSubType, serr = splitMIMEType(meta)
+	if serr != nil {
+		return "", "", "", serr
+	}
+
+	mediaType, ok := mediaTypes.Match(mimeType, mimeSubType)
+	if !ok {
+		serr = newSkipError(UNFILED_UNSUPPORTED, "the MIME type ('%s') for this file is not supported", mimeType)
+		return "", "", "", serr
+	}
+
+	timeObj, timeTag, timestampParsed = metadata.Timestamp(meta)
+	if timeTag == "" {
+		serr = newSkipError(UNFILED_NO_TIMESTAMP, "we did not find a timestamp")
+		return "", "", "", serr
+	}
+	if !timestampParsed {
+		gfbLogger.Warnf("'%s' (%s) could not be parsed, so the file is filed at the epoch", timeTag, meta.Get(timeTag).String())
+		timeObj = time.UnixMilli(0)
+	}
+
+	gfbLogger.Debugf("timeInput ('%d') pulled from '%s'", timeObj.UnixMilli(), timeTag)
+	if timeTag == "GPSDateTime" {
+		gfbLogger.Info("fell back to using 'GPSDateTime' for image timestamp, which is not necessarily accurate")
+	}
+
+	model := metadata.Model(meta)
+	model, _ = modelReplacer.ReplaceMatching(model, metadata.Tags(meta))
+	model, _ = specialReplacer.Replace(model)
+
+	camera := model
+	serials := metadata.CameraSerials(meta)
+
+	// a body can carry different serial numbers in different tags, so any of them can name it
+	if len(serials) > 0 {
+		if name, serial, ok := cameras.Match(serials); ok {
+			camera, cameraSerial = name, serial
+		} else {
+			cameraSerial = serials[0]
+			f.reportUnknownCamera(gfbLogger, cameras, cameraSerial, model)
+		}
+		cameraSerial, _ = specialReplacer.Replace(cameraSerial)
+	}
+
+	if meta.Get("LensSerialNumber").Exists() {
+		lensSerial = meta.Get("LensSerialNumber").String()
+		lensSerial, _ = specialReplacer.Replace(lensSerial)
+	}
+
+	gfbLogger.Debugf("model: %s", model)
+	gfbLogger.Debugf("camera: %s", camera)
+	gfbLogger.Debugf("cameraSerial: %s", cameraSerial)
+	gfbLogger.Debugf("lensSerial: %s", lensSerial)
+	gfbLogger.Debugf("timestamp: %s", timeObj.String())
+	gfbLogger.Debugf("MIME: %s / %s", mimeType, mimeSubType)
+	gfbLogger.Debugf("fileExtension: %s", fileExtension)
+
+	fields := nametmpl.Fields{
+		MIMEType:     mimeType,
+		MIMESubType:  mimeSubType,
+		Year:         fmt.Sprintf("%04d", timeObj.UTC().Year()),
+		Month:        fmt.Sprintf("%02d", timeObj.UTC().Month()),
+		Day:          fmt.Sprintf("%02d", timeObj.UTC().Day()),
+		Hour:         fmt.Sprintf("%02d", timeObj.UTC().Hour()),
+		Minute:       fmt.Sprintf("%02d", timeObj.UTC().Minute()),
+		Second:       fmt.Sprintf("%02d", timeObj.UTC().Second()),
+		Millisecond:  fmt.Sprintf("%03d", timeObj.UTC().Round(time.Microsecond).Nanosecond()/1e6),
+		Model:        model,
+		Extension:    fileExtension,
+		Camera:       camera,
+		CameraSerial: cameraSerial,
+		LensSerial:   lensSerial,
+		Event:        f.events[meta.Get("SourceFile").String()],
+	}
+
+	if filenameTemplate == nil {
+		filenameTemplate, _ = nametmpl.Parse(nametmpl.DefaultFilenameTemplate)
+	}
+
+	// reverse geocoding is only worth doing if a template needs it
+	if mediaType.Uses(nametmpl.LocationFields...) || filenameTemplate.Uses(nametmpl.LocationFields...) {
+		f.fillLocation(gfbLogger, meta, specialReplacer, &fields)
+	}
+
+	newPathSuffix, serr = mediaType.RenderPath(fields)
+	if serr != nil {
+		serr = &SkipError{Reason: SKIP_TEMPLATE_FAILED, Err: serr, msg: fmt.Sprintf("path template for MIME type '%s' could not be rendered", mediaType.MIME)}
+		return "", "", "", serr
+	}
+
+	newFileName, serr := filenameTemplate.RenderName(fields)
+	if serr != nil {
+		serr = &SkipError{Reason: SKIP_TEMPLATE_FAILED, Err: serr, msg: "filename template could not be rendered"}
+		return "", "", "", serr
+	}
+
+	return newPathSuffix, newFileName, fileExtension, nil
+}
+
+/*
+fileHashes returns the cache of hashes used to look for duplicates, starting a new one if
+the 'duplicate-hash' algorithm has changed.
+*/
+func (f *Filer) fileHashes() *filehash.Cache {
+	algorithm := f.cfg.Duplicates.Algorithm
+	if algorithm == "" {
+		algorithm = config.DEFAULT_DUPLICATE_HASH
+	}
+
+	if f.hashes == nil || f.hashes.Algorithm != algorithm {
+		f.hashes = filehash.NewCache(algorithm)
+	}
+	f.hashes.Payload = f.cfg.Duplicates.Compare == filehash.COMPARED_PAYLOAD
+	return f.hashes
+}
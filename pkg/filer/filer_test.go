@@ -0,0 +1,384 @@
+package filer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/filerbridge"
+	"github.com/d0ct0rvenkman/mediafiler/internal/lockfile"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+testFiler returns a Filer using an empty configuration, for testing the parts of filing
+that are given their rules explicitly.
+*/
+func testFiler(t *testing.T) *Filer {
+	cfg, err := config.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := New(filerbridge.Wrap(cfg), Options{ExiftoolBinary: "exiftool", Destination: t.TempDir(), Logger: logrus.New()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+/*
+Test_generateFilenameBase will load in test metadata sampled from real cameras
+to simulate various permutations of metadata presence/absence. Given that even the same
+camera can populate that metadata differently depending on environmental circumstances,
+we'll likely need a lot of test cases.
+
+The tester will load in JSON files to populate individual test cases. Each file should contain
+metadata for one or more simulated input files, along with test case data describing the test
+case name and expected results.
+
+The test will not do any replace actions on any of the file name components aside from removing
+forward and back slashes, whish shouldn't be present anyway.
+
+Check out README.md in ../../test/main/generateFilenameBase for more info on test file structure.
+*/
+func Test_generateFilenameBase(t *testing.T) {
+	casesProcessed := 0
+
+	testDataPath := "../../test/main/generateFilenameBase/"
+
+	mediaTypes, err := config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate)
+	if err != nil {
+		t.Fatalf("could not set up default media types. reason: %s", err)
+	}
+
+	var modelReplacer strmanip.Replacer
+	var spaceReplacer strmanip.Replacer
+
+	spaceReplacer.AddRule(strmanip.ReplacerRule{Type: "string", Find: `/`, ReplaceWith: "_"})
+	spaceReplacer.AddRule(strmanip.ReplacerRule{Type: "string", Find: `\`, ReplaceWith: "_"})
+
+	testFileList := make([]string, 0)
+	e := filepath.Walk(testDataPath, func(path string, f os.FileInfo, err error) error {
+		//t.Logf("checking %s\n", path)
+		if err != nil {
+			fmt.Println(err)
+			return err
+		}
+		if (path[len(path)-5:] == ".json") && (f.Mode().IsRegular()) {
+			//t.Logf("path %s is a file with the correct extension\n", path)
+			testFileList = append(testFileList, path)
+		}
+
+		return err
+	})
+
+	if e != nil {
+		t.Errorf("could not walk test data directory. reason: '%s'", e)
+	}
+
+	if len(testFileList) == 0 {
+		t.Fatal("the test won't work without test data")
+	}
+
+	for k, v := range testFileList {
+		t.Logf("processing test file '%s' (%d of %d)\n", v, k+1, len(testFileList))
+
+		fileContents, err := os.ReadFile(v)
+		if err != nil {
+			t.Errorf("could not read test data from '%s'\n", v)
+		}
+
+		if len(fileContents) == 0 {
+			t.Fatalf("test case was empty")
+		}
+
+		if !gjson.ValidBytes(fileContents) {
+			t.Fatalf("failed to unmarshal JSON from test case")
+		}
+
+		result := gjson.ParseBytes(fileContents)
+
+		fileCount := len(result.Array())
+		t.Logf("- Found %d simulated files to process", fileCount)
+
+		for casenum, testcase := range result.Array() {
+			casesProcessed++
+
+			var casename string
+			var fqcasename string
+			var exp_newPathSuffix string
+			var exp_newFileName string
+			var exp_fileExtension string
+			var exp_err string
+			var tmpjson gjson.Result
+
+			tmpjson = testcase.Get("casename")
+			if !tmpjson.Exists() {
+				t.Fatalf("test case name for simulated file %d in %s is missing", casenum, v)
+			} else {
+				casename = tmpjson.String()
+			}
+
+			if len(casename) == 0 {
+				t.Fatalf("test case name for simulated file %d in %s is empty", casenum, v)
+			}
+
+			fqcasename, _ = spaceReplacer.Replace(v[len(testDataPath):] + "-" + fmt.Sprintf("%d", casenum) + "-" + casename)
+
+			t.Run(fqcasename, func(t *testing.T) {
+
+				tmpjson = testcase.Get("expected.newPathSuffix")
+				if !tmpjson.Exists() {
+					t.Fatalf("expected newPathSuffix for simulated file %d in %s is missing", casenum, v)
+				} else {
+					exp_newPathSuffix = tmpjson.String()
+				}
+
+				tmpjson = testcase.Get("expected.newFileName")
+				if !tmpjson.Exists() {
+					t.Fatalf("expected newFileName for simulated file %d in %s is missing", casenum, v)
+				} else {
+					exp_newFileName = tmpjson.String()
+				}
+
+				tmpjson = testcase.Get("expected.fileExtension")
+				if !tmpjson.Exists() {
+					t.Fatalf("expected fileExtension for simulated file %d in %s is missing", casenum, v)
+				} else {
+					exp_fileExtension = tmpjson.String()
+				}
+
+				tmpjson = testcase.Get("expected.err")
+				if !tmpjson.Exists() {
+					t.Fatalf("expected err for simulated file %d in %s is missing", casenum, v)
+				} else {
+					exp_err = tmpjson.String()
+				}
+
+				tmpjson = testcase.Get("metadata")
+				if !tmpjson.Exists() {
+					t.Fatalf("metadata for simulated file %d in %s is missing", casenum, v)
+				}
+
+				if len(casename) == 0 {
+					t.Fatalf("test case name for simulated file %d in %s is empty", casenum, v)
+				}
+
+				newPathSuffix, newFileName, fileExtension, err := testFiler(t).generateFilenameBase(tmpjson, mediaTypes, modelReplacer, spaceReplacer, nil, nil)
+				if (err != nil) && (err.Error() != exp_err) {
+					t.Errorf("generateFilenameBase() err = %v, exp_err %v", err, exp_err)
+					return
+				}
+				if newPathSuffix != exp_newPathSuffix {
+					t.Errorf("generateFilenameBase() newPathSuffix = %v, want %v", newPathSuffix, exp_newPathSuffix)
+				}
+				if newFileName != exp_newFileName {
+					t.Errorf("generateFilenameBase() newFileName = %v, want %v", newFileName, exp_newFileName)
+				}
+				if fileExtension != exp_fileExtension {
+					t.Errorf("generateFilenameBase() fileExtension = %v, want %v", fileExtension, exp_fileExtension)
+				}
+			})
+
+		}
+
+	}
+
+	if casesProcessed == 0 {
+		t.Fatalf("the test didn't process any test cases")
+	} else {
+		t.Logf("the test processed %d simulated media files", casesProcessed)
+	}
+
+}
+
+/*
+Test_relativeSourcePath verifies that source files are made relative to the most specific
+source directory they were found in.
+*/
+func Test_relativeSourcePath(t *testing.T) {
+	sources := []string{"/media/card", "/media/card/DCIM", "/media/phone/IMG_0001.JPG", "/media/cardreader"}
+
+	tests := []struct {
+		sourceFile string
+		want       string
+	}{
+		{"/media/card/MISC/info.txt", "MISC/info.txt"},
+		{"/media/card/DCIM/100CANON/IMG_1234.JPG", "100CANON/IMG_1234.JPG"},
+		{"/media/phone/IMG_0001.JPG", "IMG_0001.JPG"},
+		{"/media/cardreader/IMG_0002.JPG", "IMG_0002.JPG"},
+		{"/elsewhere/IMG_0003.JPG", "IMG_0003.JPG"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sourceFile, func(t *testing.T) {
+			if got := relativeSourcePath(sources, tt.sourceFile); got != tt.want {
+				t.Errorf("relativeSourcePath() = '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+/*
+Test_exiftoolArgFile verifies that paths exiftool would otherwise mangle are written as C strings.
+*/
+func Test_exiftoolArgFile(t *testing.T) {
+	got, err := exiftoolArgFile([]string{"/media/card", "/media/with space/ IMG.JPG ", "/media/line\nbreak\\.jpg"})
+	if err != nil {
+		t.Fatalf("exiftoolArgFile() failed: %s", err)
+	}
+
+	want := "/media/card\n#[CSTR]/media/with space/ IMG.JPG \n#[CSTR]/media/line\\nbreak\\\\.jpg\n"
+	if got != want {
+		t.Errorf("exiftoolArgFile() = %q, want %q", got, want)
+	}
+
+	if _, err = exiftoolArgFile([]string{""}); err == nil {
+		t.Error("exiftoolArgFile() accepted an empty path")
+	}
+}
+
+/*
+Test_generateFilenameBase_Cameras makes sure camera names from the 'cameras' configuration can
+be used in file name templates, keyed on any of the serial number tags.
+*/
+func Test_generateFilenameBase_Cameras(t *testing.T) {
+	mediaTypes, err := config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate)
+	if err != nil {
+		t.Fatalf("could not set up default media types. reason: %s", err)
+	}
+
+	var cameras config.CameraAliases
+	cameras.AddAlias("012345", "Canon800D-A")
+	cameras.AddAlias("067890", "Canon800D-B")
+
+	filenameTemplate, err := nametmpl.Parse("{{.Year}}{{.Month}}{{.Day}}-{{.Camera}}{{if .LensSerial}}_LS{{.LensSerial}}{{end}}")
+	if err != nil {
+		t.Fatalf("could not parse file name template. reason: %s", err)
+	}
+
+	base := `"SourceFile": "IMG_0001.JPG", "FileTypeExtension": "JPG", "MIMEType": "image/jpeg", "DateTimeOriginal": 1718456645000, "Model": "Canon EOS Rebel T7i"`
+
+	tests := []struct {
+		name     string
+		metadata string
+		want     string
+	}{
+		{"serial number", `{` + base + `, "SerialNumber": "012345"}`, "20240615-Canon800D-A"},
+		{"internal serial number", `{` + base + `, "InternalSerialNumber": "067890", "LensSerialNumber": "0000c1"}`, "20240615-Canon800D-B_LS0000c1"},
+		{"unknown serial number", `{` + base + `, "BodySerialNumber": "099999"}`, "20240615-Canon EOS Rebel T7i"},
+		{"second serial number", `{` + base + `, "SerialNumber": "0000000000", "BodySerialNumber": "012345"}`, "20240615-Canon800D-A"},
+		{"first of two serial numbers", `{` + base + `, "SerialNumber": "067890", "InternalSerialNumber": "012345"}`, "20240615-Canon800D-B"},
+		{"no serial number", `{` + base + `}`, "20240615-Canon EOS Rebel T7i"},
+	}
+
+	f := testFiler(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, newFileName, _, err := f.generateFilenameBase(gjson.Parse(tt.metadata), mediaTypes, strmanip.Replacer{}, strmanip.Replacer{}, cameras, filenameTemplate)
+			if err != nil {
+				t.Fatalf("generateFilenameBase() failed. reason: %s", err)
+			}
+
+			if newFileName != tt.want {
+				t.Errorf("generateFilenameBase() newFileName = '%s', want '%s'", newFileName, tt.want)
+			}
+		})
+	}
+
+	if !f.unknownCameraSerials["099999"] {
+		t.Error("the unknown serial number was not reported")
+	}
+	if f.unknownCameraSerials["0000000000"] {
+		t.Error("a serial number was reported as unknown although another one of the file's was known")
+	}
+}
+
+/*
+Test_lockRoots makes sure directories files can be moved to outside of the destination
+are locked along with it, and that those inside it aren't.
+*/
+func Test_lockRoots(t *testing.T) {
+	f := testFiler(t)
+	outside := t.TempDir()
+
+	f.cfg.Set("unsorted-dir", "unsorted")
+	f.cfg.Set("unfiled-dir", filepath.Join(outside, "unfiled"))
+
+	want := []string{f.destRootDir, filepath.Join(outside, "unfiled")}
+	slices.Sort(want)
+	if got := f.lockRoots(); !slices.Equal(got, want) {
+		t.Errorf("lockRoots() = %q, want %q", got, want)
+	}
+}
+
+/*
+Test_acquireLock makes sure two filers that share an 'unfiled-dir' outside their
+destinations can't file at the same time.
+*/
+func Test_acquireLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("lock files aren't supported on windows")
+	}
+
+	unfiledDir := filepath.Join(t.TempDir(), "unfiled")
+	first, second := testFiler(t), testFiler(t)
+	first.cfg.Set("unfiled-dir", unfiledDir)
+	second.cfg.Set("unfiled-dir", unfiledDir)
+
+	locks, err := first.acquireLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(unfiledDir, lockfile.FileName)); err != nil {
+		t.Errorf("'unfiled-dir' wasn't locked. %s", err)
+	}
+
+	if _, err := second.acquireLock(); err == nil {
+		t.Error("acquireLock() succeeded while another filer held the lock on 'unfiled-dir'")
+	}
+	if _, err := os.Stat(filepath.Join(second.destRootDir, lockfile.FileName)); !os.IsNotExist(err) {
+		t.Error("the destination's lock was kept after 'unfiled-dir' couldn't be locked")
+	}
+
+	first.releaseLock(locks)
+	locks, err = second.acquireLock()
+	if err != nil {
+		t.Fatalf("acquireLock() failed once the lock was released. %s", err)
+	}
+	second.releaseLock(locks)
+}
+
+/*
+Test_RequestReload makes sure reloads requested while files are being filed are put off
+until the next run starts.
+*/
+func Test_RequestReload(t *testing.T) {
+	f := testFiler(t)
+	f.log.SetOutput(io.Discard)
+
+	done := f.begin(false)
+	f.RequestReload()
+
+	// planning and executing as part of a run don't pick the reload up either
+	f.begin(true)()
+	if len(f.reload) != 1 {
+		t.Fatal("the reload was applied during a run")
+	}
+	done()
+
+	f.begin(true)()
+	if len(f.reload) != 0 {
+		t.Error("the reload wasn't applied before the next run")
+	}
+}
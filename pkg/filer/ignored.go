@@ -0,0 +1,84 @@
+package filer
+
+import (
+	"os"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/ignore"
+	logrus "github.com/sirupsen/logrus"
+)
+
+/*
+sourceIgnores holds the ignore rules found while walking each source directory.
+*/
+type sourceIgnores []*ignore.Tree
+
+/*
+walkSources walks each source directory ahead of exiftool, picking up .mediafilerignore
+files and finding the directories that are ignored, so exiftool can be told to skip them.
+Sources that aren't directories are left out.
+*/
+func (f *Filer) walkSources(logger *logrus.Entry, sources []string) sourceIgnores {
+	var trees sourceIgnores
+
+	for _, source := range sources {
+		if info, err := os.Stat(source); err != nil || !info.IsDir() {
+			continue
+		}
+
+		tree, err := ignore.Walk(source, func(path string) bool {
+			ignored, err := f.isPathIgnored(path+dirSep, nil)
+			if err != nil {
+				logger.Errorf("Path Ignore Filter execution failed: reason ('%s')", err)
+			}
+			return ignored
+		})
+		if err != nil {
+			logger.Warnf("some ignore rules in '%s' could not be read. %s", source, err)
+		}
+
+		for _, dir := range tree.Pruned {
+			logger.Debugf("ignoring directory %s", dir)
+		}
+		logger.Infof("%d directories ignored in %s", len(tree.Pruned), source)
+
+		trees = append(trees, tree)
+	}
+
+	return trees
+}
+
+/*
+exiftoolArgs returns the exiftool options that make it skip the ignored directories.
+*/
+func (s sourceIgnores) exiftoolArgs() []string {
+	var args []string
+
+	for _, tree := range s {
+		for _, dir := range tree.Pruned {
+			args = append(args, "-i", dir)
+		}
+	}
+
+	return args
+}
+
+/*
+isPathIgnored reports whether a path matches one of the configured path ignore patterns,
+or is ignored by a .mediafilerignore file in one of the source directories. Directories
+end in a separator.
+*/
+func (f *Filer) isPathIgnored(path string, trees sourceIgnores) (bool, error) {
+	ignored, err := f.cfg.PathIgnorer.IsPathFiltered(path)
+	if err != nil || ignored {
+		return ignored, err
+	}
+
+	isDir := len(path) > 1 && path[len(path)-1:] == dirSep
+	for _, tree := range trees {
+		if tree.Ignored(path, isDir) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
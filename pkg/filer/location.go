@@ -0,0 +1,86 @@
+package filer
+
+import (
+	"github.com/d0ct0rvenkman/mediafiler/internal/geocode"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+const DEFAULT_LOCATION_UNKNOWN string = "unknown"
+
+/*
+locationUnknown returns the value used for location fields of files that have no GPS
+position, or aren't near any known city.
+*/
+func (f *Filer) locationUnknown() string {
+	if f.cfg.IsSet("location-unknown") {
+		return f.cfg.GetString("location-unknown")
+	}
+	return DEFAULT_LOCATION_UNKNOWN
+}
+
+/*
+filePosition returns a file's GPS position in decimal degrees. ok is false if the file's
+metadata doesn't have a usable position.
+*/
+func filePosition(meta gjson.Result) (lat float64, lon float64, ok bool) {
+	lat, latErr := geocode.ParseCoordinate(meta.Get("GPSLatitude").String(), meta.Get("GPSLatitudeRef").String())
+	lon, lonErr := geocode.ParseCoordinate(meta.Get("GPSLongitude").String(), meta.Get("GPSLongitudeRef").String())
+	return lat, lon, latErr == nil && lonErr == nil
+}
+
+/*
+fileLocation looks up where a file was taken from its GPS position, using the bundled
+cities dataset. ok is false if the file has no usable GPS position, or it isn't near
+any city in the dataset.
+*/
+func fileLocation(meta gjson.Result) (place geocode.Place, ok bool, err error) {
+	lat, lon, ok := filePosition(meta)
+	if !ok {
+		return geocode.Place{}, false, nil
+	}
+
+	geocoder, err := geocode.Default()
+	if err != nil {
+		return geocode.Place{}, false, err
+	}
+
+	place, ok = geocoder.Lookup(lat, lon)
+	return place, ok, nil
+}
+
+/*
+fillLocation sets the location fields used by templates, or sets them to the
+'location-unknown' value if the file's location can't be worked out.
+*/
+func (f *Filer) fillLocation(logger *logrus.Entry, meta gjson.Result, specialReplacer strmanip.Replacer, fields *nametmpl.Fields) {
+	unknown := f.locationUnknown()
+	fields.Country, fields.Region, fields.City = unknown, unknown, unknown
+
+	place, ok, err := fileLocation(meta)
+	if err != nil {
+		logger.WithFields(logrus.Fields{"verb": "location:"}).Errorf("couldn't load the cities dataset. %s", err)
+		return
+	}
+	if !ok {
+		logger.Debug("no GPS position near a known city, so the location is unknown")
+		return
+	}
+
+	for _, field := range []struct {
+		dest  *string
+		value string
+	}{
+		{&fields.Country, place.Country},
+		{&fields.Region, place.Region},
+		{&fields.City, place.City},
+	} {
+		if field.value != "" {
+			*field.dest, _ = specialReplacer.Replace(field.value)
+		}
+	}
+
+	logger.Debugf("location: %s / %s / %s", fields.Country, fields.Region, fields.City)
+}
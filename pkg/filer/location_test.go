@@ -0,0 +1,60 @@
+package filer
+
+import (
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Test_generateFilenameBase_Location makes sure location fields are filled in from GPS
+positions, and that files without a usable position get the 'location-unknown' value.
+*/
+func Test_generateFilenameBase_Location(t *testing.T) {
+	tests := []struct {
+		name     string
+		gps      string
+		unknown  string
+		wantPath string
+		wantName string
+	}{
+		{"decimal", `"GPSLatitude": "+48.856600", "GPSLongitude": "+2.352200"`, "", "France/Île-de-France/Paris", "Paris-X"},
+		{"with refs", `"GPSLatitude": 33.8679, "GPSLatitudeRef": "South", "GPSLongitude": 151.2073, "GPSLongitudeRef": "East"`, "", "Australia/New South Wales/Sydney", "Sydney-X"},
+		{"redacted", `"GPSLatitude": "redacted", "GPSLongitude": "redacted"`, "", "unknown/unknown/unknown", "unknown-X"},
+		{"missing", `"GPSAltitude": 10`, "nowhere", "nowhere/nowhere/nowhere", "nowhere-X"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testFiler(t)
+			if tt.unknown != "" {
+				f.cfg.Set("location-unknown", tt.unknown)
+			}
+
+			mediaTypes, err := config.DefaultMediaTypeRouter("{{.Country}}/{{.Region}}/{{.City}}")
+			if err != nil {
+				t.Fatal(err)
+			}
+			filenameTemplate, err := nametmpl.Parse("{{.City}}-{{.Model}}")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			meta := gjson.Parse(`{"SourceFile": "a.jpg", "FileTypeExtension": "JPG", "MIMEType": "image/jpeg",
+				"DateTimeOriginal": 1718456645000, "Model": "X", ` + tt.gps + `}`)
+
+			gotPath, gotName, _, err := f.generateFilenameBase(meta, mediaTypes, f.cfg.ModelReplacer, strmanip.NewSpecialReplacer(),
+				f.cfg.Cameras, filenameTemplate)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if gotPath != tt.wantPath || gotName != tt.wantName {
+				t.Errorf("generateFilenameBase() = '%s', '%s', want '%s', '%s'", gotPath, gotName, tt.wantPath, tt.wantName)
+			}
+		})
+	}
+}
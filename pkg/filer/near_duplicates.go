@@ -0,0 +1,221 @@
+package filer
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/phash"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+NEAR_DUPLICATE is the reason given for images moved to 'near-duplicate-dir' because they
+look the same as an image already in the destination.
+*/
+const NEAR_DUPLICATE Reason = "near-duplicate"
+
+/*
+NearDuplicate is the image a file looks the same as, and how many bits their perceptual
+hashes differ by.
+*/
+type NearDuplicate struct {
+	Path     string
+	Distance int
+}
+
+/*
+nearDuplicateIndex holds the perceptual hashes a plan compares images against.
+*/
+type nearDuplicateIndex struct {
+	path    string       // where the library index is saved
+	library *phash.Index // images already in the destination
+	planned *phash.Index // images this plan will file, by their destination
+}
+
+/*
+closest returns the image closest to hash, either in the destination or planned to be
+filed, if it's within maxDistance bits. sourceFile itself is never matched.
+*/
+func (n *nearDuplicateIndex) closest(hash uint64, maxDistance int, sourceFile string) (phash.Match, bool) {
+	match, found := n.library.Closest(hash, maxDistance, sourceFile)
+	if plannedMatch, ok := n.planned.Closest(hash, maxDistance); ok && (!found || plannedMatch.Distance < match.Distance) {
+		match, found = plannedMatch, true
+	}
+	return match, found
+}
+
+/*
+hashableMIMETypes are the types of images that perceptual hashes are computed for.
+*/
+var hashableMIMETypes = map[string]bool{"image/jpeg": true, "image/png": true}
+
+/*
+reviewDir returns the directory near-duplicates are moved to, below the destination
+directory if it's relative.
+*/
+func (f *Filer) reviewDir() string {
+	dir := f.cfg.NearDuplicates.Dir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(f.destRootDir, dir)
+	}
+	return dir
+}
+
+/*
+loadNearDuplicateIndex reads the index of images in the destination, and brings it up to
+date by hashing images that are new or have changed since it was saved. An index that
+can't be read is rebuilt.
+*/
+func (f *Filer) loadNearDuplicateIndex(planLog *logrus.Entry) *nearDuplicateIndex {
+	algorithm := f.cfg.NearDuplicates.Algorithm
+	path := filepath.Join(f.destRootDir, phash.IndexFileName(algorithm))
+
+	library, err := phash.LoadIndex(path, algorithm)
+	if err != nil {
+		planLog.Warnf("near-duplicate index can't be read, so it will be rebuilt. %s", err)
+		library = phash.NewIndex(algorithm)
+	}
+
+	f.refreshIndex(planLog, library)
+	return &nearDuplicateIndex{path: path, library: library, planned: phash.NewIndex(algorithm)}
+}
+
+/*
+refreshIndex hashes the images in each destination root that aren't in the index, or have
+changed since they were hashed, and drops images that are gone. Images waiting in the
+review directory aren't indexed.
+*/
+func (f *Filer) refreshIndex(planLog *logrus.Entry, index *phash.Index) {
+	reviewDir := f.reviewDir()
+	roots := f.destinationRoots()
+
+	seen := make(map[string]bool)
+	hashed := 0
+
+	for _, root := range roots {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				return nil
+			case d.IsDir() && path == reviewDir:
+				return filepath.SkipDir
+			case d.IsDir() || seen[path]:
+				return nil
+			}
+
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".jpg", ".jpeg", ".png":
+			default:
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			seen[path] = true
+
+			if entry, ok := index.Get(path); ok && entry.Current(info) {
+				return nil
+			}
+
+			hash, err := phash.HashFile(path, index.Algorithm)
+			if err != nil {
+				planLog.Debugf("couldn't compute a perceptual hash for %s. %s", path, err)
+				return nil
+			}
+
+			index.Set(phash.Entry{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Hash: hash})
+			hashed++
+			return nil
+		})
+	}
+
+	for _, entry := range index.Entries() {
+		if !seen[entry.Path] {
+			index.Remove(entry.Path)
+		}
+	}
+
+	planLog.Infof("near-duplicate index holds %d images (%d newly hashed)", len(index.Entries()), hashed)
+}
+
+/*
+checkNearDuplicate compares an image that's planned to be filed with the images in the
+destination and those planned before it. Near-duplicates are either reported and filed
+as planned, or moved to the review directory instead, depending on 'near-duplicates'.
+Nothing is ever deleted.
+*/
+func (f *Filer) checkNearDuplicate(fileLogger *logrus.Entry, meta gjson.Result, plan *Plan, sourceFileInfo os.FileInfo, claimed map[string]string,
+	planned PlannedFile) PlannedFile {
+	rules := f.cfg.NearDuplicates
+	if plan.nearDuplicates == nil || !rules.Enabled() || planned.Action != ACTION_FILE || !hashableMIMETypes[meta.Get("MIMEType").String()] {
+		return planned
+	}
+
+	hash, err := phash.HashFile(planned.Source, plan.nearDuplicates.library.Algorithm)
+	if err != nil {
+		fileLogger.Warnf("couldn't compute a perceptual hash, so near-duplicates won't be found. %s", err)
+		return planned
+	}
+
+	entry := phash.Entry{Path: planned.Destination, Size: sourceFileInfo.Size(), ModTime: sourceFileInfo.ModTime().UnixNano(), Hash: hash}
+
+	match, found := plan.nearDuplicates.closest(hash, rules.MaxDistance, planned.Source)
+	if !found {
+		plan.nearDuplicates.planned.Set(entry)
+		return planned
+	}
+
+	nearDuplicate := &NearDuplicate{Path: match.Path, Distance: match.Distance}
+	nearLogger := fileLogger.WithFields(logrus.Fields{"verb": "near-duplicate:"})
+
+	if rules.Mode == config.NEAR_DUPLICATES_REPORT {
+		nearLogger.Warnf("looks the same as %s (%d bits apart)", match.Path, match.Distance)
+		planned.NearDuplicate = nearDuplicate
+		plan.nearDuplicates.planned.Set(entry)
+		return planned
+	}
+
+	nearLogger.Warnf("looks the same as %s (%d bits apart), so it will be moved for review", match.Path, match.Distance)
+
+	// near-duplicates keep their path relative to the source directory, like unfiled files
+	relPath := relativeSourcePath(plan.sources, planned.Source)
+	fileBase, fileExtension := splitExtension(filepath.Base(relPath))
+
+	reviewed := f.planMove(fileLogger, PlannedFile{Source: planned.Source}, ACTION_REVIEW, sourceFileInfo, claimed,
+		filepath.Join(f.reviewDir(), filepath.Dir(relPath)), fileBase, fileExtension)
+	if reviewed.Action == ACTION_REVIEW {
+		reviewed.Reason = NEAR_DUPLICATE
+		reviewed.NearDuplicate = nearDuplicate
+	}
+	return reviewed
+}
+
+/*
+saveNearDuplicateIndex adds the images that were filed to the index of the destination,
+and saves it.
+*/
+func (f *Filer) saveNearDuplicateIndex(plan *Plan, results []Result) {
+	index := plan.nearDuplicates
+	indexLog := f.log.WithFields(logrus.Fields{"verb": "near-duplicate:"})
+
+	for _, result := range results {
+		if result.Outcome != OUTCOME_MOVED {
+			continue
+		}
+
+		index.library.Remove(result.Source)
+		if entry, ok := index.planned.Get(result.Destination); ok && result.Action == ACTION_FILE {
+			index.library.Set(entry)
+		}
+	}
+
+	if err := index.library.Save(index.path); err != nil {
+		indexLog.Warnf("couldn't save the near-duplicate index. %s", err)
+	}
+}
@@ -0,0 +1,145 @@
+package filer
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/phash"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+writeTestImage writes a picture of soft shapes to path, as a JPEG or PNG by its extension.
+Images with the same seed look the same at any size.
+*/
+func writeTestImage(t *testing.T, path string, width int, height int, seed float64) {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			u, v := float64(x)/float64(width), float64(y)/float64(height)
+			img.Set(x, y, color.RGBA{uint8(128 + 127*math.Sin(6*u*seed+v*3)), uint8(128 + 127*math.Cos(5*v*seed-u*2)), 128, 255})
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if filepath.Ext(path) == ".png" {
+		err = png.Encode(file, img)
+	} else {
+		err = jpeg.Encode(file, img, &jpeg.Options{Quality: 50})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+Test_checkNearDuplicate makes sure images that look like one already in the destination
+are reported or moved for review, and that the index is updated with the images filed.
+*/
+func Test_checkNearDuplicate(t *testing.T) {
+	for _, mode := range []string{config.NEAR_DUPLICATES_REPORT, config.NEAR_DUPLICATES_REVIEW} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFiler(t)
+			var err error
+			if f.cfg.MediaTypes, err = config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate); err != nil {
+				t.Fatal(err)
+			}
+			f.cfg.NearDuplicates = config.NearDuplicateRules{Mode: mode, Algorithm: phash.DHASH, MaxDistance: 6, Dir: "review"}
+
+			existing := filepath.Join(f.destRootDir, "image", "jpeg", "2023", "01", "existing.jpg")
+			writeTestImage(t, existing, 800, 600, 1)
+
+			workDir := t.TempDir()
+			files := []struct {
+				name       string
+				seed       float64
+				wantAction Action
+				wantNear   string
+			}{
+				{"resized.jpg", 1, ACTION_REVIEW, existing},
+				{"new.png", 2.7, ACTION_FILE, ""},
+				{"new-copy.jpg", 2.7, ACTION_REVIEW, "image/png/2024/06/20240615T130405.000Z-X.png"},
+			}
+
+			logger := logrus.NewEntry(logrus.New())
+			plan := &Plan{sources: []string{workDir}, nearDuplicates: f.loadNearDuplicateIndex(logger)}
+			claimed := make(map[string]string)
+
+			for _, file := range files {
+				sourceFile := filepath.Join(workDir, file.name)
+				writeTestImage(t, sourceFile, 320, 240, file.seed)
+
+				mimeType := "image/jpeg"
+				if filepath.Ext(file.name) == ".png" {
+					mimeType = "image/png"
+				}
+				meta := gjson.Parse(`{"SourceFile": "` + sourceFile + `", "FileTypeExtension": "` + filepath.Ext(file.name)[1:] + `",
+					"MIMEType": "` + mimeType + `", "DateTimeOriginal": 1718456645000, "Model": "X"}`)
+
+				planned, err := f.planFile(logger, meta, plan, claimed, "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if planned.Destination != "" {
+					claimed[planned.Destination] = sourceFile
+				}
+				plan.Files = append(plan.Files, planned)
+
+				wantAction, wantNear := file.wantAction, file.wantNear
+				if wantNear != "" && !filepath.IsAbs(wantNear) {
+					wantNear = filepath.Join(f.destRootDir, wantNear)
+				}
+				if mode == config.NEAR_DUPLICATES_REPORT && wantAction == ACTION_REVIEW {
+					wantAction = ACTION_FILE
+				}
+
+				gotNear := ""
+				if planned.NearDuplicate != nil {
+					gotNear = planned.NearDuplicate.Path
+				}
+				if planned.Action != wantAction || gotNear != wantNear {
+					t.Errorf("planFile(%s) = %+v, want action '%s' near '%s'", file.name, planned, wantAction, wantNear)
+				}
+
+				if planned.Action == ACTION_REVIEW && (planned.Reason != NEAR_DUPLICATE || planned.Destination != filepath.Join(f.destRootDir, "review", file.name)) {
+					t.Errorf("planFile(%s) reviewed at '%s' for '%s', want it in the review directory", file.name, planned.Destination, planned.Reason)
+				}
+			}
+
+			if _, err := f.Execute(context.Background(), plan); err != nil {
+				t.Fatal(err)
+			}
+
+			// the next run sees the images filed by this one, but not those waiting for review
+			index, err := phash.LoadIndex(filepath.Join(f.destRootDir, phash.IndexFileName(phash.DHASH)), phash.DHASH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantEntries := 4
+			if mode == config.NEAR_DUPLICATES_REVIEW {
+				wantEntries = 2
+			}
+			if got := len(index.Entries()); got != wantEntries {
+				t.Errorf("index has %d entries after filing, want %d", got, wantEntries)
+			}
+		})
+	}
+}
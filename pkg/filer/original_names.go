@@ -0,0 +1,311 @@
+package filer
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	multierr "github.com/hashicorp/go-multierror"
+)
+
+/*
+ManifestFileName is the name of the file in each destination directory that lists the
+original names of the files filed there, when 'original-names' is 'manifest'.
+*/
+const ManifestFileName = ".mediafiler-names.csv"
+
+/*
+SidecarExtension is added to a filed file's name to name the XMP sidecar holding its
+original name, when 'original-names' is 'sidecar'.
+*/
+const SidecarExtension = ".xmp"
+
+var manifestHeader = []string{"file", "original_name", "source_path"}
+
+/*
+OriginalName is the name a filed file had before it was filed. Path is where the file was
+filed to, and SourcePath is where it was filed from, relative to its source directory.
+*/
+type OriginalName struct {
+	Path       string
+	Name       string
+	SourcePath string
+}
+
+/*
+recordOriginalName writes down the original name of a file that was filed, in the
+directory's manifest or in a sidecar, following 'original-names'. Files moved anywhere
+else keep their names, so nothing is recorded for them.
+*/
+func (f *Filer) recordOriginalName(plan *Plan, result Result) error {
+	if result.Outcome != OUTCOME_MOVED || result.Action != ACTION_FILE {
+		return nil
+	}
+
+	original := OriginalName{
+		Path:       result.Destination,
+		Name:       filepath.Base(result.Source),
+		SourcePath: filepath.ToSlash(relativeSourcePath(plan.sources, result.Source)),
+	}
+
+	switch f.cfg.OriginalNames {
+	case config.ORIGINAL_NAMES_MANIFEST:
+		return appendManifest(original)
+	case config.ORIGINAL_NAMES_SIDECAR:
+		return writeSidecar(original)
+	}
+	return nil
+}
+
+/*
+appendManifest adds a row for a filed file to the manifest in its directory, creating the
+manifest if it's the first file recorded there.
+*/
+func appendManifest(original OriginalName) error {
+	path := filepath.Join(filepath.Dir(original.Path), ManifestFileName)
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w := csv.NewWriter(file)
+	if info.Size() == 0 {
+		w.Write(manifestHeader)
+	}
+	w.Write([]string{filepath.Base(original.Path), original.Name, original.SourcePath})
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+/*
+readManifest returns the original names listed in a manifest.
+*/
+func readManifest(path string) ([]OriginalName, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = len(manifestHeader)
+
+	var names []OriginalName
+	for line := 0; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+
+		if line == 0 && record[0] == manifestHeader[0] {
+			continue
+		}
+		names = append(names, OriginalName{Path: filepath.Join(filepath.Dir(path), record[0]), Name: record[1], SourcePath: record[2]})
+	}
+}
+
+/*
+xmpPacket holds a filed file's original name in xmpMM:PreservedFileName, the tag photo
+editors use for it, and the path it was filed from in dc:source.
+*/
+const xmpPacket = "<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n" +
+	"<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n" +
+	" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n" +
+	"  <rdf:Description rdf:about=\"\"\n" +
+	"    xmlns:xmpMM=\"http://ns.adobe.com/xap/1.0/mm/\"\n" +
+	"    xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n" +
+	"   <xmpMM:PreservedFileName>%s</xmpMM:PreservedFileName>\n" +
+	"   <dc:source>%s</dc:source>\n" +
+	"  </rdf:Description>\n" +
+	" </rdf:RDF>\n" +
+	"</x:xmpmeta>\n" +
+	"<?xpacket end=\"w\"?>\n"
+
+const (
+	xmpMMNamespace = "http://ns.adobe.com/xap/1.0/mm/"
+	dcNamespace    = "http://purl.org/dc/elements/1.1/"
+)
+
+/*
+writeSidecar writes an XMP sidecar next to a filed file. A sidecar that's already there
+is left alone, since it may hold someone's edits.
+*/
+func writeSidecar(original OriginalName) error {
+	path := original.Path + SidecarExtension
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(file, xmpPacket, xmlEscape(original.Name), xmlEscape(original.SourcePath))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+/*
+readSidecar returns the original name held in an XMP sidecar. Tags are read whether
+they're written as elements or as attributes, since editors write them either way. ok is
+false if the sidecar doesn't hold an original name.
+*/
+func readSidecar(path string) (OriginalName, bool, error) {
+	original := OriginalName{Path: strings.TrimSuffix(path, filepath.Ext(path))}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return original, false, err
+	}
+	defer file.Close()
+
+	field := func(name xml.Name) *string {
+		switch {
+		case name.Space == xmpMMNamespace && name.Local == "PreservedFileName":
+			return &original.Name
+		case name.Space == dcNamespace && name.Local == "source":
+			return &original.SourcePath
+		}
+		return nil
+	}
+
+	decoder := xml.NewDecoder(file)
+	var reading *string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return original, false, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			reading = field(t.Name)
+			for _, attr := range t.Attr {
+				if target := field(attr.Name); target != nil {
+					*target = attr.Value
+				}
+			}
+		case xml.CharData:
+			if reading != nil {
+				*reading += strings.TrimSpace(string(t))
+			}
+		case xml.EndElement:
+			reading = nil
+		}
+	}
+
+	return original, original.Name != "", nil
+}
+
+/*
+destinationRoots returns the destination directory along with the destination roots of
+each media type, which may be outside it.
+*/
+func (f *Filer) destinationRoots() []string {
+	roots := []string{f.destRootDir}
+	for _, mediaType := range f.cfg.MediaTypes {
+		roots = append(roots, mediaType.DestinationRoot(f.destRootDir))
+	}
+	return roots
+}
+
+/*
+FindOriginalNames looks through the manifests and sidecars in the destination for files
+whose original name matches pattern. Names are matched case-insensitively, and pattern can
+use the wildcards understood by path.Match, e.g. 'IMG_12*'. Patterns holding a '/'
+are matched against the path a file was filed from instead. Matches are sorted by the path
+they were filed to. Manifests and sidecars that can't be read are returned as errors along
+with whatever was found.
+*/
+func (f *Filer) FindOriginalNames(pattern string) ([]OriginalName, error) {
+	pattern = strings.ToLower(filepath.ToSlash(pattern))
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("'%s' is not a valid pattern. %s", pattern, err)
+	}
+
+	matches := func(original OriginalName) bool {
+		value := original.Name
+		if strings.Contains(pattern, "/") {
+			value = original.SourcePath
+		}
+		ok, _ := path.Match(pattern, strings.ToLower(value))
+		return ok
+	}
+
+	var found []OriginalName
+	var merr error
+	seen := make(map[string]bool)
+
+	for _, root := range f.destinationRoots() {
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil:
+				if !errors.Is(err, fs.ErrNotExist) {
+					merr = multierr.Append(merr, err)
+				}
+				return nil
+			case d.IsDir() || seen[path]:
+				return nil
+			}
+			seen[path] = true
+
+			var originals []OriginalName
+			switch {
+			case d.Name() == ManifestFileName:
+				originals, err = readManifest(path)
+			case strings.EqualFold(filepath.Ext(path), SidecarExtension):
+				var original OriginalName
+				var ok bool
+				if original, ok, err = readSidecar(path); ok {
+					originals = append(originals, original)
+				}
+			}
+			if err != nil {
+				merr = multierr.Append(merr, fmt.Errorf("couldn't read '%s'. %s", path, err))
+			}
+
+			for _, original := range originals {
+				if matches(original) {
+					found = append(found, original)
+				}
+			}
+			return nil
+		})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].Path < found[j].Path })
+	return found, merr
+}
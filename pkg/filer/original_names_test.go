@@ -0,0 +1,116 @@
+package filer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+)
+
+/*
+Test_FindOriginalNames makes sure the original names of filed files are recorded in a
+manifest or in sidecars, and can be found again by name, by wildcard or by source path.
+*/
+func Test_FindOriginalNames(t *testing.T) {
+	for _, mode := range []string{config.ORIGINAL_NAMES_MANIFEST, config.ORIGINAL_NAMES_SIDECAR} {
+		t.Run(mode, func(t *testing.T) {
+			f := testFiler(t)
+			f.cfg.OriginalNames = mode
+
+			workDir := t.TempDir()
+			files := []struct {
+				source string
+				dest   string
+				action Action
+			}{
+				{"DCIM/100CANON/IMG_1234.JPG", "2024/06/20240615T101112.123Z-Canon800D.jpg", ACTION_FILE},
+				{"DCIM/100CANON/IMG_1235.JPG", "2024/06/20240615T101113.000Z-Canon800D.jpg", ACTION_FILE},
+				{"Phone/IMG_1234.JPG", "2023/01/20230101T000000.000Z-Pixel.jpg", ACTION_FILE},
+				{"notes, \"old\".txt", "unsorted/notes, \"old\".txt", ACTION_UNSORTED},
+			}
+
+			plan := &Plan{sources: []string{workDir}}
+			for _, file := range files {
+				source := filepath.Join(workDir, filepath.FromSlash(file.source))
+				if err := os.MkdirAll(filepath.Dir(source), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(source, []byte(file.source), 0644); err != nil {
+					t.Fatal(err)
+				}
+				plan.Files = append(plan.Files, PlannedFile{Source: source, Action: file.action, Destination: filepath.Join(f.destRootDir, filepath.FromSlash(file.dest))})
+			}
+
+			if _, err := f.Execute(context.Background(), plan); err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				pattern string
+				want    []string // destinations found, in order
+			}{
+				{"img_1234.jpg", []string{"2023/01/20230101T000000.000Z-Pixel.jpg", "2024/06/20240615T101112.123Z-Canon800D.jpg"}},
+				{"IMG_123?.*", []string{"2023/01/20230101T000000.000Z-Pixel.jpg", "2024/06/20240615T101112.123Z-Canon800D.jpg",
+					"2024/06/20240615T101113.000Z-Canon800D.jpg"}},
+				{"Phone/*", []string{"2023/01/20230101T000000.000Z-Pixel.jpg"}},
+				{"notes*", nil},
+			}
+
+			for _, tt := range tests {
+				found, err := f.FindOriginalNames(tt.pattern)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got []string
+				for _, original := range found {
+					rel, _ := filepath.Rel(f.destRootDir, original.Path)
+					got = append(got, filepath.ToSlash(rel))
+				}
+				if len(got) != len(tt.want) {
+					t.Errorf("FindOriginalNames(%s) = %v, want %v", tt.pattern, got, tt.want)
+					continue
+				}
+				for idx := range got {
+					if got[idx] != tt.want[idx] {
+						t.Errorf("FindOriginalNames(%s) = %v, want %v", tt.pattern, got, tt.want)
+						break
+					}
+				}
+			}
+
+			found, _ := f.FindOriginalNames("Phone/IMG_1234.JPG")
+			if len(found) != 1 || found[0].Name != "IMG_1234.JPG" || found[0].SourcePath != "Phone/IMG_1234.JPG" {
+				t.Errorf("FindOriginalNames() = %+v, want the original name and source path recorded", found)
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		if _, err := testFiler(t).FindOriginalNames("IMG_[12"); err == nil {
+			t.Error("FindOriginalNames() accepted an invalid pattern")
+		}
+	})
+}
+
+/*
+Test_readSidecar makes sure original names are read from sidecars written by other software,
+which may hold them in attributes.
+*/
+func Test_readSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "filed.jpg.xmp")
+	sidecar := `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+		<rdf:Description rdf:about="" xmlns:xmpMM="http://ns.adobe.com/xap/1.0/mm/" xmpMM:PreservedFileName="IMG_0001.CR2"
+			xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="5"/>
+		</rdf:RDF></x:xmpmeta>`
+	if err := os.WriteFile(path, []byte(sidecar), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original, ok, err := readSidecar(path)
+	if err != nil || !ok || original.Name != "IMG_0001.CR2" || original.Path != path[:len(path)-len(SidecarExtension)] {
+		t.Errorf("readSidecar() = %+v, %v, %v, want the original name from the attribute", original, ok, err)
+	}
+}
@@ -0,0 +1,495 @@
+package filer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/catalog"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	"github.com/d0ct0rvenkman/mediafiler/internal/paths"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Action is what a plan does with a file.
+*/
+type Action string
+
+const (
+	ACTION_FILE     Action = "file"     // move the file to the destination built from its metadata
+	ACTION_UNSORTED Action = "unsorted" // move the file to 'unsorted-dir', since its MIME type isn't configured
+	ACTION_UNFILED  Action = "unfiled"  // move the file to 'unfiled-dir', since its metadata isn't usable
+	ACTION_REVIEW   Action = "review"   // move the file to 'near-duplicate-dir', since it looks like an image already filed
+	ACTION_SKIP     Action = "skip"     // leave the file where it is
+)
+
+/*
+Outcome is what happened to a file when a plan was executed.
+*/
+type Outcome string
+
+const (
+	OUTCOME_MOVED   Outcome = "moved"
+	OUTCOME_DRY_RUN Outcome = "dry-run" // the file would have been moved, but dry-run mode is on
+	OUTCOME_SKIPPED Outcome = "skipped"
+	OUTCOME_FAILED  Outcome = "failed"
+)
+
+/*
+PlannedFile is what a plan does with a single file. Destination is empty for files
+that are skipped. Reason says why a file is skipped, unfiled or moved for review, and
+Skip explains why it was skipped or unfiled in more detail. Both are empty for files
+that are filed or unsorted. NearDuplicate is set for images that look the same as one
+already filed, when 'near-duplicates' is set. Timestamp and TimestampTag are the time
+a filed file was taken and the tag it was read from. Timestamp is zero if the tag couldn't
+be parsed, in which case the file is filed at the epoch.
+*/
+type PlannedFile struct {
+	Source        string
+	Action        Action
+	Destination   string
+	Reason        Reason
+	Skip          *SkipError
+	NearDuplicate *NearDuplicate
+	Timestamp     time.Time
+	TimestampTag  string
+}
+
+/*
+Result is what happened to a planned file. Err is set when Outcome is OUTCOME_FAILED.
+WriteBackErr is set when a file was filed, but its metadata couldn't be written back to
+it, or the move couldn't be verified so nothing was written.
+*/
+type Result struct {
+	PlannedFile
+	Outcome      Outcome
+	Err          error
+	WriteBackErr error
+}
+
+/*
+Plan holds what will happen to each file found in a set of sources.
+*/
+type Plan struct {
+	Files          []PlannedFile
+	sources        []string
+	ignores        sourceIgnores
+	nearDuplicates *nearDuplicateIndex
+	catalogEntries map[string]catalog.Entry // what the catalog holds about files planned to be filed, by source
+}
+
+/*
+Plan works out what will happen to every file exiftool finds in sources, without
+changing anything. Sources must be absolute paths. Destinations are picked so that
+files in the plan don't collide with each other or with files already in the
+destination directory, and files already present there are skipped as duplicates.
+*/
+func (f *Filer) Plan(ctx context.Context, sources []string) (*Plan, error) {
+	defer f.begin(true)()
+
+	planLog := f.log.WithFields(logrus.Fields{"verb": "startup:"})
+
+	if f.exiftoolbin == "" {
+		exiftoolbin, err := exec.LookPath("exiftool")
+		if err != nil {
+			return nil, errors.New("exiftool binary was not found")
+		}
+		f.exiftoolbin = exiftoolbin
+	}
+
+	unsortedDir := f.groupDir("unsorted-dir")
+	if unsortedDir != "" {
+		planLog.Infof("files with unconfigured MIME types will be moved to: %s", unsortedDir)
+	}
+
+	unfiledDir := f.groupDir("unfiled-dir")
+	if unfiledDir != "" {
+		planLog.Infof("files that cannot be filed will be moved to: %s", unfiledDir)
+	}
+
+	// "2006-01-02T15:04:05.999999999Z07:00"
+	dateFormat := "%s%-3f"
+	// GPS coordinates as signed decimal degrees, for reverse geocoding
+	coordFormat := "%+.6f"
+
+	// find the ignored directories up front, so exiftool doesn't have to look through them
+	plan := &Plan{sources: sources, ignores: f.walkSources(planLog, sources)}
+
+	// the sources are passed to exiftool in an argument file read from stdin, since there can be more
+	// of them than fit on a command line
+	argFile, err := exiftoolArgFile(append(plan.ignores.exiftoolArgs(), sources...))
+	if err != nil {
+		return nil, fmt.Errorf("could not pass source paths to exiftool. %s", err)
+	}
+
+	cmd := exec.CommandContext(ctx, f.exiftoolbin, "-r", "-json", "-dateFormat", dateFormat, "-coordFormat", coordFormat, "-@", "-")
+	cmd.Stdin = strings.NewReader(argFile)
+	planLog.Infof("running exiftool command: %s (%d sources)", cmd.String(), len(sources))
+	output, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		planLog.Warnf("exiftool reported an error. %s", err)
+	}
+
+	if len(output) == 0 {
+		planLog.Info("exiftool output was empty.")
+		return plan, nil
+	}
+
+	if !gjson.ValidBytes(output) {
+		return nil, errors.New("failed to unmarshal JSON output from exiftool")
+	}
+
+	result := gjson.ParseBytes(output)
+
+	fileCount := len(result.Array())
+	planLog.Infof("Found %d files to process", fileCount)
+
+	// files are only hashed once per run, however many files they're compared with
+	f.hashes = nil
+
+	// events are worked out across the whole run, so they're known before any file is planned
+	f.events = nil
+	if f.usesEvents() {
+		f.events = f.planEvents(planLog, result.Array(), plan)
+	} else if f.cfg.Events.Enabled() {
+		planLog.Warn("event-gap is set, but no template uses {{.Event}}")
+	}
+
+	if f.cfg.NearDuplicates.Enabled() {
+		plan.nearDuplicates = f.loadNearDuplicateIndex(planLog)
+	}
+
+	// destinations already picked for files earlier in the plan, and the files they were picked for
+	claimed := make(map[string]string)
+
+	for k, v := range result.Array() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		sourceFile := v.Get("SourceFile").String()
+
+		fileLogger := f.log.WithFields(logrus.Fields{
+			"sourceFile": relativeSourcePath(sources, sourceFile),
+			"fileIndex":  k + 1,
+			"fileCount":  fileCount,
+			"verb":       "  ",
+		})
+
+		f.log.WithFields(logrus.Fields{"verb": "processing:"}).Infof("%s (%d of %d)", sourceFile, k+1, fileCount)
+
+		planned, err := f.planFile(fileLogger, v, plan, claimed, unsortedDir, unfiledDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if planned.Destination != "" {
+			claimed[planned.Destination] = sourceFile
+		}
+		plan.Files = append(plan.Files, planned)
+
+		if f.hooks.Planned != nil {
+			f.hooks.Planned(k, fileCount, planned)
+		}
+	}
+
+	return plan, nil
+}
+
+/*
+planFile works out what will happen to a single file. Only problems that should stop
+the whole plan are returned as errors.
+*/
+func (f *Filer) planFile(fileLogger *logrus.Entry, meta gjson.Result, plan *Plan, claimed map[string]string, unsortedDir string,
+	unfiledDir string) (PlannedFile, error) {
+	sourceFile := meta.Get("SourceFile").String()
+	planned := PlannedFile{Source: sourceFile, Action: ACTION_SKIP}
+	skipLogger := fileLogger.WithFields(logrus.Fields{"verb": "skip:"})
+
+	ignore, err := f.isPathIgnored(sourceFile, plan.ignores)
+	if err != nil {
+		return planned, fmt.Errorf("Path Ignore Filter execution failed: reason ('%s')", err)
+	}
+	if ignore {
+		skipLogger.Warnf("sourceFile matches an ignore path pattern")
+		return skipped(planned, newSkipError(SKIP_IGNORED, "sourceFile matches an ignore path pattern")), nil
+	}
+
+	if filepath.Base(sourceFile) == f.cfg.Events.MarkerFile {
+		skipLogger.Debug("sourceFile is an event marker file")
+		return skipped(planned, newSkipError(SKIP_IGNORED, "sourceFile is an event marker file")), nil
+	}
+
+	sourceFileInfo, err := os.Stat(sourceFile)
+	if err != nil {
+		skipLogger.Error("could not Stat source file. interesting.")
+		return skipped(planned, &SkipError{Reason: SKIP_STAT_FAILED, Path: sourceFile, Err: err, msg: "could not Stat source file"}), nil
+	}
+
+	if skip, filterName := f.cfg.FileFilters.Skip(metadata.FilterFile(meta, sourceFile, sourceFileInfo.Size())); skip {
+		skipErr := newSkipError(SKIP_NOT_INCLUDED, "sourceFile doesn't match any include filter")
+		if filterName != "" {
+			skipErr = newSkipError(SKIP_EXCLUDED, "sourceFile matches exclude filter '%s'", filterName)
+		}
+		skipLogger.Info(skipErr)
+		return skipped(planned, skipErr), nil
+	}
+
+	// files with a MIME type we don't file go to the unsorted directory, if there is one
+	if mimeType, mimeSubType, err := splitMIMEType(meta); err == nil && unsortedDir != "" {
+		if _, ok := f.cfg.MediaTypes.Match(mimeType, mimeSubType); !ok {
+			relPath := relativeSourcePath(plan.sources, sourceFile)
+			fileBase, fileExtension := splitExtension(filepath.Base(relPath))
+
+			fileLogger.Debugf("MIME type '%s/%s' is not configured. filing as unsorted", mimeType, mimeSubType)
+
+			return f.planMove(fileLogger, planned, ACTION_UNSORTED, sourceFileInfo, claimed, filepath.Join(unsortedDir, filepath.Dir(relPath)),
+				fileBase, fileExtension), nil
+		}
+	}
+
+	newPathSuffix, newFileName, fileExtension, err := f.generateFilenameBase(meta, f.cfg.MediaTypes, f.cfg.ModelReplacer, f.specialReplacer,
+		f.cfg.Cameras, f.cfg.FilenameTemplate)
+	if err != nil {
+		var skipErr *SkipError
+		if !errors.As(err, &skipErr) {
+			return planned, err
+		}
+
+		if unfiledDir != "" && skipErr.Reason.IsUnfiled() {
+			fileLogger.Infof("generateFilenameBase: %s", err)
+
+			// unfiled files are grouped by the reason they were rejected, keeping their path relative to the source directory
+			relPath := relativeSourcePath(plan.sources, sourceFile)
+			fileBase, fileExtension := splitExtension(filepath.Base(relPath))
+
+			planned.Reason, planned.Skip = skipErr.Reason, skipErr
+			return f.planMove(fileLogger, planned, ACTION_UNFILED, sourceFileInfo, claimed, filepath.Join(unfiledDir, string(skipErr.Reason), filepath.Dir(relPath)),
+				fileBase, fileExtension), nil
+		}
+
+		skipLogger.Infof("generateFilenameBase: %s", err)
+		return skipped(planned, skipErr), nil
+	}
+
+	// generateFilenameBase succeeded, so the MIME type is known to be valid and routed
+	mimeType, mimeSubType, _ := splitMIMEType(meta)
+	mediaType, _ := f.cfg.MediaTypes.Match(mimeType, mimeSubType)
+	fileDestRootDir := mediaType.DestinationRoot(f.destRootDir)
+
+	fileLogger.Debugf("destRootDir: %s", fileDestRootDir)
+	fileLogger.Debugf("newPathSuffix: %s", newPathSuffix)
+	fileLogger.Debugf("newFileName: %s", newFileName)
+
+	planned.Timestamp, planned.TimestampTag, _ = metadata.Timestamp(meta)
+	planned = f.planMove(fileLogger, planned, ACTION_FILE, sourceFileInfo, claimed, filepath.Join(fileDestRootDir, newPathSuffix), newFileName, fileExtension)
+	f.planCatalogEntry(plan, meta, planned)
+	return f.checkNearDuplicate(fileLogger, meta, plan, sourceFileInfo, claimed, planned), nil
+}
+
+/*
+skipped returns planned as a file that's left where it is, for the reason skipErr gives.
+*/
+func skipped(planned PlannedFile, skipErr *SkipError) PlannedFile {
+	planned.Action = ACTION_SKIP
+	planned.Destination = ""
+	planned.Reason, planned.Skip = skipErr.Reason, skipErr
+	return planned
+}
+
+/*
+planMove picks the destination for a file that's being moved into destDir. The file is
+skipped if no destination can be used, e.g. because it's already there.
+*/
+func (f *Filer) planMove(fileLogger *logrus.Entry, planned PlannedFile, action Action, sourceFileInfo os.FileInfo, claimed map[string]string,
+	destDir string, fileBase string, fileExtension string) PlannedFile {
+	destFile, err := findDestFile(fileLogger, f.fileHashes(), planned.Source, sourceFileInfo, claimed, destDir, fileBase, fileExtension)
+	if err != nil {
+		var skipErr *SkipError
+		if !errors.As(err, &skipErr) {
+			skipErr = &SkipError{Reason: SKIP_NO_DESTINATION, Err: err, msg: "could not find a destination path"}
+		}
+		return skipped(PlannedFile{Source: planned.Source}, skipErr)
+	}
+
+	fileLogger.Debugf("destination file: %s", destFile)
+
+	planned.Action = action
+	planned.Destination = destFile
+	return planned
+}
+
+/*
+Execute carries out a plan, moving each file to its destination. In dry-run mode the
+moves are only logged. Files whose destination was taken after the plan was made are
+left in place and reported as failed.
+*/
+func (f *Filer) Execute(ctx context.Context, plan *Plan) ([]Result, error) {
+	defer f.begin(false)()
+
+	dryrun := f.cfg.GetBool("dry-run")
+
+	if !dryrun && !f.locked {
+		lock, err := f.acquireLock()
+		if err != nil {
+			return nil, err
+		}
+		defer f.releaseLock(lock)
+	}
+
+	unfiledDir := f.groupDir("unfiled-dir")
+	results := make([]Result, 0, len(plan.Files))
+
+	var cat *catalog.Catalog
+	if !dryrun {
+		if cat = f.openCatalog(); cat != nil {
+			defer cat.Close()
+		}
+	}
+
+	for k, planned := range plan.Files {
+		if err := ctx.Err(); err != nil {
+			return results, err
+		}
+
+		fileLogger := f.log.WithFields(logrus.Fields{
+			"sourceFile": relativeSourcePath(plan.sources, planned.Source),
+			"fileIndex":  k + 1,
+			"fileCount":  len(plan.Files),
+			"verb":       "  ",
+		})
+
+		result := f.executeFile(ctx, fileLogger, planned, dryrun, unfiledDir)
+		results = append(results, result)
+
+		// names are recorded and files catalogued as each file is filed, so none are lost if the run is stopped
+		if err := f.recordOriginalName(plan, result); err != nil {
+			fileLogger.WithFields(logrus.Fields{"verb": "original-name:"}).Warnf("couldn't record the original name. %s", err)
+		}
+
+		if cat != nil {
+			if err := f.catalogFile(cat, plan, result); err != nil {
+				fileLogger.WithFields(logrus.Fields{"verb": "catalog:"}).Warnf("couldn't add the file to the catalog. %s", err)
+			}
+		}
+
+		if f.hooks.Executed != nil {
+			f.hooks.Executed(k, len(plan.Files), result)
+		}
+	}
+
+	if plan.nearDuplicates != nil && !dryrun {
+		f.saveNearDuplicateIndex(plan, results)
+	}
+
+	if f.cfg.GetBool("cleanup-empty-dirs") {
+		// only the directories files were moved out of are cleaned up, counting the moves a dry run would make
+		var moved []string
+		for _, result := range results {
+			if result.Outcome == OUTCOME_MOVED || result.Outcome == OUTCOME_DRY_RUN {
+				moved = append(moved, result.Source)
+			}
+		}
+
+		for _, source := range plan.sources {
+			f.cleanupEmptyDirs(source, moved, plan.ignores, dryrun)
+		}
+	}
+
+	return results, nil
+}
+
+/*
+executeFile moves a single planned file into place. Filed files then have their metadata
+written back to them, if that's turned on and the move can be verified.
+*/
+func (f *Filer) executeFile(ctx context.Context, fileLogger *logrus.Entry, planned PlannedFile, dryrun bool, unfiledDir string) Result {
+	result := Result{PlannedFile: planned}
+
+	verb := ""
+	switch planned.Action {
+	case ACTION_FILE:
+		verb = "renamed:"
+	case ACTION_UNSORTED:
+		verb = "unsorted:"
+	case ACTION_UNFILED:
+		verb = "unfiled:"
+	case ACTION_REVIEW:
+		verb = "review:"
+	default:
+		result.Outcome = OUTCOME_SKIPPED
+		return result
+	}
+
+	if dryrun {
+		fileLogger.WithFields(logrus.Fields{"verb": "dry-run:"}).Infof(">> %s", planned.Destination)
+		result.Outcome = OUTCOME_DRY_RUN
+		return result
+	}
+
+	// the destination was free when the plan was made, but something else may have been put there since
+	if available, _, err := paths.IsPathAvailable(planned.Destination); !available {
+		result.Outcome = OUTCOME_FAILED
+		result.Err = fmt.Errorf("destination can't be used since the plan was made. %w", err)
+		fileLogger.WithFields(logrus.Fields{"verb": "error:"}).Error(result.Err)
+		return result
+	}
+
+	// files are checked before they're moved, so nothing is written to a file that didn't arrive intact
+	var check *moveCheck
+	if planned.Action == ACTION_FILE && f.writeBackEnabled() && len(f.writeBackArgs(planned)) > 0 {
+		var err error
+		if check, err = f.checkBeforeMove(planned.Source); err != nil {
+			result.WriteBackErr = fmt.Errorf("couldn't check the file before moving it, so its metadata won't be written. %w", err)
+			fileLogger.WithFields(logrus.Fields{"verb": "write-back:"}).Warn(result.WriteBackErr)
+		}
+	}
+
+	if err := moveFile(fileLogger, planned.Source, planned.Destination, verb, check); err != nil {
+		result.Outcome = OUTCOME_FAILED
+		result.Err = err
+		return result
+	}
+
+	if check != nil {
+		writeBackLogger := fileLogger.WithFields(logrus.Fields{"verb": "write-back:"})
+		if err := f.verifyMove(check, planned.Destination); err != nil {
+			result.WriteBackErr = fmt.Errorf("the move couldn't be verified, so its metadata won't be written. %w", err)
+			writeBackLogger.Error(result.WriteBackErr)
+		} else if err := f.writeBack(ctx, fileLogger, planned); err != nil {
+			result.WriteBackErr = err
+			writeBackLogger.Warnf("couldn't write metadata to the filed file. %s", err)
+		}
+	}
+
+	if planned.Action == ACTION_UNFILED {
+		if err := writeUnfiledNote(unfiledDir, planned.Reason); err != nil {
+			fileLogger.Warnf("could not write note for unfiled group '%s'. reason: %s", planned.Reason, err)
+		}
+	}
+
+	result.Outcome = OUTCOME_MOVED
+	return result
+}
+
+/*
+groupDir returns the directory given by the 'unsorted-dir' or 'unfiled-dir' setting,
+below the destination directory if it's relative. Empty if the setting isn't set.
+*/
+func (f *Filer) groupDir(key string) string {
+	dir := f.cfg.GetString(key)
+	if dir != "" && !filepath.IsAbs(dir) {
+		dir = filepath.Join(f.destRootDir, dir)
+	}
+	return dir
+}
@@ -0,0 +1,134 @@
+package filer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/paths"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Test_planFile_Claimed makes sure files in the same plan don't get the same destination, and
+that a file identical to one planned before it is skipped as a duplicate.
+*/
+func Test_planFile_Claimed(t *testing.T) {
+	f := testFiler(t)
+
+	var err error
+	if f.cfg.MediaTypes, err = config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	workDir := t.TempDir()
+	contents := map[string]string{"a.jpg": "first", "b.jpg": "second", "c.jpg": "first"}
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(workDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file       string
+		wantAction Action
+		wantDest   string
+		wantReason Reason
+	}{
+		{"a.jpg", ACTION_FILE, "image/jpeg/2024/06/20240615T130405.000Z-X.jpg", ""},
+		{"b.jpg", ACTION_FILE, "image/jpeg/2024/06/20240615T130405.000Z-X-001.jpg", ""},
+		{"c.jpg", ACTION_SKIP, "", SKIP_DUPLICATE},
+	}
+
+	fileLogger := logrus.NewEntry(logrus.New())
+	plan := &Plan{sources: []string{workDir}}
+	claimed := make(map[string]string)
+
+	for _, tt := range tests {
+		sourceFile := filepath.Join(workDir, tt.file)
+		meta := gjson.Parse(`{"SourceFile": "` + sourceFile + `", "FileTypeExtension": "JPG", "MIMEType": "image/jpeg",
+			"DateTimeOriginal": 1718456645000, "Model": "X"}`)
+
+		planned, err := f.planFile(fileLogger, meta, plan, claimed, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		wantDest := ""
+		if tt.wantDest != "" {
+			wantDest = filepath.Join(f.destRootDir, tt.wantDest)
+		}
+		if planned.Action != tt.wantAction || planned.Destination != wantDest || planned.Reason != tt.wantReason {
+			t.Errorf("planFile(%s) = %+v, want action '%s', destination '%s' and reason '%s'", tt.file, planned, tt.wantAction, wantDest, tt.wantReason)
+		}
+
+		if tt.wantReason == SKIP_DUPLICATE && (planned.Skip == nil || planned.Skip.Path != filepath.Join(workDir, "a.jpg")) {
+			t.Errorf("planFile(%s) skip = %+v, want it to point at the file it duplicates", tt.file, planned.Skip)
+		}
+
+		if planned.Destination != "" {
+			claimed[planned.Destination] = sourceFile
+		}
+	}
+}
+
+/*
+Test_Execute makes sure planned files are moved, skipped files are left alone, and that the
+hook is called for each of them.
+*/
+func Test_Execute(t *testing.T) {
+	f := testFiler(t)
+
+	workDir := t.TempDir()
+	moved := filepath.Join(workDir, "a.jpg")
+	left := filepath.Join(workDir, "b.jpg")
+	taken := filepath.Join(workDir, "c.jpg")
+	for _, file := range []string{moved, left, taken} {
+		if err := os.WriteFile(file, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan := &Plan{sources: []string{workDir}, Files: []PlannedFile{
+		{Source: moved, Action: ACTION_FILE, Destination: filepath.Join(f.destRootDir, "2024", "a.jpg")},
+		{Source: left, Action: ACTION_SKIP, Reason: SKIP_EXCLUDED},
+		{Source: taken, Action: ACTION_FILE, Destination: left},
+	}}
+
+	var hooked []Outcome
+	f.hooks.Executed = func(index int, count int, result Result) {
+		hooked = append(hooked, result.Outcome)
+	}
+
+	results, err := f.Execute(context.Background(), plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Outcome{OUTCOME_MOVED, OUTCOME_SKIPPED, OUTCOME_FAILED}
+	if len(results) != len(want) || len(hooked) != len(want) {
+		t.Fatalf("Execute() returned %d results and called the hook %d times, want %d", len(results), len(hooked), len(want))
+	}
+
+	for idx, result := range results {
+		if result.Outcome != want[idx] || hooked[idx] != want[idx] {
+			t.Errorf("result %d = '%s' (hook got '%s'), want '%s'", idx, result.Outcome, hooked[idx], want[idx])
+		}
+	}
+
+	if !errors.Is(results[2].Err, paths.ErrPathExists) {
+		t.Errorf("the file with a taken destination reported '%v', want an error matching paths.ErrPathExists", results[2].Err)
+	}
+
+	if _, err := os.Stat(plan.Files[0].Destination); err != nil {
+		t.Errorf("planned file was not moved. reason: %s", err)
+	}
+	if _, err := os.Stat(taken); err != nil {
+		t.Errorf("file with a taken destination was moved anyway")
+	}
+}
@@ -0,0 +1,66 @@
+package filer
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+Reason says why a file is skipped or unfiled.
+*/
+type Reason string
+
+/*
+Reasons a file is left where it is. Files can also be skipped for one of the UNFILED_
+reasons when 'unfiled-dir' isn't set.
+*/
+const (
+	SKIP_IGNORED         Reason = "ignored"         // the path matches an ignore pattern or a .mediafilerignore file
+	SKIP_STAT_FAILED     Reason = "stat-failed"     // the file couldn't be looked at
+	SKIP_EXCLUDED        Reason = "excluded"        // the file matches an exclude filter
+	SKIP_NOT_INCLUDED    Reason = "not-included"    // there are include filters, and the file matches none of them
+	SKIP_SAME_FILE       Reason = "same-file"       // the file is already at its destination
+	SKIP_DUPLICATE       Reason = "duplicate"       // an identical file is already at its destination
+	SKIP_CHECKSUM_FAILED Reason = "checksum-failed" // the file couldn't be compared with the one at its destination
+	SKIP_NO_DESTINATION  Reason = "no-destination"  // every destination path that was tried is taken
+	SKIP_TEMPLATE_FAILED Reason = "template-failed" // the path or filename template couldn't be rendered
+)
+
+/*
+SkipError explains why a file is skipped or unfiled. Path is the other path involved,
+such as the file a duplicate was found at or the file that couldn't be looked at, and Err
+is the underlying error, if any.
+*/
+type SkipError struct {
+	Reason Reason
+	Path   string
+	Err    error
+	msg    string
+}
+
+func (e *SkipError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s. %s", e.msg, e.Err)
+	}
+	return e.msg
+}
+
+func (e *SkipError) Unwrap() error {
+	return e.Err
+}
+
+func newSkipError(reason Reason, format string, a ...any) *SkipError {
+	return &SkipError{Reason: reason, msg: fmt.Sprintf(format, a...)}
+}
+
+/*
+ReasonOf returns the reason given by a *SkipError in err's chain. ok is false for any
+other error.
+*/
+func ReasonOf(err error) (Reason, bool) {
+	var skipErr *SkipError
+	if errors.As(err, &skipErr) {
+		return skipErr.Reason, true
+	}
+	return "", false
+}
@@ -0,0 +1,71 @@
+package filer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+/*
+Reasons a file can't be filed because of its metadata. Files rejected for these reasons
+are moved to 'unfiled-dir' if it's set, and skipped otherwise.
+*/
+const (
+	UNFILED_NO_EXTENSION  Reason = "no-extension"
+	UNFILED_NO_MIME_TYPE  Reason = "no-mime-type"
+	UNFILED_BAD_MIME_TYPE Reason = "bad-mime-type"
+	UNFILED_UNSUPPORTED   Reason = "unsupported-mime-type"
+	UNFILED_NO_TIMESTAMP  Reason = "no-timestamp"
+)
+
+const (
+	unfiledNoteFileSuffix  string = ".txt"
+	unfiledNoteFileHeading string = "mediafiler could not file the files in the '%s' directory next to this note.\n\n"
+)
+
+/*
+unfiledReasons describes why files in each unfiled group were rejected. The description
+is written to a note alongside the group's directory.
+*/
+var unfiledReasons = map[Reason]string{
+	UNFILED_NO_EXTENSION: "exiftool did not report a file type extension for these files, so mediafiler" +
+		" could not tell what kind of files they are.",
+	UNFILED_NO_MIME_TYPE:  "exiftool did not report a MIME type for these files.",
+	UNFILED_BAD_MIME_TYPE: "the MIME type exiftool reported for these files is malformed.",
+	UNFILED_UNSUPPORTED: "these files have a MIME type that isn't listed in the media-types configuration," +
+		" and no unsorted-dir is configured.",
+	UNFILED_NO_TIMESTAMP: "none of the tags mediafiler looks for to determine when a file was created" +
+		" (SubSecDateTimeOriginal, DateTimeOriginal, CreateDate, ModifyDate and GPSDateTime) were found in these files.",
+}
+
+/*
+IsUnfiled reports whether files skipped for this reason can be moved to 'unfiled-dir'.
+*/
+func (r Reason) IsUnfiled() bool {
+	_, ok := unfiledReasons[r]
+	return ok
+}
+
+/*
+writeUnfiledNote writes the note explaining why files in a group were rejected, unless it
+already exists.
+*/
+func writeUnfiledNote(unfiledDir string, reason Reason) error {
+	notePath := filepath.Join(unfiledDir, string(reason)+unfiledNoteFileSuffix)
+
+	f, err := os.OpenFile(notePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	note := fmt.Sprintf(unfiledNoteFileHeading, reason) + unfiledReasons[reason] + "\n\n" +
+		"files keep their path relative to the source directory they were found in. once the problem is" +
+		" fixed, they can be filed by running mediafiler on this directory.\n"
+
+	_, err = f.WriteString(note)
+	return err
+}
@@ -0,0 +1,114 @@
+package filer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+This test verifies that generateFilenameBase reports why a file can't be filed.
+*/
+func Test_generateFilenameBase_UnfiledReasons(t *testing.T) {
+	mediaTypes, err := config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate)
+	if err != nil {
+		t.Fatalf("could not set up default media types. reason: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		metadata string
+		want     Reason
+	}{
+		{"no extension", `{"MIMEType": "image/jpeg", "DateTimeOriginal": 1729799230000}`, UNFILED_NO_EXTENSION},
+		{"no MIME type", `{"FileTypeExtension": "jpg", "DateTimeOriginal": 1729799230000}`, UNFILED_NO_MIME_TYPE},
+		{"bad MIME type", `{"FileTypeExtension": "jpg", "MIMEType": "imagejpeg", "DateTimeOriginal": 1729799230000}`, UNFILED_BAD_MIME_TYPE},
+		{"empty MIME subtype", `{"FileTypeExtension": "jpg", "MIMEType": "image/", "DateTimeOriginal": 1729799230000}`, UNFILED_BAD_MIME_TYPE},
+		{"unsupported MIME type", `{"FileTypeExtension": "pdf", "MIMEType": "application/pdf", "DateTimeOriginal": 1729799230000}`, UNFILED_UNSUPPORTED},
+		{"no timestamp", `{"FileTypeExtension": "jpg", "MIMEType": "image/jpeg"}`, UNFILED_NO_TIMESTAMP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, err := testFiler(t).generateFilenameBase(gjson.Parse(tt.metadata), mediaTypes, strmanip.Replacer{}, strmanip.Replacer{}, nil, nil)
+
+			var skipErr *SkipError
+			if !errors.As(err, &skipErr) {
+				t.Fatalf("generateFilenameBase() err = %v, wanted a SkipError", err)
+			}
+
+			if skipErr.Reason != tt.want {
+				t.Errorf("generateFilenameBase() reason = '%s', want '%s'", skipErr.Reason, tt.want)
+			}
+
+			if !skipErr.Reason.IsUnfiled() {
+				t.Errorf("reason '%s' has no description", skipErr.Reason)
+			}
+		})
+	}
+}
+
+/*
+This test verifies that unfiled files are grouped by reason, keep their relative path, and
+that a note is written alongside the group.
+*/
+func Test_planFile_Unfiled(t *testing.T) {
+	workDir := t.TempDir()
+	unfiledDir := t.TempDir()
+
+	sourceFile := filepath.Join(workDir, "DCIM", "100CANON", "IMG_1234.JPG")
+	if err := os.MkdirAll(filepath.Dir(sourceFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(sourceFile, []byte("not really a jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	f := testFiler(t)
+	if f.cfg.MediaTypes, err = config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	fileLogger := logrus.NewEntry(logrus.New())
+	meta := gjson.Parse(`{"SourceFile": "` + sourceFile + `", "FileTypeExtension": "JPG", "MIMEType": "image/jpeg"}`)
+
+	planned, err := f.planFile(fileLogger, meta, &Plan{sources: []string{workDir}}, nil, "", unfiledDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if planned.Action != ACTION_UNFILED || planned.Reason != UNFILED_NO_TIMESTAMP {
+		t.Fatalf("planFile() = %+v, wanted the file to be unfiled with reason '%s'", planned, UNFILED_NO_TIMESTAMP)
+	}
+
+	if result := f.executeFile(context.Background(), fileLogger, planned, false, unfiledDir); result.Outcome != OUTCOME_MOVED {
+		t.Fatalf("executeFile() = %+v, wanted the file to be moved", result)
+	}
+
+	destFile := filepath.Join(unfiledDir, string(UNFILED_NO_TIMESTAMP), "DCIM", "100CANON", "IMG_1234.JPG")
+	if _, err := os.Stat(destFile); err != nil {
+		t.Errorf("unfiled file was not found at '%s'. reason: %s", destFile, err)
+	}
+
+	if _, err := os.Stat(sourceFile); !os.IsNotExist(err) {
+		t.Errorf("source file still exists after being unfiled")
+	}
+
+	note, err := os.ReadFile(filepath.Join(unfiledDir, string(UNFILED_NO_TIMESTAMP)+".txt"))
+	if err != nil {
+		t.Fatalf("note for unfiled group was not written. reason: %s", err)
+	}
+
+	if !strings.Contains(string(note), unfiledReasons[UNFILED_NO_TIMESTAMP]) {
+		t.Errorf("note for unfiled group doesn't explain the reason: '%s'", note)
+	}
+}
@@ -0,0 +1,149 @@
+package filer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"os"
+	"os/exec"
+	"strings"
+
+	logrus "github.com/sirupsen/logrus"
+)
+
+/*
+originalDateTags are the timestamp tags that already hold the date a file was taken, so
+writing it back would change nothing.
+*/
+var originalDateTags = map[string]bool{"SubSecDateTimeOriginal": true, "DateTimeOriginal": true}
+
+/*
+writeBackEnabled reports whether filed files have metadata written back to them, by
+'write-back-dates' or 'write-back-source-path'.
+*/
+func (f *Filer) writeBackEnabled() bool {
+	return f.cfg.GetBool("write-back-dates") || f.cfg.GetBool("write-back-source-path")
+}
+
+/*
+writeBackArgs returns the exiftool arguments that write a filed file's metadata, or none
+if there's nothing to write. Dates are only written when the timestamp was taken from a
+tag other than DateTimeOriginal, and are written in the local time zone, which is how
+exiftool reads dates that don't have one.
+*/
+func (f *Filer) writeBackArgs(planned PlannedFile) []string {
+	var args []string
+
+	if f.cfg.GetBool("write-back-dates") && !planned.Timestamp.IsZero() && !originalDateTags[planned.TimestampTag] {
+		local := planned.Timestamp.Local()
+		args = append(args,
+			"-DateTimeOriginal="+local.Format("2006:01:02 15:04:05"),
+			"-OffsetTimeOriginal="+local.Format("-07:00"))
+	}
+
+	if f.cfg.GetBool("write-back-source-path") {
+		args = append(args, "-XMP-dc:Source="+planned.Source)
+	}
+
+	return args
+}
+
+/*
+moveCheck is what's known about a file before it's moved, so the move can be verified.
+hash is fed the file's contents if it has to be copied, which copied reports.
+*/
+type moveCheck struct {
+	info   os.FileInfo
+	hash   hash.Hash
+	copied bool
+}
+
+/*
+checkBeforeMove records what's needed to verify a file that's about to be moved. The file
+isn't read, since it's usually just renamed.
+*/
+func (f *Filer) checkBeforeMove(sourceFile string) (*moveCheck, error) {
+	info, err := os.Stat(sourceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := f.fileHashes().Algorithm.New()
+	if err != nil {
+		return nil, err
+	}
+
+	return &moveCheck{info: info, hash: h}, nil
+}
+
+/*
+verifyMove checks that the file at destFile is the one checked before it was moved. A file
+renamed on the same file system is still the same file, and one copied across file
+systems must have the size it had and the hash of what was read while copying it.
+*/
+func (f *Filer) verifyMove(check *moveCheck, destFile string) error {
+	info, err := os.Stat(destFile)
+	if err != nil {
+		return err
+	}
+
+	if os.SameFile(check.info, info) {
+		return nil
+	}
+	if !check.copied {
+		return fmt.Errorf("'%s' isn't the file that was moved there", destFile)
+	}
+
+	if info.Size() != check.info.Size() {
+		return fmt.Errorf("'%s' is %d bytes, but the file moved there was %d bytes", destFile, info.Size(), check.info.Size())
+	}
+
+	sum, err := f.fileHashes().Sum(destFile, info)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(sum, check.hash.Sum(nil)) {
+		return fmt.Errorf("'%s' doesn't have the same %s sum as the file moved there", destFile, f.fileHashes().Algorithm)
+	}
+
+	return nil
+}
+
+/*
+writeBack runs exiftool to write the metadata given by writeBackArgs to a file that's been
+filed and verified. The file is rewritten in place, keeping its modification time.
+*/
+func (f *Filer) writeBack(ctx context.Context, fileLogger *logrus.Entry, planned PlannedFile) error {
+	args := f.writeBackArgs(planned)
+	if len(args) == 0 {
+		return nil
+	}
+
+	if f.exiftoolbin == "" {
+		exiftoolbin, err := exec.LookPath("exiftool")
+		if err != nil {
+			return errors.New("exiftool binary was not found")
+		}
+		f.exiftoolbin = exiftoolbin
+	}
+
+	// the path is passed in an argument file, as it is when reading metadata, so exiftool doesn't mangle it
+	argFile, err := exiftoolArgFile([]string{planned.Destination})
+	if err != nil {
+		return err
+	}
+
+	args = append([]string{"-overwrite_original", "-preserve", "-quiet"}, args...)
+	cmd := exec.CommandContext(ctx, f.exiftoolbin, append(args, "-@", "-")...)
+	cmd.Stdin = strings.NewReader(argFile)
+
+	fileLogger.Debugf("running exiftool command: %s", cmd.String())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("exiftool failed. %s %s", err, strings.TrimSpace(string(output)))
+	}
+
+	fileLogger.WithFields(logrus.Fields{"verb": "write-back:"}).Infof("%s", strings.Join(args[3:], " "))
+	return nil
+}
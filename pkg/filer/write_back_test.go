@@ -0,0 +1,146 @@
+package filer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	logrus "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+/*
+Test_executeFile_WriteBack makes sure metadata is only written back to filed files when it's
+turned on and there's something to write, using a stand-in for exiftool that records how it
+was run.
+*/
+func Test_executeFile_WriteBack(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the exiftool stand-in is a shell script")
+	}
+
+	taken := time.Date(2024, 6, 15, 13, 4, 5, 0, time.UTC)
+	local := taken.Local()
+	takenMs := strconv.FormatInt(taken.UnixMilli(), 10)
+
+	tests := []struct {
+		name       string
+		dates      bool
+		sourcePath bool
+		meta       string
+		want       []string // arguments exiftool is run with, or nil if it shouldn't be run
+	}{
+		{"off", false, false, `{"CreateDate": ` + takenMs + `}`, nil},
+		{"dates from a fallback tag", true, false, `{"CreateDate": ` + takenMs + `}`, []string{"-DateTimeOriginal=" + local.Format("2006:01:02 15:04:05"),
+			"-OffsetTimeOriginal=" + local.Format("-07:00")}},
+		{"dates already original", true, false, `{"DateTimeOriginal": ` + takenMs + `}`, nil},
+		{"zeroed fallback date", true, false, `{"CreateDate": "0000:00:00 00:00:00"}`, nil},
+		{"source path", false, true, `{"DateTimeOriginal": ` + takenMs + `}`, []string{"-XMP-dc:Source=SOURCE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testFiler(t)
+			f.cfg.Set("write-back-dates", tt.dates)
+			f.cfg.Set("write-back-source-path", tt.sourcePath)
+
+			workDir := t.TempDir()
+			record := filepath.Join(workDir, "exiftool.args")
+			f.exiftoolbin = filepath.Join(workDir, "exiftool")
+			script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > '" + record + "'\ncat >> '" + record + "'\n"
+			if err := os.WriteFile(f.exiftoolbin, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			source := filepath.Join(workDir, "IMG_1234.JPG")
+			if err := os.WriteFile(source, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			planned := PlannedFile{Source: source, Action: ACTION_FILE, Destination: filepath.Join(f.destRootDir, "filed.jpg")}
+			planned.Timestamp, planned.TimestampTag, _ = metadata.Timestamp(gjson.Parse(tt.meta))
+
+			result := f.executeFile(context.Background(), logrus.NewEntry(logrus.New()), planned, false, "")
+			if result.Outcome != OUTCOME_MOVED || result.WriteBackErr != nil {
+				t.Fatalf("executeFile() = %+v, want the file moved and written back", result)
+			}
+
+			recorded, err := os.ReadFile(record)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("exiftool was run with %q, want it not run", recorded)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("exiftool wasn't run. %s", err)
+			}
+
+			var want []string
+			for _, arg := range tt.want {
+				want = append(want, strings.ReplaceAll(arg, "SOURCE", source))
+			}
+			want = append(append([]string{"-overwrite_original", "-preserve", "-quiet"}, want...), "-@", "-", planned.Destination)
+			if got := strings.Split(strings.TrimSuffix(string(recorded), "\n"), "\n"); strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("exiftool was run with %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+/*
+Test_verifyMove makes sure a file that was changed on the way to its destination isn't
+taken for the file that was moved.
+*/
+func Test_verifyMove(t *testing.T) {
+	tests := []struct {
+		name     string
+		copied   string // what was read while copying the file, or empty if it was renamed
+		contents string // written to a new file at the destination, or empty to rename the source
+		wantErr  bool
+	}{
+		{"renamed", "", "", false},
+		{"replaced", "", "image", true},
+		{"copied", "image", "image", false},
+		{"truncated", "image", "imag", true},
+		{"corrupted", "image", "imagf", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testFiler(t)
+			dir := t.TempDir()
+
+			source := filepath.Join(dir, "source.jpg")
+			if err := os.WriteFile(source, []byte("image"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			check, err := f.checkBeforeMove(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			dest := filepath.Join(dir, "dest.jpg")
+			if tt.contents == "" {
+				if err := os.Rename(source, dest); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.WriteFile(dest, []byte(tt.contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.copied != "" {
+				check.copied = true
+				check.hash.Write([]byte(tt.copied))
+			}
+
+			if err := f.verifyMove(check, dest); (err != nil) != tt.wantErr {
+				t.Errorf("verifyMove() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/catalog"
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+)
+
+/*
+queryCommand() recognizes 'query' in the positional arguments, followed by the destination
+directory whose catalog is searched.
+*/
+func queryCommand(args []string) (string, bool) {
+	if len(args) != 2 || args[0] != "query" {
+		return "", false
+	}
+	return args[1], true
+}
+
+/*
+runQuery() searches the catalog of destRootDir for the files matching --serial, --model,
+--year, --timestamp-tag and --mime-type, writing them to out. Returns the exit status for
+the process, which is 1 if nothing was found.
+*/
+func runQuery(cfg *config.Config, out io.Writer, destRootDir string) int {
+	if status := loadCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	path := catalog.Path(cfg.GetString("catalog"), destRootDir)
+	if path == "" {
+		fmt.Fprintln(out, "'catalog' isn't set, so there's no catalog to query")
+		return 2
+	}
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(out, "the catalog can't be read. %s\n", err)
+		return 1
+	}
+
+	cat, err := catalog.Open(path)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	defer cat.Close()
+
+	query := catalog.Query{
+		CameraSerial: cfg.GetString("serial"),
+		Model:        cfg.GetString("model"),
+		MIMEType:     cfg.GetString("mime-type"),
+		TimestampTag: cfg.GetString("timestamp-tag"),
+		Year:         cfg.GetInt("year"),
+	}
+
+	return writeQuery(cat, out, query)
+}
+
+/*
+writeQuery() writes out the entries matching query as a table, oldest first. Files that
+have been moved or removed since they were filed are marked as missing.
+*/
+func writeQuery(cat *catalog.Catalog, out io.Writer, query catalog.Query) int {
+	entries, err := cat.Find(query)
+	if err != nil {
+		fmt.Fprintf(out, "the catalog couldn't be searched. %s\n", err)
+		return 1
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "no files in the catalog match")
+		return 1
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TAKEN\tTAG\tMODEL\tSERIAL\tORIGINAL\tPATH")
+	for _, entry := range entries {
+		path := entry.Path
+		if _, err := os.Stat(path); err != nil {
+			path += " (missing)"
+		}
+		taken := "unknown"
+		if !entry.Taken.IsZero() {
+			taken = entry.Taken.UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", taken, entry.TimestampTag, entry.Model,
+			entry.CameraSerial, entry.OriginalName, path)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "%d file(s)\n", len(entries))
+	return 0
+}
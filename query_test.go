@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/catalog"
+)
+
+func Test_queryCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantDest string
+		wantOk   bool
+	}{
+		{[]string{"query", "/dest"}, "/dest", true},
+		{[]string{"query"}, "", false},
+		{[]string{"query", "/dest", "extra"}, "", false},
+		{[]string{"/src", "/dest"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			dest, ok := queryCommand(tt.args)
+			if dest != tt.wantDest || ok != tt.wantOk {
+				t.Errorf("queryCommand() = '%s', %v, want '%s', %v", dest, ok, tt.wantDest, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_writeQuery(t *testing.T) {
+	cat, err := catalog.Open(filepath.Join(t.TempDir(), "catalog.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cat.Close()
+
+	if err := cat.Put(catalog.Entry{Path: "/nonexistent/a.jpg", OriginalName: "IMG_0001.JPG", Taken: time.Date(2023, 6, 15, 10, 11, 12, 0, time.UTC),
+		TimestampTag: "GPSDateTime", Model: "Pixel 7", CameraSerial: "X1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if status := writeQuery(cat, &out, catalog.Query{TimestampTag: "GPSDateTime"}); status != 0 {
+		t.Fatalf("writeQuery() = %d, wrote:\n%s", status, out.String())
+	}
+
+	want := `TAKEN                     TAG          MODEL    SERIAL  ORIGINAL      PATH
+2023-06-15T10:11:12.000Z  GPSDateTime  Pixel 7  X1      IMG_0001.JPG  /nonexistent/a.jpg (missing)
+1 file(s)
+`
+	if out.String() != want {
+		t.Errorf("writeQuery() wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if status := writeQuery(cat, &out, catalog.Query{Year: 2024}); status != 1 {
+		t.Errorf("writeQuery() = %d with no matches, want 1", status)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/filerbridge"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/d0ct0rvenkman/mediafiler/pkg/filer"
+	"github.com/tidwall/gjson"
+)
+
+/*
+rulesCommand() recognizes 'rules test' in the positional arguments, optionally followed
+by a destination directory used when showing where files would be filed.
+*/
+func rulesCommand(args []string) (string, bool) {
+	if len(args) < 2 || len(args) > 3 || args[0] != "rules" || args[1] != "test" {
+		return "", false
+	}
+
+	if len(args) == 3 {
+		return args[2], true
+	}
+	return "", true
+}
+
+/*
+runRulesTest() shows how the configured rules treat a model (--model), a path (--path)
+and the files described by exiftool JSON output (--exif-json), writing the results to
+out. Returns the exit status for the process.
+*/
+func runRulesTest(cfg *config.Config, out io.Writer, destRootDir string) int {
+	model := cfg.GetString("model")
+	path := cfg.GetString("path")
+	exifJSON := cfg.GetString("exif-json")
+
+	if model == "" && path == "" && exifJSON == "" {
+		fmt.Fprintln(out, "'rules test' needs at least one of --model, --path or --exif-json")
+		return 2
+	}
+
+	if status := loadCommandConfig(cfg, out); status != 0 {
+		return status
+	}
+
+	if destRootDir == "" {
+		destRootDir = "<destDir>"
+	}
+
+	status := 0
+
+	if model != "" {
+		if err := testModel(cfg, out, "", model, nil); err != nil {
+			fmt.Fprintf(out, "model replace rules failed. reason: %s\n", err)
+			status = 1
+		}
+	}
+
+	if path != "" {
+		if _, err := testPath(cfg, out, "", path); err != nil {
+			fmt.Fprintf(out, "path ignore patterns failed. reason: %s\n", err)
+			status = 1
+		}
+	}
+
+	if exifJSON != "" {
+		contents, err := os.ReadFile(exifJSON)
+		if err != nil {
+			fmt.Fprintf(out, "could not read exiftool output. reason: %s\n", err)
+			return 1
+		}
+
+		if !gjson.ValidBytes(contents) {
+			fmt.Fprintf(out, "'%s' doesn't hold valid JSON\n", exifJSON)
+			return 1
+		}
+
+		result := gjson.ParseBytes(contents)
+		files := []gjson.Result{result}
+		if result.IsArray() {
+			files = result.Array()
+		}
+
+		for _, meta := range files {
+			if err := testFile(cfg, out, meta, destRootDir); err != nil {
+				fmt.Fprintf(out, "  error: %s\n", err)
+				status = 1
+			}
+		}
+	}
+
+	return status
+}
+
+/*
+testModel() writes out each step of the model replace rules for model, followed by the
+model used in file names.
+*/
+func testModel(cfg *config.Config, out io.Writer, indent string, model string, tags strmanip.TagLookup) error {
+	fmt.Fprintf(out, "%smodel: '%s'\n", indent, model)
+
+	replaced, steps, err := cfg.ModelReplacer.Trace(model, tags)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		rule := step.Rule
+		fmt.Fprintf(out, "%s  rule %d (%s '%s' -> '%s'", indent, step.Index+1, rule.Type, rule.Find, rule.ReplaceWith)
+		if len(rule.Conditions) > 0 {
+			fmt.Fprintf(out, ", %d condition(s)", len(rule.Conditions))
+		}
+		fmt.Fprintf(out, "): %s", step.Outcome)
+
+		if step.Outcome == strmanip.STEP_REPLACED {
+			fmt.Fprintf(out, " '%s' -> '%s'", step.Input, step.Output)
+			if rule.StopAfterMatch {
+				fmt.Fprint(out, ". stopping")
+			}
+		}
+		fmt.Fprintln(out)
+	}
+
+	if len(steps) == 0 {
+		fmt.Fprintf(out, "%s  no model replace rules are configured\n", indent)
+	}
+
+	specialReplacer := strmanip.NewSpecialReplacer()
+	final, err := specialReplacer.Replace(replaced)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s  => '%s'\n", indent, final)
+	return nil
+}
+
+/*
+testPath() writes out which of the path ignore patterns match path, and whether it's
+ignored. Paths of existing directories are checked with a trailing separator, the same
+way they are while filing.
+*/
+func testPath(cfg *config.Config, out io.Writer, indent string, path string) (bool, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+
+	fmt.Fprintf(out, "%spath: '%s'\n", indent, path)
+
+	ignored := false
+	for idx, pattern := range cfg.PathIgnorer {
+		matched, err := pattern.Matches(path)
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			fmt.Fprintf(out, "%s  pattern %d (%s '%s'): matches\n", indent, idx+1, pattern.Type, pattern.Pattern)
+			ignored = true
+		}
+	}
+
+	if ignored {
+		fmt.Fprintf(out, "%s  => ignored\n", indent)
+	} else {
+		fmt.Fprintf(out, "%s  => not ignored by any of the %d path ignore patterns\n", indent, len(cfg.PathIgnorer))
+	}
+	return ignored, nil
+}
+
+/*
+testFile() writes out how a file described by exiftool metadata would be handled, and
+where it would be filed.
+*/
+func testFile(cfg *config.Config, out io.Writer, meta gjson.Result, destRootDir string) error {
+	sourceFile := meta.Get("SourceFile").String()
+	fmt.Fprintf(out, "file: '%s'\n", sourceFile)
+
+	if sourceFile != "" {
+		if ignored, err := testPath(cfg, out, "  ", sourceFile); err != nil || ignored {
+			return err
+		}
+	}
+
+	// the file may not be on this machine, so fall back to the size exiftool reported
+	size := int64(-1)
+	if info, err := os.Stat(sourceFile); err == nil {
+		size = info.Size()
+	} else if parsed, err := config.ParseSize(meta.Get("FileSize").String()); err == nil {
+		size = parsed
+	}
+
+	if size < 0 {
+		fmt.Fprintln(out, "  size: unknown, so file filters are skipped")
+	} else if skip, filterName := cfg.FileFilters.Skip(metadata.FilterFile(meta, sourceFile, size)); skip {
+		if filterName != "" {
+			fmt.Fprintf(out, "  => skipped by exclude filter '%s'\n", filterName)
+		} else {
+			fmt.Fprintln(out, "  => skipped, since it doesn't match any include filter")
+		}
+		return nil
+	}
+
+	if err := testModel(cfg, out, "  ", metadata.Model(meta), metadata.Tags(meta)); err != nil {
+		return err
+	}
+
+	if mimeType, mimeSubType, err := metadata.SplitMIMEType(meta); err == nil {
+		if _, ok := cfg.MediaTypes.Match(mimeType, mimeSubType); !ok {
+			if unsortedDir := cfg.GetString("unsorted-dir"); unsortedDir != "" {
+				fmt.Fprintf(out, "  => MIME type '%s/%s' isn't configured, so it would be moved to unsorted-dir '%s'\n", mimeType, mimeSubType, unsortedDir)
+				return nil
+			}
+		}
+	}
+
+	// the filer's log is discarded so it doesn't mix with the output, so the fallbacks it
+	// would log are shown here instead
+	if _, tag, parsed := metadata.Timestamp(meta); tag != "" && !parsed {
+		fmt.Fprintf(out, "  timestamp: '%s' (%s) can't be parsed, so it would be filed at the epoch\n", tag, meta.Get(tag).String())
+	} else if tag == "GPSDateTime" {
+		fmt.Fprintln(out, "  timestamp: taken from 'GPSDateTime', which is not necessarily accurate")
+	}
+	if serials := metadata.CameraSerials(meta); len(serials) > 0 {
+		if _, _, ok := cfg.Cameras.Match(serials); !ok {
+			fmt.Fprintf(out, "  camera: serial number '%s' has no name in 'cameras', so the model is used\n", serials[0])
+		}
+	}
+
+	destFile, err := filerbridge.Destination(filerbridge.Wrap(cfg), destRootDir, nil, meta)
+	if err != nil {
+		if reason, ok := filer.ReasonOf(err); ok && reason.IsUnfiled() {
+			fmt.Fprintf(out, "  => cannot be filed (%s). %s\n", reason, err)
+			return nil
+		}
+		return err
+	}
+
+	fmt.Fprintf(out, "  destination: %s\n", destFile)
+	return nil
+}
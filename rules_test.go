@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/d0ct0rvenkman/mediafiler/internal/config"
+	"github.com/d0ct0rvenkman/mediafiler/internal/metadata"
+	"github.com/d0ct0rvenkman/mediafiler/internal/nametmpl"
+	"github.com/d0ct0rvenkman/mediafiler/internal/strmanip"
+	"github.com/tidwall/gjson"
+)
+
+func Test_rulesCommand(t *testing.T) {
+	tests := []struct {
+		args     []string
+		wantDest string
+		wantOk   bool
+	}{
+		{[]string{"rules", "test"}, "", true},
+		{[]string{"rules", "test", "/dest"}, "/dest", true},
+		{[]string{"rules"}, "", false},
+		{[]string{"rules", "check"}, "", false},
+		{[]string{"rules", "test", "/dest", "extra"}, "", false},
+		{[]string{"/src", "/dest"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			dest, ok := rulesCommand(tt.args)
+			if dest != tt.wantDest || ok != tt.wantOk {
+				t.Errorf("rulesCommand() = '%s', %v, want '%s', %v", dest, ok, tt.wantDest, tt.wantOk)
+			}
+		})
+	}
+}
+
+/*
+testRulesConfig returns a configuration with model replace rules and path ignore patterns
+for a test.
+*/
+func testRulesConfig(t *testing.T) *config.Config {
+	cfg, err := config.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.ModelReplacer.AddRule(strmanip.ReplacerRule{Type: "string", Find: "Canon EOS Rebel T7i", ReplaceWith: "Canon800D", StopAfterMatch: true,
+		Conditions: []strmanip.ReplacerCondition{{Tag: "Make", Type: "string", Pattern: "Canon"}}})
+	cfg.ModelReplacer.AddRule(strmanip.ReplacerRule{Type: "regex", Find: `\s+`, ReplaceWith: ""})
+
+	cfg.PathIgnorer.AddPattern(config.PathIgnorePattern{Type: "gitignore", Pattern: ".git/"})
+	cfg.PathIgnorer.AddPattern(config.PathIgnorePattern{Type: "glob", Pattern: "**/*.tmp"})
+
+	if cfg.MediaTypes, err = config.DefaultMediaTypeRouter(nametmpl.DefaultPathTemplate); err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func Test_testModel(t *testing.T) {
+	cfg := testRulesConfig(t)
+
+	var out strings.Builder
+	if err := testModel(cfg, &out, "", "Canon EOS Rebel T7i", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `model: 'Canon EOS Rebel T7i'
+  rule 1 (string 'Canon EOS Rebel T7i' -> 'Canon800D', 1 condition(s)): conditions not met
+  rule 2 (regex '\s+' -> ''): replaced 'Canon EOS Rebel T7i' -> 'CanonEOSRebelT7i'
+  => 'CanonEOSRebelT7i'
+`
+	if out.String() != want {
+		t.Errorf("testModel() wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	tags := metadata.Tags(gjson.Parse(`{"Make": "Canon"}`))
+	if err := testModel(cfg, &out, "", "Canon EOS Rebel T7i", tags); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out.String(), "replaced 'Canon EOS Rebel T7i' -> 'Canon800D'. stopping\n  => 'Canon800D'") {
+		t.Errorf("testModel() didn't stop after the matching rule:\n%s", out.String())
+	}
+}
+
+func Test_testPath(t *testing.T) {
+	cfg := testRulesConfig(t)
+
+	tests := []struct {
+		path        string
+		wantIgnored bool
+		wantPattern string
+	}{
+		{"/srv/photos/.git/config", true, "pattern 1 (gitignore '.git/'): matches"},
+		{"/srv/photos/upload.tmp", true, "pattern 2 (glob '**/*.tmp'): matches"},
+		{"/srv/photos/IMG_0001.JPG", false, "not ignored by any of the 2 path ignore patterns"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var out strings.Builder
+			ignored, err := testPath(cfg, &out, "", tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ignored != tt.wantIgnored || !strings.Contains(out.String(), tt.wantPattern) {
+				t.Errorf("testPath() = %v, wrote:\n%s\nwant %v and '%s'", ignored, out.String(), tt.wantIgnored, tt.wantPattern)
+			}
+		})
+	}
+}
+
+func Test_testFile(t *testing.T) {
+	cfg := testRulesConfig(t)
+
+	meta := gjson.Parse(`{"SourceFile": "/nonexistent/IMG_0001.JPG", "FileSize": "2.3 MB", "MIMEType": "image/jpeg",
+		"FileTypeExtension": "JPG", "Make": "Canon", "Model": "Canon EOS Rebel T7i", "DateTimeOriginal": "2024:06:15 12:00:00"}`)
+
+	var out strings.Builder
+	if err := testFile(cfg, &out, meta, "/dest"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "  destination: /dest/image/jpeg/2024/06/20240615T120000.000Z-Canon800D.jpg\n"
+	if !strings.HasSuffix(out.String(), want) {
+		t.Errorf("testFile() wrote:\n%s\nwant it to end with:\n%s", out.String(), want)
+	}
+}
+
+/*
+Test_testFile_Fallbacks makes sure the fallbacks logged while filing are shown in the
+output of 'rules test', since the filer's log is discarded.
+*/
+func Test_testFile_Fallbacks(t *testing.T) {
+	cfg := testRulesConfig(t)
+
+	tests := []struct {
+		name string
+		meta string
+		want string
+	}{
+		{"zeroed timestamp", `{"CreateDate": "0000:00:00 00:00:00", "ModifyDate": "2024:06:15 12:00:00"}`,
+			"  timestamp: 'CreateDate' (0000:00:00 00:00:00) can't be parsed, so it would be filed at the epoch\n"},
+		{"GPS timestamp", `{"GPSDateTime": "2024:06:15 12:00:00Z"}`,
+			"  timestamp: taken from 'GPSDateTime', which is not necessarily accurate\n"},
+		{"unknown camera", `{"DateTimeOriginal": "2024:06:15 12:00:00", "SerialNumber": "099999"}`,
+			"  camera: serial number '099999' has no name in 'cameras', so the model is used\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := gjson.Parse(`{"SourceFile": "/nonexistent/IMG_0001.JPG", "FileSize": "2.3 MB", "MIMEType": "image/jpeg",
+				"FileTypeExtension": "JPG", "Model": "Canon EOS Rebel T7i", ` + tt.meta[1:])
+
+			var out strings.Builder
+			if err := testFile(cfg, &out, meta, "/dest"); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("testFile() wrote:\n%s\nwant it to contain:\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}